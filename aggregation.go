@@ -0,0 +1,200 @@
+package streedb
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidStep        = errors.New("the step must be greater than 0")
+	ErrUnknownAggregation = errors.New("unknown aggregation")
+	ErrNotAggregatable    = errors.New("the values of the entry can't be aggregated")
+)
+
+// Sampler is implemented by entries whose values are numbers, so that they can be aggregated
+type Sampler interface {
+	// Sample returns the timestamp, in unix milliseconds, and the value at position i
+	Sample(i int) (int64, float64)
+}
+
+// Aggregation reduces the values of a bucket to a single one
+type Aggregation struct {
+	Name string
+	fn   func(s samples) (float64, bool)
+}
+
+// samples holds the values of a bucket sorted by timestamp. When the previous step has values, the
+// last of them is at position 0, so that the functions over counters see the change between steps.
+type samples struct {
+	ts     []int64
+	values []float64
+	// from is the position of the first value of the bucket, 1 when the previous value is present
+	from int
+}
+
+func (s samples) own() []float64 {
+	return s.values[s.from:]
+}
+
+// always wraps the functions that give a result for any number of values
+func always(fn func(values []float64) float64) func(s samples) (float64, bool) {
+	return func(s samples) (float64, bool) { return fn(s.own()), true }
+}
+
+var aggregations = map[string]func(s samples) (float64, bool){
+	"min":   always(slices.Min[[]float64]),
+	"max":   always(slices.Max[[]float64]),
+	"sum":   always(sum),
+	"avg":   always(func(values []float64) float64 { return sum(values) / float64(len(values)) }),
+	"count": always(func(values []float64) float64 { return float64(len(values)) }),
+	"first": always(func(values []float64) float64 { return values[0] }),
+	"last":  always(func(values []float64) float64 { return values[len(values)-1] }),
+
+	"delta":    delta,
+	"increase": increase,
+	"rate":     rate,
+	"irate":    irate,
+}
+
+// ParseAggregation returns the aggregation with the given name: min, max, sum, avg, count, first,
+// last, a percentile like p50 or p99.9, or one of the functions over counters: delta, increase,
+// rate and irate. See counters.go
+func ParseAggregation(name string) (Aggregation, error) {
+	if fn, ok := aggregations[name]; ok {
+		return Aggregation{Name: name, fn: fn}, nil
+	}
+
+	if p, found := strings.CutPrefix(name, "p"); found {
+		q, err := strconv.ParseFloat(p, 64)
+		if err == nil && q >= 0 && q <= 100 {
+			return Aggregation{Name: name, fn: always(func(values []float64) float64 { return percentile(values, q) })}, nil
+		}
+	}
+
+	return Aggregation{}, fmt.Errorf("%w '%s'", ErrUnknownAggregation, name)
+}
+
+func sum(values []float64) float64 {
+	res := 0.0
+	for _, v := range values {
+		res += v
+	}
+	return res
+}
+
+// percentile interpolates linearly between the closest ranks, q goes from 0 to 100
+func percentile(values []float64, q float64) float64 {
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+
+	rank := q / 100 * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+
+	return sorted[lower] + (sorted[upper]-sorted[lower])*(rank-float64(lower))
+}
+
+// Bucket holds the result of every aggregation over the values of one step. Ts is the start of the
+// step. Aggregations without a result, like the rate of a single value, are left out.
+type Bucket struct {
+	Ts     int64              `json:"ts"`
+	Values map[string]float64 `json:"values"`
+}
+
+type DownsampledSeries struct {
+	PrimaryIdx   string   `json:"primary_index"`
+	SecondaryIdx string   `json:"secondary_index"`
+	Buckets      []Bucket `json:"buckets"`
+}
+
+// Downsample groups the values of every series in steps of stepMs milliseconds, aligned to the unix
+// epoch, and computes the aggregations over each of them. Steps without values are omitted. The
+// iterator must return every series once with its values in ascending order, like LsmTree.Find
+// does, and its entries must implement Sampler. The iterator is closed.
+func Downsample[O cmp.Ordered](iter EntryIterator[O], stepMs int64, aggs ...Aggregation) ([]*DownsampledSeries, error) {
+	defer iter.Close()
+
+	if stepMs <= 0 {
+		return nil, ErrInvalidStep
+	}
+
+	res := make([]*DownsampledSeries, 0)
+	for {
+		entry, found, err := iter.Next()
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			return res, nil
+		}
+
+		sampler, ok := entry.(Sampler)
+		if !ok {
+			return nil, fmt.Errorf("%w: %T", ErrNotAggregatable, entry)
+		}
+
+		res = append(res, &DownsampledSeries{
+			PrimaryIdx:   entry.PrimaryIndex(),
+			SecondaryIdx: entry.SecondaryIndex(),
+			Buckets:      downsampleSeries(sampler, entry.Len(), stepMs, aggs),
+		})
+	}
+}
+
+func downsampleSeries(sampler Sampler, n int, stepMs int64, aggs []Aggregation) []Bucket {
+	buckets := make([]Bucket, 0)
+	bucket := samples{ts: make([]int64, 0), values: make([]float64, 0)}
+
+	flush := func(start int64) {
+		if len(bucket.own()) == 0 {
+			return
+		}
+
+		res := Bucket{Ts: start, Values: make(map[string]float64, len(aggs))}
+		for _, agg := range aggs {
+			if v, ok := agg.fn(bucket); ok {
+				res.Values[agg.Name] = v
+			}
+		}
+		buckets = append(buckets, res)
+
+		// the last value is kept for the next step, if it comes right after this one
+		last := len(bucket.values) - 1
+		bucket.ts[0], bucket.values[0] = bucket.ts[last], bucket.values[last]
+		bucket.ts, bucket.values, bucket.from = bucket.ts[:1], bucket.values[:1], 1
+	}
+
+	current := int64(0)
+	for i := 0; i < n; i++ {
+		t, v := sampler.Sample(i)
+
+		start := bucketStart(t, stepMs)
+		if start != current {
+			flush(current)
+			if start != current+stepMs {
+				bucket.ts, bucket.values, bucket.from = bucket.ts[:0], bucket.values[:0], 0
+			}
+			current = start
+		}
+
+		bucket.ts = append(bucket.ts, t)
+		bucket.values = append(bucket.values, v)
+	}
+	flush(current)
+
+	return buckets
+}
+
+// bucketStart rounds ts down to a multiple of stepMs, also for timestamps before the epoch
+func bucketStart(ts, stepMs int64) int64 {
+	start := ts - ts%stepMs
+	if start > ts {
+		start -= stepMs
+	}
+	return start
+}
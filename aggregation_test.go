@@ -0,0 +1,67 @@
+package streedb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAggregation(t *testing.T) {
+	for _, name := range []string{"min", "max", "sum", "avg", "count", "first", "last", "p0", "p50", "p99.9", "p100"} {
+		agg, err := ParseAggregation(name)
+		require.NoError(t, err, name)
+		assert.Equal(t, name, agg.Name)
+	}
+
+	for _, name := range []string{"", "median", "p", "p101", "p-1"} {
+		_, err := ParseAggregation(name)
+		assert.ErrorIs(t, err, ErrUnknownAggregation, name)
+	}
+}
+
+func TestDownsample(t *testing.T) {
+	aggs := make([]Aggregation, 0)
+	for _, name := range []string{"min", "max", "sum", "avg", "count", "first", "last", "p50"} {
+		agg, err := ParseAggregation(name)
+		require.NoError(t, err)
+		aggs = append(aggs, agg)
+	}
+
+	iter := NewListIterator([]Entry[int64]{
+		// the step from 60 to 120 has no values
+		NewKv("instance1", "cpu", []int64{-10, 0, 10, 59, 120, 130}, []int32{7, 4, 1, 3, 5, 6}),
+		NewKv("instance1", "mem", []int64{1}, []int32{2}),
+	})
+
+	series, err := Downsample(iter, 60, aggs...)
+	require.NoError(t, err)
+	require.Len(t, series, 2)
+
+	assert.Equal(t, "instance1", series[0].PrimaryIdx)
+	assert.Equal(t, "cpu", series[0].SecondaryIdx)
+	assert.Equal(t, []Bucket{
+		{Ts: -60, Values: map[string]float64{"min": 7, "max": 7, "sum": 7, "avg": 7, "count": 1, "first": 7, "last": 7, "p50": 7}},
+		{Ts: 0, Values: map[string]float64{"min": 1, "max": 4, "sum": 8, "avg": 8.0 / 3, "count": 3, "first": 4, "last": 3, "p50": 3}},
+		{Ts: 120, Values: map[string]float64{"min": 5, "max": 6, "sum": 11, "avg": 5.5, "count": 2, "first": 5, "last": 6, "p50": 5.5}},
+	}, series[0].Buckets)
+
+	assert.Equal(t, "mem", series[1].SecondaryIdx)
+	assert.Equal(t, []Bucket{
+		{Ts: 0, Values: map[string]float64{"min": 2, "max": 2, "sum": 2, "avg": 2, "count": 1, "first": 2, "last": 2, "p50": 2}},
+	}, series[1].Buckets)
+
+	_, err = Downsample(NewListIterator([]Entry[int64]{}), 0, aggs...)
+	assert.ErrorIs(t, err, ErrInvalidStep)
+}
+
+func TestPercentile(t *testing.T) {
+	values := []float64{4, 1, 3, 2}
+
+	assert.Equal(t, 1.0, percentile(values, 0))
+	assert.Equal(t, 2.5, percentile(values, 50))
+	assert.Equal(t, 4.0, percentile(values, 100))
+	assert.InDelta(t, 3.97, percentile(values, 99), 1e-9)
+	// the values are not sorted in place
+	assert.Equal(t, []float64{4, 1, 3, 2}, values)
+}
@@ -0,0 +1,78 @@
+package streedb
+
+import (
+	"cmp"
+	"math"
+
+	"github.com/spaolacci/murmur3"
+)
+
+// BloomFilter tells if a key may have been added to it, or if it wasn't for sure. It's stored in the
+// metadata of the fileblocks with their secondary indexes and labels, so that fileblocks that don't
+// hold a series can be skipped without scanning their rows.
+type BloomFilter struct {
+	Bits   []byte
+	Hashes int
+}
+
+// NewBloomFilter returns a filter for n keys with a false positive rate of p
+func NewBloomFilter(n int, p float64) *BloomFilter {
+	n = max(n, 1)
+	bits := math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2))
+	bytes := max(int(math.Ceil(bits/8)), 1)
+	hashes := int(math.Round(float64(bytes*8) / float64(n) * math.Ln2))
+
+	return &BloomFilter{Bits: make([]byte, bytes), Hashes: max(hashes, 1)}
+}
+
+func (b *BloomFilter) Add(key string) {
+	h1, h2 := murmur3.Sum128([]byte(key))
+	for i := 0; i < b.Hashes; i++ {
+		bit := b.bit(h1, h2, i)
+		b.Bits[bit/8] |= 1 << (bit % 8)
+	}
+}
+
+// Contains returns false if the key wasn't added for sure
+func (b *BloomFilter) Contains(key string) bool {
+	h1, h2 := murmur3.Sum128([]byte(key))
+	for i := 0; i < b.Hashes; i++ {
+		bit := b.bit(h1, h2, i)
+		if b.Bits[bit/8]&(1<<(bit%8)) == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// bit returns the i-th position of a key by double hashing
+func (b *BloomFilter) bit(h1, h2 uint64, i int) uint64 {
+	return (h1 + uint64(i)*h2) % uint64(len(b.Bits)*8)
+}
+
+// MembershipFilter is implemented by filters that only pass the series with a key, so that the
+// fileblocks that don't hold it can be pruned with their BloomFilter
+type MembershipFilter interface {
+	// MembershipKey returns the key, false when the filter can pass series without it
+	MembershipKey() (string, bool)
+}
+
+func secondaryIndexKey(sIdx string) string {
+	return "s\x00" + sIdx
+}
+
+func labelKey(name, value string) string {
+	return "l\x00" + name + "\x00" + value
+}
+
+// rowKeys returns the keys of the row in the BloomFilter of its fileblock
+func rowKeys[O cmp.Ordered](row *Row[O]) []string {
+	keys := make([]string, 0, len(row.Labels)+1)
+	keys = append(keys, secondaryIndexKey(row.SecondaryIdx))
+	for _, label := range row.Labels {
+		keys = append(keys, labelKey(label.Name, label.Value))
+	}
+
+	return keys
+}
@@ -0,0 +1,85 @@
+package streedb
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBloomFilter(t *testing.T) {
+	b := NewBloomFilter(1000, 0.01)
+	for i := 0; i < 1000; i++ {
+		b.Add("in" + strconv.Itoa(i))
+	}
+
+	for i := 0; i < 1000; i++ {
+		require.True(t, b.Contains("in"+strconv.Itoa(i)))
+	}
+
+	falsePositives := 0
+	for i := 0; i < 10000; i++ {
+		if b.Contains("out" + strconv.Itoa(i)) {
+			falsePositives++
+		}
+	}
+	assert.Less(t, falsePositives, 300)
+
+	// it's stored in the metadata
+	byt, err := json.Marshal(b)
+	require.NoError(t, err)
+	var res BloomFilter
+	require.NoError(t, json.Unmarshal(byt, &res))
+	assert.Equal(t, *b, res)
+}
+
+func TestMetadataBuilderBloom(t *testing.T) {
+	builder := NewMetadataBuilder[int64](NewDefaultConfig())
+	// enough series to rebuild the filter
+	for i := 0; i < 100; i++ {
+		builder.WithEntry(NewKv("instance1", "cpu"+strconv.Itoa(i), []int64{1}, []int32{1}))
+	}
+	us, err := NewLabels(map[string]string{"host": "a", "region": "us"})
+	require.NoError(t, err)
+	builder.WithEntry(NewLabeledEntry("instance1", us, []int64{1}, []float64{1}))
+
+	fb := NewFileblock(NewDefaultConfig(), &builder.MetaFile, nil)
+	require.NotNil(t, fb.Bloom)
+	for i := 0; i < 100; i++ {
+		require.True(t, fb.Matches(SecondaryIndexFilter[int64]("cpu"+strconv.Itoa(i))))
+	}
+	assert.False(t, fb.Matches(SecondaryIndexFilter[int64]("mem")))
+
+	exact, err := ParseFilter(SecondaryIndexFilterKind, "cpu1")
+	require.NoError(t, err)
+	assert.True(t, fb.Matches(exact))
+
+	host, err := LabelFilter("host", "a")
+	require.NoError(t, err)
+	assert.True(t, fb.Matches(host))
+
+	// the rows aren't scanned when the bloom filter doesn't have the series
+	fb.Rows = append(fb.Rows, Row[int64]{SecondaryIdx: "mem"})
+	assert.False(t, fb.Matches(SecondaryIndexFilter[int64]("mem")))
+	fb.Rows[len(fb.Rows)-1].Labels = Labels{{Name: "host", Value: "b"}}
+	host, err = LabelFilter("host", "b")
+	require.NoError(t, err)
+	assert.False(t, fb.Matches(host))
+
+	// filters that can match more than a value don't use it
+	prefix, err := ParseFilter(SecondaryIndexFilterKind, "prefix:me")
+	require.NoError(t, err)
+	assert.True(t, fb.Matches(prefix))
+	host, err = LabelFilter("host", "!a")
+	require.NoError(t, err)
+	assert.True(t, fb.Matches(host))
+
+	t.Run("Disabled", func(t *testing.T) {
+		cfg := NewDefaultConfig()
+		cfg.Metadata.BloomFalsePositiveRate = 0
+		builder := NewMetadataBuilder[int64](cfg).WithEntry(NewKv("instance1", "cpu", []int64{1}, []int32{1}))
+		assert.Nil(t, builder.Bloom)
+	})
+}
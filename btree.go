@@ -2,6 +2,8 @@ package streedb
 
 import (
 	"cmp"
+	"context"
+	"strings"
 
 	"github.com/google/btree"
 )
@@ -60,6 +62,10 @@ func (p *secondaryIndexFilter[O]) Kind() EntryFilterKind {
 	return SecondaryIndexFilterKind
 }
 
+func (p *secondaryIndexFilter[O]) MembershipKey() (string, bool) {
+	return secondaryIndexKey(p.sIdx), p.sIdx != ""
+}
+
 func LLFComp[O cmp.Ordered, I cmp.Ordered](a, b *BtreeItem[O, I]) bool {
 	return a.Key < b.Key
 }
@@ -100,27 +106,51 @@ func (b *BtreeIndex[O, I]) Upsert(key I, value *Fileblock[O]) bool {
 	return true
 }
 
-func (b *BtreeIndex[O, I]) AscendRangeWithFilters(min, max I, filters ...EntryFilter) (EntryIterator[O], bool, error) {
-	pFilters := make([]EntryFilter, 0)
-	for _, filter := range filters {
-		if filter.Kind() == PrimaryIndexFilterKind {
-			pFilters = append(pFilters, filter)
-		}
+// AscendRangeWithFilters returns an iterator over the entries of the matching fileblocks with keys
+// between min, included, and max. It stops with the error of the context if it's cancelled.
+//
+// Deprecated: fileblocks that start before min are missed, use IntervalIndex.AscendRangeWithFilters.
+func (b *BtreeIndex[O, I]) AscendRangeWithFilters(ctx context.Context, min, max I, filters ...EntryFilter) (EntryIterator[O], bool, error) {
+	result, found, err := b.ascendRangeWithFilters(min, max, filters...)
+	if err != nil {
+		return nil, false, err
 	}
 
-	// When no primary index filter is provided, we provice a mock one
-	if len(pFilters) == 0 {
-		pFilters = append(pFilters, AlwaysTrueIndexFilter())
-	}
+	first, last := valueRange(result)
+	return newIteratorWithFilters(ctx, result, first, last, false, filters), found, nil
+}
 
-	result, found, err := b.ascendRangeWithFilters(min, max, pFilters...)
+// DescendRangeWithFilters is AscendRangeWithFilters with the entries of every series in descending
+// order of their max value, so the fileblocks with its newest values are read first. Keys are
+// between max and min, both included.
+//
+// Deprecated: fileblocks that start before min are missed, use IntervalIndex.DescendRangeWithFilters.
+func (b *BtreeIndex[O, I]) DescendRangeWithFilters(ctx context.Context, max, min I, filters ...EntryFilter) (EntryIterator[O], bool, error) {
+	result, found, err := b.descendRangeWithFilters(max, min, filters...)
 	if err != nil {
 		return nil, false, err
 	}
 
-	return newIteratorWithFilters(result, filters), found, nil
+	first, last := valueRange(result)
+	return newIteratorWithFilters(ctx, result, first, last, true, filters), found, nil
 }
 
+// valueRange returns the lowest min and the greatest max of the fileblocks. Keys aren't values, so
+// every value of the fileblocks found by key is read.
+func valueRange[O cmp.Ordered](blocks []*Fileblock[O]) (first, last O) {
+	for i, fb := range blocks {
+		if i == 0 || *fb.Min < first {
+			first = *fb.Min
+		}
+		if i == 0 || *fb.Max > last {
+			last = *fb.Max
+		}
+	}
+
+	return first, last
+}
+
+// ascendRangeWithFilters returns the matching fileblocks pinned, the caller must release them.
 func (b *BtreeIndex[O, I]) ascendRangeWithFilters(min, max I, filters ...EntryFilter) ([]*Fileblock[O], bool, error) {
 	result := make([]*Fileblock[O], 0)
 
@@ -128,22 +158,76 @@ func (b *BtreeIndex[O, I]) ascendRangeWithFilters(min, max I, filters ...EntryFi
 		&BtreeItem[O, I]{Key: min},
 		&BtreeItem[O, I]{Key: max},
 		func(item *BtreeItem[O, I]) bool {
-			for next := item.Val.head; next != nil; next = next.Next {
-				fileblock := next.Val
-				for _, filter := range filters {
-					if filter.Filter(fileblock) {
-						result = append(result, fileblock)
-						continue
-					}
-				}
-			}
+			result = acquireMatching(result, item, filters)
+			return true
+		})
+
+	return result, len(result) > 0, nil
+}
+
+// descendRangeWithFilters returns the matching fileblocks pinned, the caller must release them.
+func (b *BtreeIndex[O, I]) descendRangeWithFilters(max, min I, filters ...EntryFilter) ([]*Fileblock[O], bool, error) {
+	result := make([]*Fileblock[O], 0)
 
+	b.DescendLessOrEqual(
+		&BtreeItem[O, I]{Key: max},
+		func(item *BtreeItem[O, I]) bool {
+			if item.Key < min {
+				return false
+			}
+			result = acquireMatching(result, item, filters)
 			return true
 		})
 
 	return result, len(result) > 0, nil
 }
 
+// acquireMatching appends the fileblocks of the item that match the filters, pinned until the
+// iterator has loaded them. See Fileblock.Matches
+func acquireMatching[O, I cmp.Ordered](result []*Fileblock[O], item *BtreeItem[O, I], filters []EntryFilter) []*Fileblock[O] {
+	for next := item.Val.head; next != nil; next = next.Next {
+		fileblock := next.Val
+		if fileblock.Matches(filters...) && fileblock.Acquire() {
+			result = append(result, fileblock)
+		}
+	}
+
+	return result
+}
+
+// AscendPrefixWithFilters is AscendRangeWithFilters over an index of primary indexes, reading only
+// the ones that start with prefix. See AscendFileblocksWithFilters.
+func AscendPrefixWithFilters[O cmp.Ordered](ctx context.Context, index *BtreeIndex[O, string], prefix string, min, max O, filters ...EntryFilter) (EntryIterator[O], bool, error) {
+	blocks := make([]*Fileblock[O], 0)
+
+	index.AscendGreaterOrEqual(&BtreeItem[O, string]{Key: prefix}, func(item *BtreeItem[O, string]) bool {
+		if !strings.HasPrefix(item.Key, prefix) {
+			return false
+		}
+
+		item.Val.Each(func(fb *Fileblock[O]) bool {
+			blocks = append(blocks, fb)
+			return true
+		})
+		return true
+	})
+
+	return AscendFileblocksWithFilters(ctx, blocks, min, max, filters...)
+}
+
+// AscendFileblocksWithFilters returns an iterator over the entries of the fileblocks with values
+// between min and max, both included, that match the filters. See IntervalIndex.AscendRangeWithFilters
+func AscendFileblocksWithFilters[O cmp.Ordered](ctx context.Context, blocks []*Fileblock[O], min, max O, filters ...EntryFilter) (EntryIterator[O], bool, error) {
+	result := make([]*Fileblock[O], 0)
+	for _, fileblock := range blocks {
+		if *fileblock.Min <= max && min <= *fileblock.Max && fileblock.MatchesRange(min, max, filters...) && fileblock.Acquire() {
+			result = append(result, fileblock)
+		}
+	}
+
+	return newIteratorWithFilters(ctx, result, min, max, false, filters), len(result) > 0, nil
+}
+
 func (b *BtreeIndex[O, I]) ascendRange(pIdx, sIdx string, min, max I) ([]*Fileblock[O], bool, error) {
 	result := make([]*Fileblock[O], 0)
 
@@ -9,8 +9,10 @@ import (
 )
 
 type mockFilesystem[O cmp.Ordered] struct {
-	emap  *EntriesMap[O]
-	extra struct {
+	emap      *EntriesMap[O]
+	loadErr   error
+	removeErr error
+	extra     struct {
 		create               int
 		fillMetadataBuilder  int
 		load                 int
@@ -43,7 +45,7 @@ func (m *mockFilesystem[O]) FillMetadataBuilder(meta *MetadataBuilder[O]) *Metad
 }
 func (m *mockFilesystem[O]) Load(*Fileblock[O]) (*EntriesMap[O], error) {
 	m.extra.load++
-	return m.emap, nil
+	return m.emap, m.loadErr
 }
 func (m *mockFilesystem[O]) OpenMetaFilesInLevel([]FileblockListener[O]) error {
 	m.extra.openMetaFilesInLevel++
@@ -51,7 +53,7 @@ func (m *mockFilesystem[O]) OpenMetaFilesInLevel([]FileblockListener[O]) error {
 }
 func (m *mockFilesystem[O]) Remove(*Fileblock[O], []FileblockListener[O]) error {
 	m.extra.remove++
-	return nil
+	return m.removeErr
 }
 func (m *mockFilesystem[O]) UpdateMetadata(*Fileblock[O]) error {
 	m.extra.updateMetadata++
@@ -23,6 +23,8 @@ func cleanSampleName(s string) string {
 
 func main() {
 	cfg := db.NewDefaultConfig()
+	cfg.Wal.Persistent = true
+	cfg.Wal.Flushers = 2
 	coreDb, err := core.NewLsmTree[int64, *db.Kv](cfg)
 	if err != nil {
 		panic(err)
@@ -80,6 +82,8 @@ func main() {
 	router := gin.Default()
 
 	router.GET("/ping", metricsServer.Ping)
+	// /api/query would hide a primary index named query, the underscore keeps it apart from real names
+	router.GET("/api/_query", metricsServer.GETQuery)
 	router.GET("/api/metrics", metricsServer.GETMetricsAPI)
 	router.GET("/api/metrics/:pIdx", metricsServer.GETMetricsAPI)
 	router.GET("/api/metrics/:pIdx/:sIdx", metricsServer.GETMetricsAPI)
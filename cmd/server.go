@@ -2,7 +2,13 @@ package main
 
 import (
 	"cmp"
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	db "github.com/sayden/streedb"
@@ -12,6 +18,50 @@ import (
 type FromTo[O cmp.Ordered] struct {
 	From O `json:"from"`
 	To   O `json:"to"`
+
+	// Limit pages the results, the response carries the cursor of the next page
+	Limit  int    `json:"limit" form:"limit"`
+	Cursor string `json:"cursor" form:"cursor"`
+
+	// Step downsamples the results, like 60s or 60000 milliseconds, computing every aggregation in
+	// Aggregations over each step, like avg, p99 or rate for counters. Aggregations can be repeated
+	// or comma separated, avg by default
+	Step         string   `json:"step" form:"step"`
+	Aggregations []string `json:"agg" form:"agg"`
+}
+
+// downsampling parses the step and the aggregations of the query
+func (f *FromTo[O]) downsampling() (int64, []db.Aggregation, error) {
+	stepMs, err := strconv.ParseInt(f.Step, 10, 64)
+	if err != nil {
+		step, err := time.ParseDuration(f.Step)
+		if err != nil {
+			return 0, nil, errors.Join(db.ErrInvalidStep, err)
+		}
+		stepMs = step.Milliseconds()
+	}
+	if stepMs <= 0 {
+		return 0, nil, db.ErrInvalidStep
+	}
+
+	names := make([]string, 0, len(f.Aggregations))
+	for _, agg := range f.Aggregations {
+		names = append(names, strings.Split(agg, ",")...)
+	}
+	if len(names) == 0 {
+		names = append(names, "avg")
+	}
+
+	aggs := make([]db.Aggregation, 0, len(names))
+	for _, name := range names {
+		agg, err := db.ParseAggregation(strings.TrimSpace(name))
+		if err != nil {
+			return 0, nil, err
+		}
+		aggs = append(aggs, agg)
+	}
+
+	return stepMs, aggs, nil
 }
 
 type ServerMetrics[O cmp.Ordered, E db.Entry[O]] struct {
@@ -25,13 +75,32 @@ func (s *ServerMetrics[O, _]) GETPrimaryAndSecondaryIndex(c *gin.Context) {
 	fromTo := FromTo[O]{}
 	if err := c.ShouldBindQuery(&fromTo); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 
 	// FIXME: This is a hack to get the min and max values
 	min := fromTo.From
 	max := fromTo.To
 
-	iter, found, err := s.db.Find(pIdx, sIdx, min, max)
+	if fromTo.Limit > 0 {
+		page, next, err := s.db.FindPage(c.Request.Context(), pIdx, sIdx, min, max, fromTo.Limit, fromTo.Cursor)
+		writePage(c, page, next, err, pIdx, sIdx, min, max)
+		return
+	}
+
+	if fromTo.Step != "" {
+		stepMs, aggs, err := fromTo.downsampling()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "primary_index": pIdx, "secondary_index": sIdx, "from": min, "to": max})
+			return
+		}
+
+		iter, found, err := s.db.Find(c.Request.Context(), pIdx, sIdx, min, max)
+		writeDownsampled(c, iter, found, err, stepMs, aggs, pIdx, sIdx, min, max)
+		return
+	}
+
+	iter, found, err := s.db.Find(c.Request.Context(), pIdx, sIdx, min, max)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "primary_index": pIdx, "secondary_index": sIdx, "from": min, "to": max})
 		return
@@ -43,9 +112,10 @@ func (s *ServerMetrics[O, _]) GETPrimaryAndSecondaryIndex(c *gin.Context) {
 	}
 
 	// Accumulate the metrics using the iterator
-	em := db.NewEntriesMap[O]()
-	for entry, found, err := iter.Next(); entry != nil && found && err == nil; entry, found, err = iter.Next() {
-		em.Append(entry)
+	em, err := collect(iter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "primary_index": pIdx, "secondary_index": sIdx, "from": min, "to": max})
+		return
 	}
 
 	if sIdx == "" {
@@ -63,12 +133,31 @@ func (s *ServerMetrics[O, _]) GETMetricsAPI(c *gin.Context) {
 	fromTo := FromTo[int64]{}
 	if err := c.ShouldBindQuery(&fromTo); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 
 	min := fromTo.From
 	max := fromTo.To
 
-	iter, found, err := s.db.Metrics.Find(pIdx, sIdx, min, max)
+	if fromTo.Limit > 0 {
+		page, next, err := s.db.Metrics.FindPage(c.Request.Context(), pIdx, sIdx, min, max, fromTo.Limit, fromTo.Cursor)
+		writePage(c, page, next, err, pIdx, sIdx, min, max)
+		return
+	}
+
+	if fromTo.Step != "" {
+		stepMs, aggs, err := fromTo.downsampling()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "primary_index": pIdx, "secondary_index": sIdx, "from": min, "to": max})
+			return
+		}
+
+		iter, found, err := s.db.Metrics.Find(c.Request.Context(), pIdx, sIdx, min, max)
+		writeDownsampled(c, iter, found, err, stepMs, aggs, pIdx, sIdx, min, max)
+		return
+	}
+
+	iter, found, err := s.db.Metrics.Find(c.Request.Context(), pIdx, sIdx, min, max)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "primary_index": pIdx, "secondary_index": sIdx, "from": min, "to": max})
 		return
@@ -79,9 +168,10 @@ func (s *ServerMetrics[O, _]) GETMetricsAPI(c *gin.Context) {
 		return
 	}
 
-	em := db.NewEntriesMap[int64]()
-	for entry, found, err := iter.Next(); entry != nil && found && err == nil; entry, found, err = iter.Next() {
-		em.Append(entry)
+	em, err := collect(iter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "primary_index": pIdx, "secondary_index": sIdx, "from": min, "to": max})
+		return
 	}
 
 	if sIdx == "" {
@@ -92,14 +182,70 @@ func (s *ServerMetrics[O, _]) GETMetricsAPI(c *gin.Context) {
 	c.JSON(http.StatusOK, em.Get(sIdx))
 }
 
+// GETQuery returns the series whose indexes match the primary and secondary query parameters, and
+// whose labels match the label ones, like label=region=glob:eu-*. All of them can be repeated. A
+// plain value matches exactly, prefix:, glob: and regex: match with a pattern, like
+// glob:instance-*, and a leading ! negates the match.
+func (s *ServerMetrics[O, _]) GETQuery(c *gin.Context) {
+	fromTo := FromTo[O]{}
+	if err := c.ShouldBindQuery(&fromTo); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	primary := c.QueryArray("primary")
+	secondary := c.QueryArray("secondary")
+	labels := c.QueryArray("label")
+	min := fromTo.From
+	max := fromTo.To
+
+	filters, err := parseFilters(primary, secondary, labels)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "primary": primary, "secondary": secondary})
+		return
+	}
+
+	if fromTo.Step != "" {
+		stepMs, aggs, err := fromTo.downsampling()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "primary": primary, "secondary": secondary, "from": min, "to": max})
+			return
+		}
+
+		iter, found, err := s.db.FindMatching(c.Request.Context(), min, max, filters...)
+		writeDownsampled(c, iter, found, err, stepMs, aggs, strings.Join(primary, ","), strings.Join(secondary, ","), min, max)
+		return
+	}
+
+	iter, found, err := s.db.FindMatching(c.Request.Context(), min, max, filters...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "primary": primary, "secondary": secondary, "from": min, "to": max})
+		return
+	}
+
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "data not found", "primary": primary, "secondary": secondary, "from": min, "to": max})
+		return
+	}
+
+	// series of different primary indexes can share a secondary index, they are kept apart
+	entries, err := collectList(iter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "primary": primary, "secondary": secondary, "from": min, "to": max})
+		return
+	}
+
+	c.JSON(http.StatusOK, entries)
+}
+
 func (s *ServerMetrics[O, E]) Ping(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"message": "pong",
 	})
 }
 
-func (s *ServerMetrics[O, _]) getMetrics() (*db.EntriesMap[int64], bool, error) {
-	iter, found, err := s.db.GetMetrics()
+func (s *ServerMetrics[O, _]) getMetrics(ctx context.Context) (*db.EntriesMap[int64], bool, error) {
+	iter, found, err := s.db.GetMetrics(ctx)
 	if err != nil {
 		return nil, found, err
 	}
@@ -109,11 +255,127 @@ func (s *ServerMetrics[O, _]) getMetrics() (*db.EntriesMap[int64], bool, error)
 	}
 
 	// Accumulate the metrics using the iterator
-	em := db.NewEntriesMap[int64]()
-	var entry db.Entry[int64]
-	for entry, found, err = iter.Next(); entry != nil && found && err == nil; entry, found, err = iter.Next() {
-		em.Append(entry)
+	em, err := collect(iter)
+	if err != nil {
+		return nil, false, err
 	}
 
 	return em, true, nil
 }
+
+func parseFilters(primary, secondary, labels []string) ([]db.EntryFilter, error) {
+	filters := make([]db.EntryFilter, 0, len(primary)+len(secondary)+len(labels))
+	for _, expr := range primary {
+		filter, err := db.ParseFilter(db.PrimaryIndexFilterKind, expr)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, filter)
+	}
+	for _, expr := range secondary {
+		filter, err := db.ParseFilter(db.SecondaryIndexFilterKind, expr)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, filter)
+	}
+	for _, label := range labels {
+		name, expr, found := strings.Cut(label, "=")
+		if !found {
+			return nil, fmt.Errorf("%w: label filters look like name=expression, got '%s'", db.ErrInvalidFilter, label)
+		}
+
+		filter, err := db.LabelFilter(name, expr)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, filter)
+	}
+
+	return filters, nil
+}
+
+// writePage responds with a page of results and the cursor of the next one, empty on the last page
+func writePage[O cmp.Ordered](c *gin.Context, page []db.Entry[O], next string, err error, pIdx, sIdx string, min, max O) {
+	if errors.Is(err, db.ErrInvalidCursor) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "primary_index": pIdx, "secondary_index": sIdx, "from": min, "to": max})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "primary_index": pIdx, "secondary_index": sIdx, "from": min, "to": max})
+		return
+	}
+
+	if len(page) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "data not found", "primary_index": pIdx, "secondary_index": sIdx, "from": min, "to": max})
+		return
+	}
+
+	em := db.NewEntriesMap[O]()
+	for _, entry := range page {
+		em.Append(entry)
+	}
+
+	if sIdx == "" {
+		c.JSON(http.StatusOK, gin.H{"entries": em, "cursor": next})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"entries": em.Get(sIdx), "cursor": next})
+}
+
+// writeDownsampled responds with the aggregated steps of every series found
+func writeDownsampled[O cmp.Ordered](c *gin.Context, iter db.EntryIterator[O], found bool, err error, stepMs int64, aggs []db.Aggregation, pIdx, sIdx string, min, max O) {
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "primary_index": pIdx, "secondary_index": sIdx, "from": min, "to": max})
+		return
+	}
+
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "data not found", "primary_index": pIdx, "secondary_index": sIdx, "from": min, "to": max})
+		return
+	}
+
+	series, err := db.Downsample(iter, stepMs, aggs...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "primary_index": pIdx, "secondary_index": sIdx, "from": min, "to": max})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"step": stepMs, "series": series})
+}
+
+// collect reads every entry of the iterator and closes it. A request that is cancelled stops the
+// iterator through its context.
+func collect[O cmp.Ordered](iter db.EntryIterator[O]) (*db.EntriesMap[O], error) {
+	defer iter.Close()
+
+	em := db.NewEntriesMap[O]()
+	for {
+		entry, found, err := iter.Next()
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			return em, nil
+		}
+		em.Append(entry)
+	}
+}
+
+// collectList is collect keeping every entry apart, whatever its indexes
+func collectList[O cmp.Ordered](iter db.EntryIterator[O]) ([]db.Entry[O], error) {
+	defer iter.Close()
+
+	entries := make([]db.Entry[O], 0)
+	for {
+		entry, found, err := iter.Next()
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			return entries, nil
+		}
+		entries = append(entries, entry)
+	}
+}
@@ -9,9 +9,26 @@ func NewDefaultConfig() *Config {
 		Filesystem:       FilesystemTypeMap[FILESYSTEM_TYPE_LOCAL],
 		LevelFilesystems: []string{"local", "local", "local", "local", "local"},
 		Wal: WalCfg{
-			MaxItems:         1024 * 32,
-			MaxElapsedTimeMs: time.Hour.Milliseconds() * 1000,
-			MaxSizeBytes:     32 * 32 * 32 * 1024,
+			MaxItems:          1024 * 32,
+			MaxElapsedTimeMs:  time.Hour.Milliseconds() * 1000,
+			MaxSizeBytes:      32 * 32 * 32 * 1024,
+			MaxTotalBytes:     256 * 1024 * 1024,
+			Flushers:          0,
+			MaxPendingFlushes: 8,
+			Persistent:        false,
+			SegmentSizeBytes:  64 * 1024 * 1024,
+			SyncMode:          WAL_SYNC_BATCH,
+			SyncIntervalMs:    10,
+			SyncBytes:         1024 * 1024,
+		},
+		Retention: RetentionCfg{
+			CheckIntervalMs: 60 * 1000,
+		},
+		Metadata: MetadataCfg{
+			BloomFalsePositiveRate: 0.01,
+		},
+		Manifest: ManifestCfg{
+			CheckpointEdits: 1000,
 		},
 		Compaction: CompactionCfg{
 			Promoters: PromotersCfg{
@@ -46,12 +63,93 @@ type Config struct {
 	LevelFilesystems []string
 	Compaction       CompactionCfg
 	Wal              WalCfg
+	Retention        RetentionCfg
+	Metadata         MetadataCfg
+	Manifest         ManifestCfg
+}
+
+type MetadataCfg struct {
+	// BloomFalsePositiveRate is the rate of the bloom filters of the fileblocks, 0 disables them
+	BloomFalsePositiveRate float64
 }
 
 type WalCfg struct {
 	MaxItems         int
 	MaxElapsedTimeMs int64
 	MaxSizeBytes     int
+
+	// MaxTotalBytes bounds the memory used by the WAL across all primary indexes. When it's
+	// exceeded, the largest primary indexes are flushed first. 0 disables it
+	MaxTotalBytes int
+
+	// Flushers is the number of background routines writing flushed entries to fileblocks. With 0,
+	// entries are written synchronously by the append that triggers the flush
+	Flushers int
+
+	// MaxPendingFlushes is the number of flushes waiting for a background flusher after which
+	// appends stall. They block until a flush finishes or, with FailOnWriteStall, return
+	// ErrWriteStall. 0 disables it
+	MaxPendingFlushes int
+	FailOnWriteStall  bool
+
+	// Persistent writes every appended entry to a log in DbPath before acknowledging it,
+	// so that entries not yet flushed to a fileblock survive a crash
+	Persistent       bool
+	SegmentSizeBytes int
+	SyncMode         WalSyncMode
+	SyncIntervalMs   int64
+	SyncBytes        int
+}
+
+// RetentionCfg drops values older than a maximum age. Values are taken as unix milliseconds, so it
+// only works with numeric orderings.
+type RetentionCfg struct {
+	// MaxAgeMs applies to every primary index without an override. 0 keeps values forever
+	MaxAgeMs int64
+
+	// PrimaryIndexMaxAgeMs overrides MaxAgeMs per primary index, 0 keeps its values forever
+	PrimaryIndexMaxAgeMs map[string]int64
+
+	// CheckIntervalMs is how often the janitor looks for expired fileblocks
+	CheckIntervalMs int64
+}
+
+// MaxAge returns the maximum age of the values of a primary index, 0 if they never expire
+func (r *RetentionCfg) MaxAge(pIdx string) time.Duration {
+	maxAgeMs, found := r.PrimaryIndexMaxAgeMs[pIdx]
+	if !found {
+		maxAgeMs = r.MaxAgeMs
+	}
+
+	return time.Duration(maxAgeMs) * time.Millisecond
+}
+
+// Enabled tells if the values of any primary index expire
+func (r *RetentionCfg) Enabled() bool {
+	if r.MaxAgeMs > 0 {
+		return true
+	}
+
+	for _, maxAgeMs := range r.PrimaryIndexMaxAgeMs {
+		if maxAgeMs > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ManifestCfg configures the manifests of the levels, see Manifest
+type ManifestCfg struct {
+	// Disabled opens the levels reading the metadata file of every fileblock, without a manifest
+	Disabled bool
+
+	// CheckpointEdits is the number of edits after which a manifest is rewritten with the fileblocks
+	// it holds, 0 rewrites it on every edit
+	CheckpointEdits int
+
+	// Rebuild ignores the manifests on startup, they are rebuilt from the metadata files
+	Rebuild bool
 }
 
 type CompactionCfg struct {
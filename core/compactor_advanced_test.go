@@ -1,6 +1,7 @@
 package core
 
 import (
+	"context"
 	"os"
 	"testing"
 
@@ -52,7 +53,7 @@ func TestCompactionAdvanced(t *testing.T) {
 	iter, found := wal.Find("instance1", "cpu", 0, 4)
 	assert.False(t, found)
 	assert.Nil(t, iter)
-	iter, found, err = mlevel.levels.FindSingle("instance1", "", 0, 4)
+	iter, found, err = mlevel.levels.FindSingle(context.Background(), "instance1", "", 0, 4)
 	require.NoError(t, err)
 	assert.True(t, found)
 	count := 0
@@ -124,3 +125,28 @@ func TestCompactionAdvanced(t *testing.T) {
 	kv = es.Get("mem").(*db.Kv)
 	assert.Equal(t, 9, len(kv.Val))
 }
+
+func TestCompactionAdvancedLoadError(t *testing.T) {
+	t.Cleanup(func() { os.RemoveAll("/tmp/db/compaction_error") })
+
+	cfg := db.NewDefaultConfig()
+	cfg.MaxLevels = 2
+	cfg.LevelFilesystems = []string{"local", "local"}
+	cfg.DbPath = "/tmp/db/compaction_error"
+	cfg.Wal.MaxItems = 5
+
+	lsmtree, err := NewLsmTree[int64, *db.Kv](cfg)
+	require.NoError(t, err)
+	defer lsmtree.Close()
+
+	// 2 overlapping fileblocks of the same primary index
+	require.NoError(t, lsmtree.Append(db.NewKv("instance1", "cpu", []int64{1, 2, 3, 4, 5}, []int32{1, 2, 3, 4, 5})))
+	require.NoError(t, lsmtree.Append(db.NewKv("instance1", "cpu", []int64{3, 4, 5, 6, 7}, []int32{1, 2, 3, 4, 5})))
+
+	blocks := lsmtree.levels.Fileblocks()
+	require.Len(t, blocks, 2)
+	require.NoError(t, os.Remove(blocks[0].DataFilepath))
+
+	// the merge fails to load the missing file, the compaction returns the error
+	assert.Error(t, lsmtree.Compact())
+}
@@ -1,6 +1,7 @@
 package core
 
 import (
+	"context"
 	"os"
 	"testing"
 
@@ -52,7 +53,7 @@ func TestCompactionMultiLevel(t *testing.T) {
 	iter, found := wal.Find("instance1", "cpu", 0, 4)
 	assert.False(t, found)
 	assert.Nil(t, iter)
-	iter, found, err = mlevel.levels.FindSingle("instance1", "", 0, 4)
+	iter, found, err = mlevel.levels.FindSingle(context.Background(), "instance1", "", 0, 4)
 	require.NoError(t, err)
 	assert.True(t, found)
 	count := 0
@@ -137,12 +138,9 @@ func countWalItems(wal *memoryWal[int64]) int {
 func getFileblocksAtLevel(mlevel *LsmTree[int64, *db.Kv], level int) []*db.Fileblock[int64] {
 	blocks := make([]*db.Fileblock[int64], 0)
 
-	mlevel.levels.Index.Ascend(func(i *db.BtreeItem[int64, int64]) bool {
-		ll := i.Val
-		for next, found := ll.Head(); next != nil && found; next = next.Next {
-			if next.Val.Metadata().Level == level {
-				blocks = append(blocks, next.Val)
-			}
+	mlevel.levels.Index.Ascend(func(fb *db.Fileblock[int64]) bool {
+		if fb.Metadata().Level == level {
+			blocks = append(blocks, fb)
 		}
 		return true
 	})
@@ -153,12 +151,9 @@ func getFileblocksAtLevel(mlevel *LsmTree[int64, *db.Kv], level int) []*db.Fileb
 func countFileblocks(mlevel *LsmTree[int64, *db.Kv], level int) int {
 	count := 0
 
-	mlevel.levels.Index.Ascend(func(i *db.BtreeItem[int64, int64]) bool {
-		ll := i.Val
-		for next, found := ll.Head(); next != nil && found; next = next.Next {
-			if next.Val.Metadata().Level == level {
-				count++
-			}
+	mlevel.levels.Index.Ascend(func(fb *db.Fileblock[int64]) bool {
+		if fb.Metadata().Level == level {
+			count++
 		}
 		return true
 	})
@@ -0,0 +1,135 @@
+package core
+
+import (
+	"context"
+	"os"
+	"slices"
+	"testing"
+
+	db "github.com/sayden/streedb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// findTimestamps returns, sorted, every timestamp of a series between min and max
+func findTimestamps(t *testing.T, lsmtree *LsmTree[int64, *db.Kv], pIdx, sIdx string, min, max int64) []int64 {
+	iter, found, err := lsmtree.Find(context.Background(), pIdx, sIdx, min, max)
+	require.NoError(t, err)
+
+	ts := make([]int64, 0)
+	if !found {
+		return ts
+	}
+	for entry, found, _ := iter.Next(); found; entry, found, _ = iter.Next() {
+		if entry.SecondaryIndex() == sIdx {
+			ts = append(ts, entry.(*db.Kv).Ts...)
+		}
+	}
+	slices.Sort(ts)
+
+	return ts
+}
+
+func TestDelete(t *testing.T) {
+	t.Cleanup(func() { os.RemoveAll("/tmp/db/delete") })
+
+	t.Run("Memory", func(t *testing.T) {
+		cfg := db.NewDefaultConfig()
+		cfg.Filesystem = db.FilesystemTypeMap[db.FILESYSTEM_TYPE_MEMORY]
+		cfg.LevelFilesystems = nil
+		cfg.DbPath = "/tmp/db/delete/memory"
+		cfg.Wal.MaxItems = 10
+
+		lsmtree, err := NewLsmTree[int64, *db.Kv](cfg)
+		require.NoError(t, err)
+		defer lsmtree.Close()
+
+		// 2 fileblocks, and cpu 10 to 12 in the wal
+		for i := int64(0); i < 10; i += 5 {
+			ts := []int64{i, i + 1, i + 2, i + 3, i + 4}
+			require.NoError(t, lsmtree.Append(db.NewKv("instance1", "cpu", ts, []int32{1, 2, 3, 4, 5})))
+			require.NoError(t, lsmtree.Append(db.NewKv("instance1", "mem", slices.Clone(ts), []int32{1, 2, 3, 4, 5})))
+		}
+		require.NoError(t, lsmtree.Append(db.NewKv("instance1", "cpu", []int64{10, 11, 12}, []int32{1, 2, 3})))
+
+		snapshot := lsmtree.Snapshot()
+		defer snapshot.Close()
+
+		assert.ErrorIs(t, lsmtree.Delete("", "cpu", 0, 1), db.ErrInvalidTombstone)
+
+		require.NoError(t, lsmtree.Delete("instance1", "cpu", 3, 11))
+		assert.Equal(t, []int64{0, 1, 2, 12}, findTimestamps(t, lsmtree, "instance1", "cpu", 0, 100))
+		assert.Equal(t, []int64{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, findTimestamps(t, lsmtree, "instance1", "mem", 0, 100))
+
+		// every series of the primary index
+		require.NoError(t, lsmtree.Delete("instance1", "", 0, 0))
+		assert.Equal(t, []int64{1, 2, 12}, findTimestamps(t, lsmtree, "instance1", "cpu", 0, 100))
+		assert.Equal(t, []int64{1, 2, 3, 4, 5, 6, 7, 8, 9}, findTimestamps(t, lsmtree, "instance1", "mem", 0, 100))
+
+		// the snapshot was taken before the deletes
+		assert.Len(t, snapshotTimestamps(t, snapshot), 13)
+
+		// compaction drops the deleted values from the fileblocks
+		require.NoError(t, lsmtree.Compact())
+		fileblocks := lsmtree.levels.Fileblocks()
+		require.Len(t, fileblocks, 1)
+		assert.Empty(t, fileblocks[0].Tombstones)
+		assert.Equal(t, []int64{1, 2, 12}, findTimestamps(t, lsmtree, "instance1", "cpu", 0, 100))
+		assert.Equal(t, []int64{1, 2, 3, 4, 5, 6, 7, 8, 9}, findTimestamps(t, lsmtree, "instance1", "mem", 0, 100))
+	})
+
+	t.Run("LocalPersistence", func(t *testing.T) {
+		cfg := db.NewDefaultConfig()
+		cfg.Filesystem = db.FilesystemTypeMap[db.FILESYSTEM_TYPE_LOCAL]
+		cfg.LevelFilesystems = nil
+		cfg.DbPath = "/tmp/db/delete/local"
+		cfg.Wal.MaxItems = 5
+
+		lsmtree, err := NewLsmTree[int64, *db.Kv](cfg)
+		require.NoError(t, err)
+		require.NoError(t, lsmtree.Append(db.NewKv("instance1", "cpu", []int64{0, 1, 2, 3, 4}, []int32{1, 2, 3, 4, 5})))
+		require.NoError(t, lsmtree.Delete("instance1", "cpu", 1, 2))
+		require.NoError(t, lsmtree.Close())
+
+		// the tombstone is read back from the metadata of the fileblock
+		cfg.LevelFilesystems = nil
+		lsmtree, err = NewLsmTree[int64, *db.Kv](cfg)
+		require.NoError(t, err)
+		defer lsmtree.Close()
+
+		assert.Equal(t, []int64{0, 3, 4}, findTimestamps(t, lsmtree, "instance1", "cpu", 0, 100))
+	})
+
+	t.Run("ReplayAfterCrash", func(t *testing.T) {
+		cfg := db.NewDefaultConfig()
+		cfg.Filesystem = db.FilesystemTypeMap[db.FILESYSTEM_TYPE_LOCAL]
+		cfg.LevelFilesystems = nil
+		cfg.DbPath = "/tmp/db/delete/replay"
+		cfg.Wal.Persistent = true
+		cfg.Wal.MaxItems = 100
+
+		lsmtree, err := NewLsmTree[int64, *db.Kv](cfg)
+		require.NoError(t, err)
+		require.NoError(t, lsmtree.Append(db.NewKv("instance1", "cpu", []int64{0, 1, 2}, []int32{1, 2, 3})))
+		require.NoError(t, lsmtree.Delete("instance1", "cpu", 1, 1))
+		require.NoError(t, lsmtree.Append(db.NewKv("instance1", "cpu", []int64{1}, []int32{4})))
+
+		// simulate a crash after the fileblock is written but before the wal is marked as flushed
+		memoryWal := lsmtree.wal.(*diskWal[int64, *db.Kv]).memoryWal
+		memoryWal.mu.Lock()
+		memoryWal.onFlushed = nil
+		memoryWal.mu.Unlock()
+		require.NoError(t, lsmtree.Close())
+
+		cfg.LevelFilesystems = nil
+		lsmtree, err = NewLsmTree[int64, *db.Kv](cfg)
+		require.NoError(t, err)
+		defer lsmtree.Close()
+
+		// the value appended after the tombstone is kept in the fileblock
+		fileblocks := lsmtree.levels.Fileblocks()
+		require.Len(t, fileblocks, 1)
+		assert.Empty(t, fileblocks[0].Tombstones)
+		assert.Contains(t, findTimestamps(t, lsmtree, "instance1", "cpu", 0, 100), int64(1))
+	})
+}
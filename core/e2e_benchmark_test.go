@@ -1,6 +1,10 @@
 package core
 
 import (
+	"context"
+	"fmt"
+	"os"
+	"sync/atomic"
 	"testing"
 
 	db "github.com/sayden/streedb"
@@ -14,6 +18,7 @@ func TestStreeDB(t *testing.T) {
 	// })
 
 	cfg := db.NewDefaultConfig()
+	cfg.DbPath = t.TempDir()
 	cfg.Filesystem = db.FilesystemTypeMap[db.FILESYSTEM_TYPE_LOCAL]
 
 	lsmtree, err := NewLsmTree[int64, *db.Kv](cfg)
@@ -41,8 +46,52 @@ func TestStreeDB(t *testing.T) {
 	err = lsmtree.Append(db.NewKv("instance1", "cpu", ts, vals))
 	require.NoError(t, err)
 
-	iter, found, err := lsmtree.Find("instance1", "cpu", 12345, 12399)
+	iter, found, err := lsmtree.Find(context.Background(), "instance1", "cpu", 12345, 12399)
 	require.NoError(t, err)
 	assert.True(t, found)
 	require.NotNil(t, iter)
 }
+
+func BenchmarkWalSyncModes(b *testing.B) {
+	modes := []struct {
+		name string
+		mode db.WalSyncMode
+	}{
+		{"Always", db.WAL_SYNC_ALWAYS},
+		{"Batch", db.WAL_SYNC_BATCH},
+		{"None", db.WAL_SYNC_NONE},
+	}
+
+	for _, m := range modes {
+		b.Run(m.name, func(b *testing.B) {
+			b.Cleanup(func() { os.RemoveAll("/tmp/db/bench_wal") })
+
+			cfg := db.NewDefaultConfig()
+			cfg.DbPath = "/tmp/db/bench_wal"
+			cfg.Filesystem = db.FilesystemTypeMap[db.FILESYSTEM_TYPE_MEMORY]
+			cfg.LevelFilesystems = nil
+			cfg.Wal.Persistent = true
+			cfg.Wal.SyncMode = m.mode
+
+			lsmtree, err := NewLsmTree[int64, *db.Kv](cfg)
+			require.NoError(b, err)
+			defer lsmtree.Close()
+
+			// many concurrent writers, so that the fsync of Always and Batch is shared between them
+			b.SetParallelism(16)
+
+			var instance atomic.Int64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				pIdx := fmt.Sprintf("instance%d", instance.Add(1))
+				i := int64(0)
+				for pb.Next() {
+					if err := lsmtree.Append(db.NewKv(pIdx, "cpu", []int64{i}, []int32{int32(i)})); err != nil {
+						b.Fatal(err)
+					}
+					i++
+				}
+			})
+		})
+	}
+}
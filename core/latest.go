@@ -0,0 +1,239 @@
+package core
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"slices"
+	"strings"
+
+	db "github.com/sayden/streedb"
+	"github.com/sayden/streedb/fs"
+)
+
+var ErrInvalidLatestCount = errors.New("the number of values to find must be greater than 0")
+
+// FindLatest returns the n newest values of every series matching the indexes, in descending order.
+// Series are ordered by primary and secondary index. Fileblocks are walked from the one with the
+// newest values, and only the ones that might hold any of the n newest values of a series, going by
+// the rows of their metadata, are pinned. Once both indexes are set, the walk stops when the series
+// has n values newer than the rest of the fileblocks.
+func (l *LsmTree[O, E]) FindLatest(ctx context.Context, pIdx, sIdx string, n int) (_ db.EntryIterator[O], _ bool, err error) {
+	if n <= 0 {
+		return nil, false, ErrInvalidLatestCount
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	latest := newLatestSeries[O](n)
+	var blocks []*db.Fileblock[O]
+	err = l.wal.View(func(wal db.WalReader[O]) error {
+		if walIter, found := wal.FindSeries(pIdx, sIdx); found {
+			for {
+				entry, found, err := walIter.Next()
+				if err != nil {
+					return err
+				}
+				if !found {
+					break
+				}
+				if err = latest.add(entry); err != nil {
+					return err
+				}
+			}
+		}
+
+		blocks = latest.acquire(l.levels, pIdx, sIdx)
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	read := 0
+	defer func() {
+		for _, fb := range blocks[read:] {
+			err = errors.Join(err, fb.Release())
+		}
+	}()
+
+	for _, fb := range blocks {
+		if err := ctx.Err(); err != nil {
+			return nil, false, err
+		}
+
+		// the values loaded so far can rule out a fileblock its rows didn't
+		if latest.needs(fb, sIdx) {
+			if err := latest.addFileblock(fb, sIdx); err != nil {
+				return nil, false, err
+			}
+		}
+
+		read++
+		if err := fb.Release(); err != nil {
+			return nil, false, err
+		}
+	}
+
+	return latest.iterator()
+}
+
+// latestSeries keeps the n newest values of every series added to it, sorted. Before loading any
+// fileblock, counted holds how many values of every series are known to exist and a bound none of
+// them is older than, from the values added and the rows of the fileblocks pinned.
+type latestSeries[O cmp.Ordered] struct {
+	n       int
+	series  map[string]db.Entry[O]
+	counted map[string]latestCount[O]
+}
+
+type latestCount[O cmp.Ordered] struct {
+	values int
+	oldest O
+}
+
+func newLatestSeries[O cmp.Ordered](n int) *latestSeries[O] {
+	return &latestSeries[O]{n: n, series: make(map[string]db.Entry[O]), counted: make(map[string]latestCount[O])}
+}
+
+func latestSeriesKey(pIdx, sIdx string) string {
+	return pIdx + "\x00" + sIdx
+}
+
+func (l *latestSeries[O]) add(entry db.Entry[O]) error {
+	if entry.Len() == 0 {
+		return nil
+	}
+	entry.Sort()
+
+	key := latestSeriesKey(entry.PrimaryIndex(), entry.SecondaryIndex())
+	if current, found := l.series[key]; found {
+		if err := current.Append(entry); err != nil {
+			return errors.Join(errors.New("failed to merge fragments of a series"), err)
+		}
+		current.Sort()
+		entry = current
+	}
+
+	// the slice caps its capacity, later appends don't touch the entry it comes from
+	entry = entry.Slice(max(entry.Len()-l.n, 0), entry.Len())
+	l.series[key] = entry
+	l.counted[key] = latestCount[O]{values: entry.Len(), oldest: entry.Min()}
+
+	return nil
+}
+
+// acquire walks the fileblocks from the one with the newest values and pins the ones that might hold
+// any of the n newest values of a series, counting the values of their rows. It must be called with
+// the wal values added and no entry being flushed.
+func (l *latestSeries[O]) acquire(levels *fs.MultiFsLevels[O], pIdx, sIdx string) []*db.Fileblock[O] {
+	blocks := make([]*db.Fileblock[O], 0)
+	levels.DescendMax(pIdx, func(fb *db.Fileblock[O]) bool {
+		// every fileblock left is older than this one, only a known series can be complete
+		if pIdx != "" && sIdx != "" && l.hasNewer(latestSeriesKey(pIdx, sIdx), *fb.Max) {
+			return false
+		}
+
+		if l.mightNeed(fb, sIdx) && fb.Acquire() {
+			l.count(fb, sIdx)
+			blocks = append(blocks, fb)
+		}
+		return true
+	})
+
+	return blocks
+}
+
+// hasNewer tells if the series is known to have n values not older than bound
+func (l *latestSeries[O]) hasNewer(key string, bound O) bool {
+	counted, found := l.counted[key]
+	return found && counted.values >= l.n && counted.oldest >= bound
+}
+
+// mightNeed is needs going by the values counted instead of the ones loaded
+func (l *latestSeries[O]) mightNeed(fb *db.Fileblock[O], sIdx string) bool {
+	if len(fb.Rows) == 0 {
+		return true
+	}
+
+	for _, row := range fb.Rows {
+		if (sIdx == "" || row.SecondaryIdx == sIdx) && !l.hasNewer(latestSeriesKey(fb.PrimaryIdx, row.SecondaryIdx), row.Max) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// count adds the values of the rows of the fileblock. Every value of a row is at least as new as its
+// min. The rows of a fileblock with tombstones might count deleted values, they aren't counted.
+func (l *latestSeries[O]) count(fb *db.Fileblock[O], sIdx string) {
+	if fb.HasTombstones() {
+		return
+	}
+
+	for _, row := range fb.Rows {
+		if sIdx != "" && row.SecondaryIdx != sIdx {
+			continue
+		}
+
+		key := latestSeriesKey(fb.PrimaryIdx, row.SecondaryIdx)
+		counted, found := l.counted[key]
+		if !found || row.Min < counted.oldest {
+			counted.oldest = row.Min
+		}
+		counted.values += row.ItemCount
+		l.counted[key] = counted
+	}
+}
+
+// needs tells if the fileblock can hold any of the n newest values of the series. Fileblocks without
+// rows can't be pruned, like in Fileblock.Matches
+func (l *latestSeries[O]) needs(fb *db.Fileblock[O], sIdx string) bool {
+	if len(fb.Rows) == 0 {
+		return true
+	}
+
+	for _, row := range fb.Rows {
+		if sIdx != "" && row.SecondaryIdx != sIdx {
+			continue
+		}
+
+		current, found := l.series[latestSeriesKey(fb.PrimaryIdx, row.SecondaryIdx)]
+		if !found || current.Len() < l.n || current.Min() < row.Max {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (l *latestSeries[O]) addFileblock(fb *db.Fileblock[O], sIdx string) (err error) {
+	es, err := fb.Load()
+	if err != nil {
+		return errors.Join(errors.New("failed to load fileblock"), err)
+	}
+
+	es.Range(func(_ string, entry db.Entry[O]) bool {
+		if sIdx == "" || entry.SecondaryIndex() == sIdx {
+			err = l.add(entry)
+		}
+		return err == nil
+	})
+
+	return err
+}
+
+func (l *latestSeries[O]) iterator() (db.EntryIterator[O], bool, error) {
+	entries := make([]db.Entry[O], 0, len(l.series))
+	for _, entry := range l.series {
+		entry.Reverse()
+		entries = append(entries, entry)
+	}
+	slices.SortFunc(entries, func(a, b db.Entry[O]) int {
+		return cmp.Or(strings.Compare(a.PrimaryIndex(), b.PrimaryIndex()), strings.Compare(a.SecondaryIndex(), b.SecondaryIndex()))
+	})
+
+	return db.NewListIterator(entries), len(entries) > 0, nil
+}
@@ -0,0 +1,120 @@
+package core
+
+import (
+	"context"
+	"testing"
+
+	db "github.com/sayden/streedb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindLatest(t *testing.T) {
+	cfg := db.NewDefaultConfig()
+	cfg.Filesystem = db.FilesystemTypeMap[db.FILESYSTEM_TYPE_MEMORY]
+	cfg.LevelFilesystems = nil
+	cfg.DbPath = "/tmp/db/find_latest"
+	cfg.Wal.MaxItems = 3
+
+	lsmtree, err := NewLsmTree[int64, *db.Kv](cfg)
+	require.NoError(t, err)
+	defer lsmtree.Close()
+
+	// 3 fileblocks of cpu, the newest cpu value and mem in the wal
+	for ts := int64(1); ts < 10; ts++ {
+		require.NoError(t, lsmtree.Append(db.NewKv("instance1", "cpu", []int64{ts}, []int32{int32(ts)})))
+	}
+	require.NoError(t, lsmtree.Append(db.NewKv("instance1", "mem", []int64{2}, []int32{2})))
+	require.NoError(t, lsmtree.Append(db.NewKv("instance1", "cpu", []int64{10}, []int32{10})))
+
+	readAll := func(iter db.EntryIterator[int64]) []*db.Kv {
+		defer iter.Close()
+
+		res := make([]*db.Kv, 0)
+		for entry, found, err := iter.Next(); found; entry, found, err = iter.Next() {
+			require.NoError(t, err)
+			res = append(res, entry.(*db.Kv))
+		}
+		return res
+	}
+
+	t.Run("Series", func(t *testing.T) {
+		iter, found, err := lsmtree.FindLatest(context.Background(), "instance1", "cpu", 4)
+		require.NoError(t, err)
+		require.True(t, found)
+
+		res := readAll(iter)
+		require.Len(t, res, 1)
+		assert.Equal(t, []int64{10, 9, 8, 7}, res[0].Ts)
+		assert.Equal(t, []int32{10, 9, 8, 7}, res[0].Val)
+	})
+
+	t.Run("PrimaryIndex", func(t *testing.T) {
+		iter, found, err := lsmtree.FindLatest(context.Background(), "instance1", "", 1)
+		require.NoError(t, err)
+		require.True(t, found)
+
+		res := readAll(iter)
+		require.Len(t, res, 2)
+		assert.Equal(t, "cpu", res[0].Key)
+		assert.Equal(t, []int64{10}, res[0].Ts)
+		assert.Equal(t, "mem", res[1].Key)
+		assert.Equal(t, []int64{2}, res[1].Ts)
+	})
+
+	t.Run("Acquire", func(t *testing.T) {
+		// 10 is in the wal, the newest fileblock holds 7, 8 and 9, the older ones aren't pinned
+		latest := newLatestSeries[int64](2)
+		require.NoError(t, latest.add(db.NewKv("instance1", "cpu", []int64{10}, []int32{10})))
+		blocks := latest.acquire(lsmtree.levels, "instance1", "cpu")
+		require.Len(t, blocks, 1)
+		assert.Equal(t, int64(9), *blocks[0].Max)
+		require.NoError(t, blocks[0].Release())
+
+		// the wal holds the newest value of both series
+		latest = newLatestSeries[int64](1)
+		require.NoError(t, latest.add(db.NewKv("instance1", "cpu", []int64{10}, []int32{10})))
+		require.NoError(t, latest.add(db.NewKv("instance1", "mem", []int64{2}, []int32{2})))
+		assert.Empty(t, latest.acquire(lsmtree.levels, "instance1", ""))
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		_, found, err := lsmtree.FindLatest(context.Background(), "instance2", "", 1)
+		require.NoError(t, err)
+		assert.False(t, found)
+
+		_, _, err = lsmtree.FindLatest(context.Background(), "instance1", "", 0)
+		assert.ErrorIs(t, err, ErrInvalidLatestCount)
+	})
+
+	t.Run("Descending", func(t *testing.T) {
+		iter, found, err := lsmtree.FindDescending(context.Background(), "instance1", "cpu", 1, 8)
+		require.NoError(t, err)
+		require.True(t, found)
+
+		res := readAll(iter)
+		require.Len(t, res, 1)
+		assert.Equal(t, []int64{8, 7, 6, 5, 4, 3, 2, 1}, res[0].Ts)
+	})
+}
+
+func TestLatestSeriesNeeds(t *testing.T) {
+	latest := newLatestSeries[int64](2)
+	require.NoError(t, latest.add(db.NewKv("instance1", "cpu", []int64{9, 7, 8}, []int32{9, 7, 8})))
+	assert.Equal(t, []int64{8, 9}, latest.series[latestSeriesKey("instance1", "cpu")].(*db.Kv).Ts)
+
+	fileblock := func(rows ...db.Row[int64]) *db.Fileblock[int64] {
+		return db.NewFileblock(db.NewDefaultConfig(), &db.MetaFile[int64]{PrimaryIdx: "instance1", Rows: rows}, nil)
+	}
+
+	// older than the 2 newest cpu values
+	assert.False(t, latest.needs(fileblock(db.Row[int64]{SecondaryIdx: "cpu", Min: 1, Max: 7}), "cpu"))
+	// holds a newer cpu value
+	assert.True(t, latest.needs(fileblock(db.Row[int64]{SecondaryIdx: "cpu", Min: 1, Max: 10}), "cpu"))
+	// mem has no values yet, but it's only needed if it's asked for
+	memBlock := fileblock(db.Row[int64]{SecondaryIdx: "cpu", Min: 1, Max: 7}, db.Row[int64]{SecondaryIdx: "mem", Min: 1, Max: 7})
+	assert.True(t, latest.needs(memBlock, ""))
+	assert.False(t, latest.needs(memBlock, "cpu"))
+	// without rows, the values it holds are unknown
+	assert.True(t, latest.needs(fileblock(), "cpu"))
+}
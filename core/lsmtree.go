@@ -2,13 +2,24 @@ package core
 
 import (
 	"cmp"
+	"context"
 	"errors"
+	"sync"
+	"time"
 
 	db "github.com/sayden/streedb"
 	"github.com/sayden/streedb/fs"
+	"github.com/thehivecorporation/log"
 )
 
 func NewLsmTree[O cmp.Ordered, E db.Entry[O]](cfg *db.Config, listeners ...db.FileblockListener[O]) (*LsmTree[O, E], error) {
+	// checked before opening the levels, they would be left open otherwise
+	if cfg.Retention.Enabled() {
+		if err := checkRetention[O](); err != nil {
+			return nil, err
+		}
+	}
+
 	if cfg.LevelFilesystems == nil {
 		cfg.LevelFilesystems = make([]string, 0, cfg.MaxLevels)
 		for i := 0; i < cfg.MaxLevels; i++ {
@@ -29,11 +40,23 @@ func NewLsmTree[O cmp.Ordered, E db.Entry[O]](cfg *db.Config, listeners ...db.Fi
 		cfg:    cfg,
 	}
 
+	if cfg.Retention.Enabled() {
+		l.janitor = newRetentionJanitor(cfg, levels)
+	}
+
 	// Create the WAL
-	l.wal = newNMMemoryWal(cfg, levels,
+	walFlushStrategies := []db.WalFlushStrategy[O]{
 		newItemLimitWalFlushStrategy[O](cfg.Wal.MaxItems),
 		newSizeLimitWalFlushStrategy[O](cfg.Wal.MaxSizeBytes),
-	)
+	}
+	if cfg.Wal.Persistent {
+		// entries that were not flushed before the last shutdown are replayed here
+		if l.wal, err = newDiskWal[O, E](cfg, levels, walFlushStrategies...); err != nil {
+			return nil, errors.Join(err, levels.Close())
+		}
+	} else {
+		l.wal = newNMMemoryWal(cfg, levels, walFlushStrategies...)
+	}
 
 	compactionStrategies := &samePrimaryIndexCompactionStrategy[O]{
 		and: &overlappingCompactionStrategy[O]{},
@@ -44,42 +67,226 @@ func NewLsmTree[O cmp.Ordered, E db.Entry[O]](cfg *db.Config, listeners ...db.Fi
 		panic(err)
 	}
 
+	if l.janitor != nil && cfg.Retention.CheckIntervalMs > 0 {
+		l.janitorDone = make(chan struct{})
+		l.janitorWg.Add(1)
+		go l.expireOnInterval(time.Duration(cfg.Retention.CheckIntervalMs) * time.Millisecond)
+	}
+
 	return l, nil
 }
 
+// stoppableWal is a wal that can reject new entries before it's closed
+type stoppableWal interface {
+	stop()
+}
+
+// LsmTree is safe for concurrent use. Append, Find and Compact can be called from any number of
+// goroutines:
+//   - The wal and the indexes of the levels are guarded by their own locks.
+//   - Find returns iterators over a snapshot: copies of the wal entries and the list of fileblocks
+//     at the moment of the call, taken while no entry can be flushed so that every value is found
+//     once. Those fileblocks are pinned, a compaction doesn't delete their
+//     files until the iterator has loaded them or is closed, so iterators must always be closed.
+//     Separate Find calls can see different data, use a Snapshot to get the same view on several
+//     calls.
+//   - Compactions are serialized, a Compact call waits for a running one to finish. Deletes and
+//     retention passes are serialized with them.
+//   - Close waits for ongoing calls to finish before flushing the wal.
 type LsmTree[O cmp.Ordered, E db.Entry[O]] struct {
 	cfg *db.Config
 
+	// mu is held for reading during every operation and for writing by Close
+	mu        sync.RWMutex
+	compactMu sync.Mutex
+
 	compactor db.Compactor[O]
 	wal       db.Wal[O]
 	levels    *fs.MultiFsLevels[O]
+
+	// janitor is nil when retention is disabled
+	janitor         *retentionJanitor[O]
+	janitorDone     chan struct{}
+	janitorWg       sync.WaitGroup
+	stopJanitorOnce sync.Once
 }
 
 func (l *LsmTree[O, _]) Append(d db.Entry[O]) error {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
 	return l.wal.Append(d)
 }
 
-func (l *LsmTree[O, E]) Find(pIdx, sIdx string, min, max O) (db.EntryIterator[O], bool, error) {
-	// Look in the WAL
-	walIter, walFound := l.wal.Find(pIdx, sIdx, min, max)
+// Find returns every series matching the indexes once, with its values between min and max in
+// ascending order. Series are ordered by primary and secondary index.
+func (l *LsmTree[O, E]) Find(ctx context.Context, pIdx, sIdx string, min, max O) (db.EntryIterator[O], bool, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
 
-	dbIter, dbFound, err := l.levels.FindSingle(pIdx, sIdx, min, max)
+	// a flush between reading the wal and the levels would return its entries twice
+	var walIter, dbIter db.EntryIterator[O]
+	var walFound, dbFound bool
+	err := l.wal.View(func(wal db.WalReader[O]) (err error) {
+		walIter, walFound = wal.Find(pIdx, sIdx, min, max)
+		dbIter, dbFound, err = l.levels.FindSingle(ctx, pIdx, sIdx, min, max)
+		return err
+	})
 	if err != nil {
 		return nil, false, err
 	}
-	if walFound && dbFound {
-		walIter = db.NewIteratorMerger[O](walIter, dbIter)
-		return walIter, true, nil
+
+	return mergeFound(walIter, walFound, dbIter, dbFound, min, max, db.NewSortedMergeIterator[O])
+}
+
+// FindMatching is Find returning the series that pass every filter, like a regex or a glob over
+// their indexes
+func (l *LsmTree[O, E]) FindMatching(ctx context.Context, min, max O, filters ...db.EntryFilter) (db.EntryIterator[O], bool, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	var walIter, dbIter db.EntryIterator[O]
+	var walFound, dbFound bool
+	err := l.wal.View(func(wal db.WalReader[O]) (err error) {
+		walIter, walFound = wal.FindMatching(min, max, filters...)
+		dbIter, dbFound, err = l.levels.FindMatching(ctx, min, max, filters...)
+		return err
+	})
+	if err != nil {
+		return nil, false, err
 	}
 
-	if walFound {
-		return walIter, true, nil
+	return mergeFound(walIter, walFound, dbIter, dbFound, min, max, db.NewSortedMergeIterator[O])
+}
+
+// FindPage is Find returning at most limit values, starting after the position encoded in cursor.
+// The returned cursor resumes the query where the page ends, it's empty once every value has been
+// returned. Only the fileblocks that hold values of the page are loaded.
+func (l *LsmTree[O, E]) FindPage(ctx context.Context, pIdx, sIdx string, min, max O, limit int, cursor string) ([]db.Entry[O], string, error) {
+	if limit <= 0 {
+		return nil, "", db.ErrInvalidLimit
+	}
+
+	after, err := db.DecodeCursor[O](cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	filters := []db.EntryFilter{db.PrimaryIndexFilter(pIdx), db.SecondaryIndexFilter[O](sIdx)}
+	if after != nil {
+		filters = append(filters, after.Filter())
+		// a single series starts at the cursor
+		if sIdx != "" && after.PrimaryIdx == pIdx && after.SecondaryIdx == sIdx && min < after.Ts {
+			min = after.Ts
+		}
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	var walIter, dbIter db.EntryIterator[O]
+	var walFound, dbFound bool
+	err = l.wal.View(func(wal db.WalReader[O]) (err error) {
+		walIter, walFound = wal.Find(pIdx, sIdx, min, max)
+		dbIter, dbFound, err = l.levels.FindMatching(ctx, min, max, filters...)
+		return err
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	iter, found, err := mergeFound(walIter, walFound, dbIter, dbFound, min, max, db.NewSortedChunkIterator[O])
+	if err != nil || !found {
+		return nil, "", err
+	}
+
+	page, next, err := db.ReadPage(iter, limit, after)
+	if err != nil {
+		return nil, "", err
 	}
 
-	return dbIter, dbFound, nil
+	return page, next.Encode(), nil
+}
+
+// FindDescending is Find with the values of every series in descending order. The fileblocks with
+// the newest values are read first.
+func (l *LsmTree[O, E]) FindDescending(ctx context.Context, pIdx, sIdx string, min, max O) (db.EntryIterator[O], bool, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	var walIter, dbIter db.EntryIterator[O]
+	var walFound, dbFound bool
+	err := l.wal.View(func(wal db.WalReader[O]) (err error) {
+		walIter, walFound = wal.Find(pIdx, sIdx, min, max)
+		dbIter, dbFound, err = l.levels.FindSingleDescending(ctx, pIdx, sIdx, min, max)
+		return err
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	iter, found, err := mergeFound(walIter, walFound, dbIter, dbFound, min, max, db.NewSortedMergeIterator[O])
+	if !found || err != nil {
+		return nil, found, err
+	}
+
+	return db.NewReverseIterator(iter), true, nil
+}
+
+// mergeFound merges what was found in the wal and in the levels with merge, trimmed to the values
+// between min and max
+func mergeFound[O cmp.Ordered, M db.EntryIterator[O]](walIter db.EntryIterator[O], walFound bool, dbIter db.EntryIterator[O], dbFound bool, min, max O, merge func(...db.EntryIterator[O]) M) (db.EntryIterator[O], bool, error) {
+	if !walFound && !dbFound {
+		return nil, false, dbIter.Close()
+	}
+
+	// fileblocks are trimmed before their series are merged, so that the values out of the range
+	// are never copied. A series can be split across the wal and several fileblocks
+	dbIter = db.NewRangeIterator(dbIter, min, max)
+	if !walFound {
+		return merge(dbIter), true, nil
+	}
+
+	return merge(db.NewRangeIterator(walIter, min, max), dbIter), true, nil
+}
+
+// Delete removes the values between min and max, both included, of a series, or of every series of
+// the primary index when sIdx is empty. The values are masked by tombstones until a compaction
+// drops them from the fileblocks.
+func (l *LsmTree[O, _]) Delete(pIdx, sIdx string, min, max O) error {
+	t, err := db.NewTombstone(pIdx, sIdx, min, max)
+	if err != nil {
+		return err
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	// a compaction merging a fileblock that gets the tombstone afterwards would bring the values back
+	l.compactMu.Lock()
+	defer l.compactMu.Unlock()
+
+	return l.wal.Delete(t, func() error { return l.levels.AddTombstone(t) })
 }
 
 func (l *LsmTree[_, _]) Close() (err error) {
+	// a running retention pass holds mu, it must finish before Close takes it
+	l.stopJanitorOnce.Do(func() {
+		if l.janitorDone != nil {
+			close(l.janitorDone)
+			l.janitorWg.Wait()
+		}
+	})
+
+	// stalled appends hold mu until a flush finishes, which might never happen if the fileblocks
+	// can't be written. They must give up before Close takes it
+	if w, ok := l.wal.(stoppableWal); ok {
+		w.stop()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
 	// Close the wal and write whatever is left in it
 	errs := make([]error, 0)
 
@@ -96,5 +303,47 @@ func (l *LsmTree[_, _]) Close() (err error) {
 }
 
 func (l *LsmTree[_, _]) Compact() error {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	l.compactMu.Lock()
+	defer l.compactMu.Unlock()
+
 	return l.compactor.Compact(l.levels.Fileblocks())
 }
+
+// Expire drops the values older than the retention of their primary index from the fileblocks. It
+// runs periodically in the background, every Retention.CheckIntervalMs. Values still in the wal are
+// expired once they are flushed.
+func (l *LsmTree[_, _]) Expire() error {
+	if l.janitor == nil {
+		return nil
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	l.compactMu.Lock()
+	defer l.compactMu.Unlock()
+
+	return l.janitor.expire()
+}
+
+func (l *LsmTree[_, _]) expireOnInterval(interval time.Duration) {
+	defer l.janitorWg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.janitorDone:
+			return
+		case <-ticker.C:
+		}
+
+		if err := l.Expire(); err != nil {
+			log.WithError(err).Error("error expiring fileblocks")
+		}
+	}
+}
@@ -3,8 +3,13 @@ package core
 import (
 	"cmp"
 	"context"
+	"errors"
+	"fmt"
 	"os"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
@@ -137,23 +142,430 @@ func TestDBMemory(t *testing.T) {
 
 	// with the current config, inserting 60 items and compacting 3 times should result in
 	// 1 fileblock at level 4 with 40 items and 1 fileblock at level 3 with 20 items
-	lsmtree.levels.Index.Ascend(func(i *db.BtreeItem[int64, int64]) bool {
-		i.Val.Each(func(v *db.Fileblock[int64]) bool {
-			t.Logf("Fileblock %s has %d items. Level: %d", v.UUID(), v.ItemCount, v.Level)
-			switch v.Level {
-			case 3:
-				assert.Equal(t, 20, v.ItemCount)
-			case 4:
-				assert.Equal(t, 40, v.ItemCount)
-			default:
-				t.Fatalf("Unexpected level %d", v.Level)
-			}
-			return true
-		})
+	lsmtree.levels.Index.Ascend(func(v *db.Fileblock[int64]) bool {
+		t.Logf("Fileblock %s has %d items. Level: %d", v.UUID(), v.ItemCount, v.Level)
+		switch v.Level {
+		case 3:
+			assert.Equal(t, 20, v.ItemCount)
+		case 4:
+			assert.Equal(t, 40, v.ItemCount)
+		default:
+			t.Fatalf("Unexpected level %d", v.Level)
+		}
 		return true
 	})
 }
 
+func TestLsmTreeConcurrency(t *testing.T) {
+	for _, flushers := range []int{0, 2} {
+		t.Run(fmt.Sprintf("Flushers%d", flushers), func(t *testing.T) {
+			cfg := db.NewDefaultConfig()
+			cfg.Filesystem = db.FilesystemTypeMap[db.FILESYSTEM_TYPE_MEMORY]
+			cfg.LevelFilesystems = nil
+			cfg.DbPath = "/tmp/db/concurrency"
+			cfg.Wal.MaxItems = 10
+			cfg.Wal.Flushers = flushers
+
+			lsmtree, err := NewLsmTree[int64, *db.Kv](cfg)
+			require.NoError(t, err)
+
+			const (
+				writers = 4
+				appends = 200
+			)
+
+			var (
+				wg      sync.WaitGroup
+				writing atomic.Int32
+			)
+			writing.Store(writers)
+
+			for w := 0; w < writers; w++ {
+				wg.Add(1)
+				go func(w int) {
+					defer wg.Done()
+					defer writing.Add(-1)
+					for i := 0; i < appends; i++ {
+						assert.NoError(t, lsmtree.Append(db.NewKv(fmt.Sprintf("instance%d", w%2), "cpu", []int64{int64(i)}, []int32{int32(i)})))
+					}
+				}(w)
+			}
+
+			for r := 0; r < 4; r++ {
+				wg.Add(1)
+				go func(r int) {
+					defer wg.Done()
+					for writing.Load() > 0 {
+						iter, found, err := lsmtree.Find(context.Background(), fmt.Sprintf("instance%d", r%2), "cpu", 0, appends)
+						assert.NoError(t, err)
+						if !found {
+							continue
+						}
+
+						// read the entries to catch any write to them made by the appends or the compaction
+						for entry, found, _ := iter.Next(); found; entry, found, _ = iter.Next() {
+							_ = entry.Min()
+							_ = entry.(*db.Kv).Ts
+						}
+					}
+				}(r)
+			}
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for writing.Load() > 0 {
+					assert.NoError(t, lsmtree.Compact())
+				}
+			}()
+
+			wg.Wait()
+			require.NoError(t, lsmtree.Close())
+
+			// every appended item must be found exactly once after the wal has been flushed
+			for i := 0; i < 2; i++ {
+				iter, found, err := lsmtree.Find(context.Background(), fmt.Sprintf("instance%d", i), "cpu", 0, appends)
+				require.NoError(t, err)
+				require.True(t, found)
+
+				total := 0
+				for entry, found, _ := iter.Next(); found; entry, found, _ = iter.Next() {
+					total += entry.Len()
+				}
+				assert.Equal(t, writers/2*appends, total)
+			}
+		})
+	}
+}
+
+func TestCloseWithStalledAppend(t *testing.T) {
+	cfg := db.NewDefaultConfig()
+	cfg.Filesystem = db.FilesystemTypeMap[db.FILESYSTEM_TYPE_MEMORY]
+	cfg.LevelFilesystems = nil
+	cfg.DbPath = t.TempDir()
+	cfg.Wal.MaxItems = 1
+	cfg.Wal.Flushers = 1
+	cfg.Wal.MaxPendingFlushes = 1
+
+	lsmtree, err := NewLsmTree[int64, *db.Kv](cfg)
+	require.NoError(t, err)
+
+	// the filesystem always fails, the flusher retries forever
+	require.NoError(t, lsmtree.wal.Close())
+	fbcreator := &mockFileblockCreator[int64]{
+		newFileblock: func(es *db.EntriesMap[int64], builder *db.MetadataBuilder[int64]) error {
+			return errors.New("disk full")
+		},
+	}
+	lsmtree.wal = newNMMemoryWal(cfg, fbcreator, newItemLimitWalFlushStrategy[int64](cfg.Wal.MaxItems))
+
+	require.NoError(t, lsmtree.Append(db.NewKv("instance1", "cpu", []int64{1}, []int32{1})))
+	appended := make(chan error)
+	go func() {
+		appended <- lsmtree.Append(db.NewKv("instance2", "cpu", []int64{1}, []int32{1}))
+	}()
+
+	select {
+	case <-appended:
+		t.Fatal("append didn't stall")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	closed := make(chan error)
+	go func() { closed <- lsmtree.Close() }()
+
+	select {
+	case err := <-appended:
+		assert.ErrorIs(t, err, ErrWalClosed)
+	case <-time.After(time.Second):
+		t.Fatal("close didn't release the stalled append")
+	}
+
+	select {
+	case err := <-closed:
+		// the frozen entries still can't be written
+		assert.ErrorContains(t, err, "disk full")
+	case <-time.After(time.Second):
+		t.Fatal("close didn't return")
+	}
+}
+
+func TestFindDuringFlush(t *testing.T) {
+	cfg := db.NewDefaultConfig()
+	cfg.Filesystem = db.FilesystemTypeMap[db.FILESYSTEM_TYPE_MEMORY]
+	cfg.LevelFilesystems = nil
+	cfg.DbPath = "/tmp/db/find_flush"
+	cfg.Wal.MaxItems = 10
+	cfg.Wal.Flushers = 2
+
+	lsmtree, err := NewLsmTree[int64, *db.Kv](cfg)
+	require.NoError(t, err)
+	defer lsmtree.Close()
+
+	const appends = 5000
+
+	var (
+		wg      sync.WaitGroup
+		writing atomic.Bool
+	)
+	writing.Store(true)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer writing.Store(false)
+		for i := 0; i < appends; i++ {
+			assert.NoError(t, lsmtree.Append(db.NewKv("instance1", "cpu", []int64{int64(i)}, []int32{int32(i)})))
+		}
+	}()
+
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for writing.Load() {
+				iter, found, err := lsmtree.Find(context.Background(), "instance1", "cpu", 0, appends)
+				assert.NoError(t, err)
+				if !found {
+					continue
+				}
+
+				// every timestamp was appended once, a value moving from the wal to a fileblock while
+				// Find runs must not be returned twice
+				seen := make(map[int64]struct{})
+				for entry, found, _ := iter.Next(); found; entry, found, _ = iter.Next() {
+					for _, ts := range entry.(*db.Kv).Ts {
+						if _, ok := seen[ts]; ok {
+							assert.Failf(t, "value returned twice", "%d", ts)
+						}
+						seen[ts] = struct{}{}
+					}
+				}
+				assert.NoError(t, iter.Close())
+			}
+		}()
+	}
+
+	wg.Wait()
+}
+
+func TestFindDuringCompaction(t *testing.T) {
+	cfg := db.NewDefaultConfig()
+	cfg.Filesystem = db.FilesystemTypeMap[db.FILESYSTEM_TYPE_MEMORY]
+	cfg.LevelFilesystems = nil
+	cfg.DbPath = "/tmp/db/find_compaction"
+	cfg.Wal.MaxItems = 5
+
+	lsmtree, err := NewLsmTree[int64, *db.Kv](cfg)
+	require.NoError(t, err)
+	defer lsmtree.Close()
+
+	// 4 fileblocks of 5 items
+	for i := int64(0); i < 20; i += 5 {
+		require.NoError(t, lsmtree.Append(db.NewKv("instance1", "cpu", []int64{i, i + 1, i + 2, i + 3, i + 4}, []int32{1, 2, 3, 4, 5})))
+	}
+
+	iter, found, err := lsmtree.Find(context.Background(), "instance1", "cpu", 0, 20)
+	require.NoError(t, err)
+	require.True(t, found)
+
+	// the fileblocks resolved by Find are replaced before the iterator has loaded them
+	require.NoError(t, lsmtree.Compact())
+
+	total := 0
+	for entry, found, _ := iter.Next(); found; entry, found, _ = iter.Next() {
+		total += entry.Len()
+	}
+	assert.Equal(t, 20, total)
+}
+
+func TestFindSortedSeries(t *testing.T) {
+	cfg := db.NewDefaultConfig()
+	cfg.Filesystem = db.FilesystemTypeMap[db.FILESYSTEM_TYPE_MEMORY]
+	cfg.LevelFilesystems = nil
+	cfg.DbPath = "/tmp/db/find_sorted"
+	cfg.Wal.MaxItems = 3
+
+	lsmtree, err := NewLsmTree[int64, *db.Kv](cfg)
+	require.NoError(t, err)
+	defer lsmtree.Close()
+
+	// cpu is split in 2 fileblocks and the wal, written out of order
+	for _, ts := range []int64{10, 9, 8, 3, 2, 1, 6} {
+		require.NoError(t, lsmtree.Append(db.NewKv("instance1", "cpu", []int64{ts}, []int32{int32(ts)})))
+	}
+	require.NoError(t, lsmtree.Append(db.NewKv("instance1", "mem", []int64{4}, []int32{4})))
+
+	iter, found, err := lsmtree.Find(context.Background(), "instance1", "", 0, 20)
+	require.NoError(t, err)
+	require.True(t, found)
+	defer iter.Close()
+
+	entry, found, err := iter.Next()
+	require.NoError(t, err)
+	require.True(t, found)
+	assert.Equal(t, "cpu", entry.SecondaryIndex())
+	assert.Equal(t, []int64{1, 2, 3, 6, 8, 9, 10}, entry.(*db.Kv).Ts)
+	assert.Equal(t, []int32{1, 2, 3, 6, 8, 9, 10}, entry.(*db.Kv).Val)
+
+	entry, found, err = iter.Next()
+	require.NoError(t, err)
+	require.True(t, found)
+	assert.Equal(t, "mem", entry.SecondaryIndex())
+
+	_, found, err = iter.Next()
+	assert.NoError(t, err)
+	assert.False(t, found)
+
+	// only the values in the range are returned
+	assert.Equal(t, []int64{1, 2, 3, 6, 8, 9}, findTimestamps(t, lsmtree, "instance1", "cpu", 1, 9))
+	// fileblocks that start before the range, or at its end, overlap it too
+	assert.Equal(t, []int64{2, 3, 6, 8}, findTimestamps(t, lsmtree, "instance1", "cpu", 2, 8))
+}
+
+func TestFindPage(t *testing.T) {
+	cfg := db.NewDefaultConfig()
+	cfg.Filesystem = db.FilesystemTypeMap[db.FILESYSTEM_TYPE_MEMORY]
+	cfg.LevelFilesystems = nil
+	cfg.DbPath = "/tmp/db/find_page"
+	cfg.Wal.MaxItems = 3
+
+	lsmtree, err := NewLsmTree[int64, *db.Kv](cfg)
+	require.NoError(t, err)
+	defer lsmtree.Close()
+
+	for ts := int64(1); ts < 8; ts++ {
+		require.NoError(t, lsmtree.Append(db.NewKv("instance1", "cpu", []int64{ts}, []int32{int32(ts)})))
+		require.NoError(t, lsmtree.Append(db.NewKv("instance1", "mem", []int64{ts}, []int32{int32(ts)})))
+	}
+
+	expected := map[string][]int64{
+		"cpu": findTimestamps(t, lsmtree, "instance1", "cpu", 0, 20),
+		"mem": findTimestamps(t, lsmtree, "instance1", "mem", 0, 20),
+	}
+
+	read := map[string][]int64{}
+	cursor := ""
+	pages := 0
+	for {
+		page, next, err := lsmtree.FindPage(context.Background(), "instance1", "", 0, 20, 3, cursor)
+		require.NoError(t, err)
+		pages++
+
+		for _, entry := range page {
+			read[entry.SecondaryIndex()] = append(read[entry.SecondaryIndex()], entry.(*db.Kv).Ts...)
+		}
+
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+
+	assert.Equal(t, expected, read)
+	assert.Equal(t, 5, pages)
+
+	_, _, err = lsmtree.FindPage(context.Background(), "instance1", "", 0, 20, 0, "")
+	assert.ErrorIs(t, err, db.ErrInvalidLimit)
+	_, _, err = lsmtree.FindPage(context.Background(), "instance1", "", 0, 20, 3, "not a cursor")
+	assert.ErrorIs(t, err, db.ErrInvalidCursor)
+}
+
+func TestFindMatching(t *testing.T) {
+	cfg := db.NewDefaultConfig()
+	cfg.Filesystem = db.FilesystemTypeMap[db.FILESYSTEM_TYPE_MEMORY]
+	cfg.LevelFilesystems = nil
+	cfg.DbPath = "/tmp/db/find_matching"
+	cfg.Wal.MaxItems = 3
+
+	lsmtree, err := NewLsmTree[int64, *db.Kv](cfg)
+	require.NoError(t, err)
+	defer lsmtree.Close()
+
+	// every primary index gets a fileblock, the last series stay in the wal
+	for _, pIdx := range []string{"instance-1", "instance-2", "host-1"} {
+		for _, sIdx := range []string{"cpu_user", "cpu_system", "mem"} {
+			require.NoError(t, lsmtree.Append(db.NewKv(pIdx, sIdx, []int64{1, 2}, []int32{1, 2})))
+		}
+	}
+	require.NoError(t, lsmtree.Append(db.NewKv("instance-3", "cpu_user", []int64{1}, []int32{1})))
+
+	primary, err := db.ParseFilter(db.PrimaryIndexFilterKind, "glob:instance-*")
+	require.NoError(t, err)
+	secondary, err := db.ParseFilter(db.SecondaryIndexFilterKind, "prefix:cpu_")
+	require.NoError(t, err)
+	notSystem, err := db.ParseFilter(db.SecondaryIndexFilterKind, "!cpu_system")
+	require.NoError(t, err)
+
+	iter, found, err := lsmtree.FindMatching(context.Background(), 0, 10, primary, secondary, notSystem)
+	require.NoError(t, err)
+	require.True(t, found)
+	defer iter.Close()
+
+	series := make([]string, 0)
+	for entry, found, err := iter.Next(); found; entry, found, err = iter.Next() {
+		require.NoError(t, err)
+		series = append(series, entry.PrimaryIndex()+"/"+entry.SecondaryIndex())
+	}
+	assert.Equal(t, []string{"instance-1/cpu_user", "instance-2/cpu_user", "instance-3/cpu_user"}, series)
+
+	_, found, err = lsmtree.FindMatching(context.Background(), 0, 10, db.PrefixFilter(db.PrimaryIndexFilterKind, "other"))
+	require.NoError(t, err)
+	assert.False(t, found)
+}
+
+func TestFindMatchingLabels(t *testing.T) {
+	cfg := db.NewDefaultConfig()
+	cfg.Filesystem = db.FilesystemTypeMap[db.FILESYSTEM_TYPE_MEMORY]
+	cfg.LevelFilesystems = nil
+	cfg.DbPath = "/tmp/db/find_matching_labels"
+	cfg.Wal.MaxItems = 2
+
+	lsmtree, err := NewLsmTree[int64, *db.LabeledEntry](cfg)
+	require.NoError(t, err)
+	defer lsmtree.Close()
+
+	for _, series := range []map[string]string{
+		{"host": "a", "region": "eu-west", "service": "api"},
+		{"host": "b", "region": "eu-central", "service": "db"},
+		{"host": "c", "region": "us-east", "service": "api"},
+		{"host": "d", "service": "api"},
+	} {
+		labels, err := db.NewLabels(series)
+		require.NoError(t, err)
+		require.NoError(t, lsmtree.Append(db.NewLabeledEntry("cpu", labels, []int64{1, 2}, []float64{0.5, 0.6})))
+	}
+
+	find := func(filters ...db.EntryFilter) []string {
+		iter, found, err := lsmtree.FindMatching(context.Background(), 0, 10, filters...)
+		require.NoError(t, err)
+		if !found {
+			return nil
+		}
+		defer iter.Close()
+
+		hosts := make([]string, 0)
+		for entry, found, err := iter.Next(); found; entry, found, err = iter.Next() {
+			require.NoError(t, err)
+			hosts = append(hosts, entry.(*db.LabeledEntry).Labels().Get("host"))
+		}
+		return hosts
+	}
+	filter := func(name, expr string) db.EntryFilter {
+		f, err := db.LabelFilter(name, expr)
+		require.NoError(t, err)
+		return f
+	}
+
+	// the fileblocks are found through the postings, the last series is still in the wal
+	assert.ElementsMatch(t, []string{"a", "c"}, find(filter("service", "api"), filter("region", "regex:.+")))
+	assert.ElementsMatch(t, []string{"a", "b"}, find(filter("region", "glob:eu-*")))
+	assert.ElementsMatch(t, []string{"b"}, find(filter("service", "!api"), filter("host", "glob:?"), filter("region", "!prefix:us")))
+	// nothing narrows down the series, every fileblock is read
+	assert.ElementsMatch(t, []string{"d"}, find(filter("region", "!regex:.+")))
+	assert.Empty(t, find(filter("region", "asia")))
+}
+
 func launchTestWithConfig(t *testing.T, cfg *db.Config, insertOrCompact bool) {
 	lsmtree, err := NewLsmTree[int64, *db.Kv](cfg)
 	require.NoError(t, err)
@@ -201,7 +613,7 @@ func launchTestWithConfig(t *testing.T, cfg *db.Config, insertOrCompact bool) {
 		require.NoError(t, err)
 	}
 
-	val, found, err := lsmtree.Find("instance1", "cpu", 0, 4)
+	val, found, err := lsmtree.Find(context.Background(), "instance1", "cpu", 0, 4)
 	require.NoError(t, err)
 	assert.True(t, found)
 	require.NotNil(t, val)
@@ -0,0 +1,126 @@
+package core
+
+import (
+	"cmp"
+	"errors"
+	"time"
+
+	db "github.com/sayden/streedb"
+	"github.com/sayden/streedb/fs"
+)
+
+var ErrRetentionUnsupported = errors.New("retention needs values that are unix milliseconds")
+
+// checkRetention returns ErrRetentionUnsupported if the values of O can't be compared with an age
+func checkRetention[O cmp.Ordered]() error {
+	if _, ok := millisToOrdered[O](0); !ok {
+		return ErrRetentionUnsupported
+	}
+
+	return nil
+}
+
+func newRetentionJanitor[O cmp.Ordered](cfg *db.Config, levels *fs.MultiFsLevels[O]) *retentionJanitor[O] {
+	return &retentionJanitor[O]{cfg: cfg, levels: levels, now: time.Now}
+}
+
+// retentionJanitor drops the values of the fileblocks that are older than the retention of their
+// primary index. Fileblocks that are fully expired are removed, fileblocks that straddle the
+// boundary are rewritten without their expired values.
+type retentionJanitor[O cmp.Ordered] struct {
+	cfg    *db.Config
+	levels *fs.MultiFsLevels[O]
+	now    func() time.Time
+}
+
+// expire makes a single pass over the fileblocks. It must not run concurrently with compactions or
+// deletes, a fileblock being rewritten could miss their changes.
+func (r *retentionJanitor[O]) expire() (err error) {
+	groups := r.levels.FileblocksByPrimaryIndex()
+	defer func() {
+		for _, group := range groups {
+			for _, fb := range group {
+				err = errors.Join(err, fb.Release())
+			}
+		}
+	}()
+
+	now := r.now()
+	for _, group := range groups {
+		maxAge := r.cfg.Retention.MaxAge(group[0].PrimaryIdx)
+		if maxAge <= 0 {
+			continue
+		}
+
+		// values at the cutoff or before it are expired
+		cutoff, _ := millisToOrdered[O](now.Add(-maxAge).UnixMilli())
+		for _, fb := range group {
+			if err = r.expireFileblock(fb, cutoff); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (r *retentionJanitor[O]) expireFileblock(fb *db.Fileblock[O], cutoff O) error {
+	if *fb.Min > cutoff {
+		return nil
+	}
+
+	if *fb.Max > cutoff {
+		es, err := fb.Load()
+		if err != nil {
+			return errors.Join(errors.New("failed to load expiring fileblock"), err)
+		}
+
+		expired := make([]string, 0)
+		es.Range(func(key string, entry db.Entry[O]) bool {
+			entry.DeleteRange(*fb.Min, cutoff)
+			if entry.Len() == 0 {
+				expired = append(expired, key)
+			}
+			return true
+		})
+		for _, key := range expired {
+			es.Delete(key)
+		}
+
+		// tombstones can leave nothing to rewrite. The rewritten fileblock takes the place of the
+		// expiring one in a single edit, a crash doesn't leave both
+		if es.Size() > 0 {
+			builder := db.NewMetadataBuilder[O](r.cfg).WithLevel(fb.Level).WithWalLsn(fb.WalLsn)
+			if err = r.levels.ReplaceFile(fb, es, builder); err != nil {
+				return errors.Join(errors.New("failed to rewrite expiring fileblock"), err)
+			}
+
+			return nil
+		}
+	}
+
+	if err := r.levels.RemoveFile(fb); err != nil {
+		return errors.Join(errors.New("failed to remove expired fileblock"), err)
+	}
+
+	return nil
+}
+
+// millisToOrdered converts unix milliseconds to O, it returns false if O isn't numeric
+func millisToOrdered[O cmp.Ordered](ms int64) (O, bool) {
+	var o O
+	switch v := any(&o).(type) {
+	case *int64:
+		*v = ms
+	case *int:
+		*v = int(ms)
+	case *uint64:
+		*v = uint64(max(ms, 0))
+	case *float64:
+		*v = float64(ms)
+	default:
+		return o, false
+	}
+
+	return o, true
+}
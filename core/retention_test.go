@@ -0,0 +1,81 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	db "github.com/sayden/streedb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetention(t *testing.T) {
+	cfg := db.NewDefaultConfig()
+	cfg.Filesystem = db.FilesystemTypeMap[db.FILESYSTEM_TYPE_MEMORY]
+	cfg.LevelFilesystems = nil
+	cfg.DbPath = "/tmp/db/retention"
+	cfg.Wal.MaxItems = 5
+	cfg.Retention = db.RetentionCfg{
+		MaxAgeMs:             1000,
+		PrimaryIndexMaxAgeMs: map[string]int64{"instance2": 0},
+	}
+
+	lsmtree, err := NewLsmTree[int64, *db.Kv](cfg)
+	require.NoError(t, err)
+	defer lsmtree.Close()
+	lsmtree.janitor.now = func() time.Time { return time.UnixMilli(100_000) }
+
+	appendTs := func(pIdx string, ts ...int64) {
+		for _, ts := range ts {
+			require.NoError(t, lsmtree.Append(db.NewKv(pIdx, "cpu", []int64{ts}, []int32{int32(ts)})))
+		}
+	}
+	// an expired fileblock, one that straddles the cutoff at 99_000 and one that is kept
+	appendTs("instance1", 90_000, 90_001, 90_002, 90_003, 90_004)
+	appendTs("instance1", 98_998, 98_999, 99_000, 99_001, 99_002)
+	appendTs("instance1", 99_500, 99_501, 99_502, 99_503, 99_504)
+	// never expires
+	appendTs("instance2", 1, 2, 3, 4, 5)
+	require.Len(t, lsmtree.levels.Fileblocks(), 4)
+
+	require.NoError(t, lsmtree.Expire())
+
+	assert.ElementsMatch(t, []int64{99_001, 99_002, 99_500, 99_501, 99_502, 99_503, 99_504}, findTimestamps(t, lsmtree, "instance1", "cpu", 0, 200_000))
+	assert.ElementsMatch(t, []int64{1, 2, 3, 4, 5}, findTimestamps(t, lsmtree, "instance2", "cpu", 0, 200_000))
+	require.Len(t, lsmtree.levels.Fileblocks(), 3)
+
+	// nothing else to expire
+	require.NoError(t, lsmtree.Expire())
+	require.Len(t, lsmtree.levels.Fileblocks(), 3)
+}
+
+func TestRetentionJanitor(t *testing.T) {
+	cfg := db.NewDefaultConfig()
+	cfg.Filesystem = db.FilesystemTypeMap[db.FILESYSTEM_TYPE_MEMORY]
+	cfg.LevelFilesystems = nil
+	cfg.DbPath = "/tmp/db/retention_janitor"
+	cfg.Wal.MaxItems = 2
+	cfg.Retention = db.RetentionCfg{MaxAgeMs: 1000, CheckIntervalMs: 10}
+
+	lsmtree, err := NewLsmTree[int64, *db.Kv](cfg)
+	require.NoError(t, err)
+
+	old := time.Now().Add(-time.Hour).UnixMilli()
+	require.NoError(t, lsmtree.Append(db.NewKv("instance1", "cpu", []int64{old, old + 1}, []int32{1, 2})))
+
+	assert.Eventually(t, func() bool { return len(lsmtree.levels.Fileblocks()) == 0 }, time.Second, 10*time.Millisecond)
+	require.NoError(t, lsmtree.Close())
+}
+
+func TestMillisToOrdered(t *testing.T) {
+	i, ok := millisToOrdered[int64](10)
+	assert.True(t, ok)
+	assert.Equal(t, int64(10), i)
+
+	f, ok := millisToOrdered[float64](10)
+	assert.True(t, ok)
+	assert.Equal(t, float64(10), f)
+
+	_, ok = millisToOrdered[string](10)
+	assert.False(t, ok)
+}
@@ -0,0 +1,100 @@
+package core
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"sync"
+
+	db "github.com/sayden/streedb"
+)
+
+// Snapshot is a read view of the LsmTree at the moment it was taken. Find returns the same results
+// on every call, regardless of later appends, deletes, wal flushes or compactions. The fileblocks of
+// the snapshot are pinned until Close is called, so a snapshot must always be closed.
+type Snapshot[O cmp.Ordered] struct {
+	walEntries []db.Entry[O]
+	fileblocks []*db.Fileblock[O]
+	index      *db.IntervalIndex[O]
+
+	mu     sync.Mutex
+	closed bool
+}
+
+var ErrSnapshotClosed = errors.New("snapshot is closed")
+
+// Snapshot captures the entries of the wal and the fileblocks of the levels. A running compaction or
+// delete is waited for, so that the snapshot never sees a compaction result next to its sources.
+func (l *LsmTree[O, E]) Snapshot() *Snapshot[O] {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	l.compactMu.Lock()
+	defer l.compactMu.Unlock()
+
+	s := &Snapshot[O]{index: db.NewIntervalIndex[O]()}
+	s.walEntries = l.wal.Snapshot(func() {
+		s.fileblocks = l.levels.AcquireFileblocks()
+	})
+
+	// views keep deletes made after the snapshot out of it. Pinning a view pins its fileblock
+	for _, fb := range s.fileblocks {
+		s.index.Insert(fb.View())
+	}
+
+	return s
+}
+
+func (s *Snapshot[O]) Find(ctx context.Context, pIdx, sIdx string, min, max O) (db.EntryIterator[O], bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil, false, ErrSnapshotClosed
+	}
+
+	entries := make([]db.Entry[O], 0)
+	for _, entry := range s.walEntries {
+		if walEntryMatches(entry, pIdx, sIdx, min, max) {
+			// the caller is free to modify what it gets
+			entries = append(entries, entry.Clone())
+		}
+	}
+	walFound := len(entries) > 0
+	db.SortFragments(entries)
+
+	dbIter, dbFound, err := s.index.AscendRangeWithFilters(ctx, min, max, db.PrimaryIndexFilter(pIdx), db.SecondaryIndexFilter[O](sIdx))
+	if err != nil {
+		return nil, false, err
+	}
+
+	if !walFound && !dbFound {
+		return nil, false, dbIter.Close()
+	}
+
+	return db.NewSortedMergeIterator(
+		db.NewRangeIterator[O](db.NewListIterator(entries), min, max),
+		db.NewRangeIterator(dbIter, min, max),
+	), true, nil
+}
+
+// Close releases the fileblocks of the snapshot. Iterators returned by Find pin the fileblocks they
+// read on their own, so they keep working until they have been read or closed.
+func (s *Snapshot[O]) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+
+	errs := make([]error, 0)
+	for _, fb := range s.fileblocks {
+		if err := fb.Release(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
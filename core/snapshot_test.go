@@ -0,0 +1,170 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	db "github.com/sayden/streedb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// snapshotTimestamps reads every item of instance1 in the snapshot
+func snapshotTimestamps(t *testing.T, s *Snapshot[int64]) []int64 {
+	iter, found, err := s.Find(context.Background(), "instance1", "cpu", 0, 1_000_000)
+	require.NoError(t, err)
+	if !found {
+		return nil
+	}
+
+	ts := make([]int64, 0)
+	for entry, found, _ := iter.Next(); found; entry, found, _ = iter.Next() {
+		ts = append(ts, entry.(*db.Kv).Ts...)
+	}
+
+	return ts
+}
+
+func TestSnapshot(t *testing.T) {
+	cfg := db.NewDefaultConfig()
+	cfg.Filesystem = db.FilesystemTypeMap[db.FILESYSTEM_TYPE_MEMORY]
+	cfg.LevelFilesystems = nil
+	cfg.DbPath = "/tmp/db/snapshot"
+	cfg.Wal.MaxItems = 5
+
+	lsmtree, err := NewLsmTree[int64, *db.Kv](cfg)
+	require.NoError(t, err)
+	defer lsmtree.Close()
+
+	// 2 fileblocks and 3 items in the wal
+	for i := int64(0); i < 13; i++ {
+		require.NoError(t, lsmtree.Append(db.NewKv("instance1", "cpu", []int64{i}, []int32{int32(i)})))
+	}
+
+	snapshot := lsmtree.Snapshot()
+	assert.Len(t, snapshotTimestamps(t, snapshot), 13)
+
+	// a flush and a compaction later, the snapshot still sees the same data
+	for i := int64(13); i < 20; i++ {
+		require.NoError(t, lsmtree.Append(db.NewKv("instance1", "cpu", []int64{i}, []int32{int32(i)})))
+	}
+	require.NoError(t, lsmtree.Compact())
+
+	assert.ElementsMatch(t, []int64{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}, snapshotTimestamps(t, snapshot))
+
+	_, found, err := snapshot.Find(context.Background(), "instance2", "cpu", 0, 20)
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	require.NoError(t, snapshot.Close())
+	_, _, err = snapshot.Find(context.Background(), "instance1", "cpu", 0, 20)
+	assert.ErrorIs(t, err, ErrSnapshotClosed)
+
+	iter, found, err := lsmtree.Find(context.Background(), "instance1", "cpu", 0, 20)
+	require.NoError(t, err)
+	require.True(t, found)
+	total := 0
+	for entry, found, _ := iter.Next(); found; entry, found, _ = iter.Next() {
+		total += entry.Len()
+	}
+	assert.Equal(t, 20, total)
+}
+
+func TestSnapshotIteratorAfterClose(t *testing.T) {
+	cfg := db.NewDefaultConfig()
+	cfg.Filesystem = db.FilesystemTypeMap[db.FILESYSTEM_TYPE_MEMORY]
+	cfg.LevelFilesystems = nil
+	cfg.DbPath = "/tmp/db/snapshot_close"
+	cfg.Wal.MaxItems = 5
+
+	lsmtree, err := NewLsmTree[int64, *db.Kv](cfg)
+	require.NoError(t, err)
+	defer lsmtree.Close()
+
+	// 4 fileblocks, the iterator loads them one at a time
+	for i := int64(0); i < 20; i++ {
+		require.NoError(t, lsmtree.Append(db.NewKv("instance1", "cpu", []int64{i}, []int32{int32(i)})))
+	}
+
+	snapshot := lsmtree.Snapshot()
+	iter, found, err := snapshot.Find(context.Background(), "instance1", "cpu", 0, 20)
+	require.NoError(t, err)
+	require.True(t, found)
+	require.NoError(t, snapshot.Close())
+
+	// the compaction removes the fileblocks that the iterator hasn't loaded yet
+	require.NoError(t, lsmtree.Compact())
+
+	total := 0
+	entry, found, err := iter.Next()
+	for ; found; entry, found, err = iter.Next() {
+		total += entry.Len()
+	}
+	require.NoError(t, err)
+	assert.Equal(t, 20, total)
+	require.NoError(t, iter.Close())
+}
+
+func TestSnapshotConsistency(t *testing.T) {
+	for _, flushers := range []int{0, 2} {
+		t.Run(fmt.Sprintf("Flushers%d", flushers), func(t *testing.T) {
+			cfg := db.NewDefaultConfig()
+			cfg.Filesystem = db.FilesystemTypeMap[db.FILESYSTEM_TYPE_MEMORY]
+			cfg.LevelFilesystems = nil
+			cfg.DbPath = "/tmp/db/snapshot_consistency"
+			cfg.Wal.MaxItems = 4
+			cfg.Wal.Flushers = flushers
+
+			lsmtree, err := NewLsmTree[int64, *db.Kv](cfg)
+			require.NoError(t, err)
+			defer lsmtree.Close()
+
+			var (
+				wg      sync.WaitGroup
+				writing atomic.Bool
+			)
+			writing.Store(true)
+
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				defer writing.Store(false)
+				for i := int64(0); i < 300; i++ {
+					assert.NoError(t, lsmtree.Append(db.NewKv("instance1", "cpu", []int64{i}, []int32{int32(i)})))
+				}
+			}()
+			go func() {
+				defer wg.Done()
+				for writing.Load() {
+					assert.NoError(t, lsmtree.Compact())
+				}
+			}()
+
+			// items are appended one by one, so any consistent view holds exactly the first n of them,
+			// no matter if they are in the wal or in fileblocks
+			for writing.Load() {
+				snapshot := lsmtree.Snapshot()
+				first := snapshotTimestamps(t, snapshot)
+				second := snapshotTimestamps(t, snapshot)
+				require.NoError(t, snapshot.Close())
+
+				assert.ElementsMatch(t, first, second)
+
+				seen := make(map[int64]struct{}, len(first))
+				for _, ts := range first {
+					seen[ts] = struct{}{}
+				}
+				assert.Len(t, seen, len(first), "duplicated items")
+				for i := int64(0); i < int64(len(first)); i++ {
+					_, ok := seen[i]
+					require.True(t, ok, "missing item %d of %d", i, len(first))
+				}
+			}
+
+			wg.Wait()
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package core
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"path"
+
+	db "github.com/sayden/streedb"
+)
+
+// newDiskWal opens the log in DbPath and replays the entries that were never flushed into a
+// memoryWal before returning.
+func newDiskWal[O cmp.Ordered, E db.Entry[O]](cfg *db.Config, fbc db.FileblockCreator[O], persistStrategies ...db.WalFlushStrategy[O]) (db.Wal[O], error) {
+	// a crash after writing a fileblock but before recording its flush would replay its entries
+	var written map[string]uint64
+	if r, ok := fbc.(db.WalLsnReader); ok {
+		written = r.WalLsns()
+	}
+
+	walLog, pending, err := openWalLog(path.Join(cfg.DbPath, "wal"), &cfg.Wal, written)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &diskWal[O, E]{
+		log:       walLog,
+		memoryWal: newNMMemoryWal(cfg, fbc, persistStrategies...).(*memoryWal[O]),
+	}
+	// once a fileblock has been created, the log doesn't need to replay its entries anymore
+	w.memoryWal.onFlushed = w.log.markFlushed
+
+	// a tombstone might not have reached every fileblock before the crash. Fileblocks written after
+	// it might not have been marked as flushed either, their values are newer than the tombstone
+	var onDelete func(db.Tombstone[O], uint64) error
+	if tw, ok := fbc.(db.TombstoneWriter[O]); ok {
+		onDelete = tw.ReplayTombstone
+	}
+
+	for _, record := range pending {
+		if record.Kind == walRecordTombstone {
+			var t db.Tombstone[O]
+			if err = json.Unmarshal(record.Entry, &t); err != nil {
+				return nil, errors.Join(errors.New("error decoding wal tombstone"), err)
+			}
+
+			var replay func() error
+			if onDelete != nil {
+				replay = func() error { return onDelete(t, record.Lsn) }
+			}
+			if err = w.memoryWal.delete(t, recordLsn(record), replay); err != nil {
+				return nil, errors.Join(errors.New("error replaying wal tombstone"), err)
+			}
+			continue
+		}
+
+		var entry E
+		if err = json.Unmarshal(record.Entry, &entry); err != nil {
+			return nil, errors.Join(errors.New("error decoding wal entry"), err)
+		}
+
+		if err = w.memoryWal.append(entry, recordLsn(record)); err != nil {
+			return nil, errors.Join(errors.New("error replaying wal entry"), err)
+		}
+	}
+
+	return w, nil
+}
+
+// recordLsn returns the lsn of a replayed record, which is already in the log.
+func recordLsn(record *walRecord) func() (uint64, error) {
+	return func() (uint64, error) { return record.Lsn, nil }
+}
+
+// diskWal is a write-ahead log that writes every entry to a segmented log on disk before keeping it
+// in memory. Entries are served from memory and flushed to fileblocks like in memoryWal, once a
+// fileblock has been created the log is truncated.
+//
+// An entry takes its lsn and is inserted in memory under the same lock, so that a flushed map covers
+// every lsn it's marked with. Appends wait for the sync of the log after releasing it, an entry can
+// be read before Append returns.
+type diskWal[O cmp.Ordered, E db.Entry[O]] struct {
+	memoryWal *memoryWal[O]
+	log       *walLog
+}
+
+func (w *diskWal[O, E]) Append(d db.Entry[O]) error {
+	if err := w.memoryWal.admit(); err != nil {
+		return err
+	}
+
+	byt, err := json.Marshal(d)
+	if err != nil {
+		return errors.Join(errors.New("error encoding wal entry"), err)
+	}
+
+	// the lsn is taken while the memory wal is locked, the fsync is waited for after unlocking it
+	var lsn uint64
+	err = w.memoryWal.append(d, func() (uint64, error) {
+		lsn, err = w.log.append(walRecordEntry, d.PrimaryIndex(), byt)
+		return lsn, err
+	})
+	if err != nil {
+		return err
+	}
+
+	return w.log.sync(lsn)
+}
+
+func (w *diskWal[O, E]) Find(pIdx, sIdx string, min, max O) (db.EntryIterator[O], bool) {
+	return w.memoryWal.Find(pIdx, sIdx, min, max)
+}
+
+func (w *diskWal[O, E]) FindMatching(min, max O, filters ...db.EntryFilter) (db.EntryIterator[O], bool) {
+	return w.memoryWal.FindMatching(min, max, filters...)
+}
+
+func (w *diskWal[O, E]) FindSeries(pIdx, sIdx string) (db.EntryIterator[O], bool) {
+	return w.memoryWal.FindSeries(pIdx, sIdx)
+}
+
+func (w *diskWal[O, E]) View(onView func(db.WalReader[O]) error) error {
+	return w.memoryWal.View(onView)
+}
+
+func (w *diskWal[O, E]) Snapshot(onSnapshot func()) []db.Entry[O] {
+	return w.memoryWal.Snapshot(onSnapshot)
+}
+
+// Delete writes the tombstone to the log before applying it, so that it's replayed after a crash
+// until the entries it masks have been flushed.
+func (w *diskWal[O, E]) Delete(t db.Tombstone[O], onDelete func() error) error {
+	byt, err := json.Marshal(t)
+	if err != nil {
+		return errors.Join(errors.New("error encoding wal tombstone"), err)
+	}
+
+	var lsn uint64
+	err = w.memoryWal.delete(t, func() (uint64, error) {
+		lsn, err = w.log.append(walRecordTombstone, t.PrimaryIdx, byt)
+		return lsn, err
+	}, onDelete)
+	if err != nil {
+		return err
+	}
+
+	return w.log.sync(lsn)
+}
+
+func (w *diskWal[O, E]) stop() {
+	w.memoryWal.stop()
+}
+
+func (w *diskWal[O, E]) Close() error {
+	return errors.Join(w.memoryWal.Close(), w.log.close())
+}
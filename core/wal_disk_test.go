@@ -0,0 +1,356 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path"
+	"sync"
+	"testing"
+
+	db "github.com/sayden/streedb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiskWal(t *testing.T) {
+	t.Cleanup(func() { os.RemoveAll("/tmp/db/disk_wal") })
+
+	cfg := db.NewDefaultConfig()
+	cfg.DbPath = "/tmp/db/disk_wal"
+	cfg.Wal.MaxItems = 6
+
+	fbc := &mockFileblockCreator[int64]{
+		newFileblock: func(es *db.EntriesMap[int64], builder *db.MetadataBuilder[int64]) error { return nil },
+	}
+
+	segments := func() []string {
+		files, err := os.ReadDir(path.Join(cfg.DbPath, "wal"))
+		require.NoError(t, err)
+		names := make([]string, 0, len(files))
+		for _, f := range files {
+			names = append(names, f.Name())
+		}
+		return names
+	}
+
+	t.Run("ReplayAfterCrash", func(t *testing.T) {
+		wal, err := newDiskWal[int64, *db.Kv](cfg, fbc, newItemLimitWalFlushStrategy[int64](cfg.Wal.MaxItems))
+		require.NoError(t, err)
+
+		require.NoError(t, wal.Append(db.NewKv("instance1", "cpu", []int64{1, 2}, []int32{1, 2})))
+		require.NoError(t, wal.Append(db.NewKv("instance1", "mem", []int64{1, 2}, []int32{3, 4})))
+		require.NoError(t, wal.Append(db.NewKv("instance2", "cpu", []int64{3}, []int32{5})))
+
+		// simulate a crash, nothing is flushed from memory
+		require.NoError(t, wal.(*diskWal[int64, *db.Kv]).log.close())
+		require.Equal(t, 0, fbc.newFileblockCount)
+
+		wal, err = newDiskWal[int64, *db.Kv](cfg, fbc, newItemLimitWalFlushStrategy[int64](cfg.Wal.MaxItems))
+		require.NoError(t, err)
+
+		iter, found := wal.Find("instance1", "mem", 0, 10)
+		require.True(t, found)
+		entry, found, err := iter.Next()
+		require.NoError(t, err)
+		require.True(t, found)
+		assert.Equal(t, []int64{1, 2}, entry.(*db.Kv).Ts)
+		assert.Equal(t, []int32{3, 4}, entry.(*db.Kv).Val)
+
+		_, found = wal.Find("instance2", "cpu", 0, 10)
+		assert.True(t, found)
+
+		require.NoError(t, wal.Close())
+		assert.Equal(t, 2, fbc.newFileblockCount)
+		assert.Empty(t, segments())
+	})
+
+	t.Run("TruncateAfterFlush", func(t *testing.T) {
+		fbc.newFileblockCount = 0
+		cfg.Wal.SegmentSizeBytes = 1
+
+		wal, err := newDiskWal[int64, *db.Kv](cfg, fbc, newItemLimitWalFlushStrategy[int64](cfg.Wal.MaxItems))
+		require.NoError(t, err)
+
+		require.NoError(t, wal.Append(db.NewKv("instance1", "cpu", []int64{1, 2, 3}, []int32{1, 2, 3})))
+		require.NoError(t, wal.Append(db.NewKv("instance2", "cpu", []int64{1}, []int32{1})))
+		assert.Len(t, segments(), 3)
+
+		// instance1 is flushed but instance2 is still in the first segments
+		require.NoError(t, wal.Append(db.NewKv("instance1", "cpu", []int64{4, 5, 6}, []int32{4, 5, 6})))
+		require.Equal(t, 1, fbc.newFileblockCount)
+		assert.Len(t, segments(), 4)
+
+		// a crash now must not replay the flushed entries of instance1
+		require.NoError(t, wal.(*diskWal[int64, *db.Kv]).log.close())
+		wal, err = newDiskWal[int64, *db.Kv](cfg, fbc, newItemLimitWalFlushStrategy[int64](cfg.Wal.MaxItems))
+		require.NoError(t, err)
+
+		_, found := wal.Find("instance1", "cpu", 0, 10)
+		assert.False(t, found)
+		_, found = wal.Find("instance2", "cpu", 0, 10)
+		assert.True(t, found)
+
+		require.NoError(t, wal.Close())
+		assert.Equal(t, 2, fbc.newFileblockCount)
+		assert.Empty(t, segments())
+	})
+
+	t.Run("ReplayTombstone", func(t *testing.T) {
+		fbc.newFileblockCount = 0
+
+		wal, err := newDiskWal[int64, *db.Kv](cfg, fbc, newItemLimitWalFlushStrategy[int64](cfg.Wal.MaxItems))
+		require.NoError(t, err)
+		require.NoError(t, wal.Append(db.NewKv("instance1", "cpu", []int64{1, 2, 3}, []int32{1, 2, 3})))
+		require.NoError(t, wal.Append(db.NewKv("instance2", "cpu", []int64{1}, []int32{1})))
+
+		tombstone, err := db.NewTombstone[int64]("instance1", "cpu", 2, 2)
+		require.NoError(t, err)
+		require.NoError(t, wal.Delete(tombstone, nil))
+
+		// everything of instance2 is deleted, nothing is left to replay for it
+		tombstone, err = db.NewTombstone[int64]("instance2", "", 0, 10)
+		require.NoError(t, err)
+		require.NoError(t, wal.Delete(tombstone, nil))
+
+		// appended after the delete, so it is kept
+		require.NoError(t, wal.Append(db.NewKv("instance1", "cpu", []int64{2}, []int32{4})))
+
+		require.NoError(t, wal.(*diskWal[int64, *db.Kv]).log.close())
+		wal, err = newDiskWal[int64, *db.Kv](cfg, fbc, newItemLimitWalFlushStrategy[int64](cfg.Wal.MaxItems))
+		require.NoError(t, err)
+
+		iter, found := wal.Find("instance1", "cpu", 0, 10)
+		require.True(t, found)
+		entry, _, err := iter.Next()
+		require.NoError(t, err)
+		assert.Equal(t, []int64{1, 2, 3}, entry.(*db.Kv).Ts)
+		assert.Equal(t, []int32{1, 4, 3}, entry.(*db.Kv).Val)
+
+		_, found = wal.Find("instance2", "cpu", 0, 10)
+		assert.False(t, found)
+
+		require.NoError(t, wal.Close())
+		assert.Equal(t, 1, fbc.newFileblockCount)
+		assert.Empty(t, segments())
+	})
+
+	t.Run("TornWrite", func(t *testing.T) {
+		cfg.Wal.SegmentSizeBytes = 1024 * 1024
+
+		wal, err := newDiskWal[int64, *db.Kv](cfg, fbc, newItemLimitWalFlushStrategy[int64](cfg.Wal.MaxItems))
+		require.NoError(t, err)
+		require.NoError(t, wal.Append(db.NewKv("instance1", "cpu", []int64{1}, []int32{1})))
+		require.NoError(t, wal.(*diskWal[int64, *db.Kv]).log.close())
+
+		// half written record at the end of the segment
+		names := segments()
+		require.Len(t, names, 1)
+		f, err := os.OpenFile(path.Join(cfg.DbPath, "wal", names[0]), os.O_APPEND|os.O_WRONLY, 0644)
+		require.NoError(t, err)
+		_, err = f.Write([]byte{0xff, 0x00, 0x00, 0x00, 0x01})
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+
+		wal, err = newDiskWal[int64, *db.Kv](cfg, fbc, newItemLimitWalFlushStrategy[int64](cfg.Wal.MaxItems))
+		require.NoError(t, err)
+
+		_, found := wal.Find("instance1", "cpu", 0, 10)
+		assert.True(t, found)
+		require.NoError(t, wal.Close())
+	})
+
+	t.Run("SyncFailure", func(t *testing.T) {
+		cfg.Wal.SyncMode = db.WAL_SYNC_ALWAYS
+
+		wal, err := newDiskWal[int64, *db.Kv](cfg, fbc, newItemLimitWalFlushStrategy[int64](cfg.Wal.MaxItems))
+		require.NoError(t, err)
+		require.NoError(t, wal.Append(db.NewKv("instance1", "cpu", []int64{1}, []int32{1})))
+
+		// a pipe can't be fsynced
+		_, pipe, err := os.Pipe()
+		require.NoError(t, err)
+		defer pipe.Close()
+
+		l := wal.(*diskWal[int64, *db.Kv]).log
+		l.mu.Lock()
+		segment := l.active
+		l.active = pipe
+		l.mu.Unlock()
+		assert.ErrorIs(t, wal.Append(db.NewKv("instance1", "cpu", []int64{2}, []int32{2})), ErrWalSyncFailed)
+
+		// the log stays failed even if the segment could be synced again
+		l.mu.Lock()
+		l.active = segment
+		l.mu.Unlock()
+		assert.ErrorIs(t, wal.Append(db.NewKv("instance1", "cpu", []int64{3}, []int32{3})), ErrWalSyncFailed)
+		assert.ErrorIs(t, l.close(), ErrWalSyncFailed)
+
+		// reopening it replays what reached the segment
+		wal, err = newDiskWal[int64, *db.Kv](cfg, fbc, newItemLimitWalFlushStrategy[int64](cfg.Wal.MaxItems))
+		require.NoError(t, err)
+
+		iter, found := wal.Find("instance1", "cpu", 0, 10)
+		require.True(t, found)
+		entry, _, err := iter.Next()
+		require.NoError(t, err)
+		assert.Equal(t, []int64{1}, entry.(*db.Kv).Ts)
+		require.NoError(t, wal.Close())
+		assert.Empty(t, segments())
+	})
+
+	t.Run("CrashBeforeFlushRecord", func(t *testing.T) {
+		fbc := &walLsnFileblockCreator{lsns: make(map[string]uint64)}
+		fbc.newFileblock = func(es *db.EntriesMap[int64], builder *db.MetadataBuilder[int64]) error {
+			fbc.lsns[builder.PrimaryIdx] = builder.WalLsn
+			return nil
+		}
+
+		wal, err := newDiskWal[int64, *db.Kv](cfg, fbc, newItemLimitWalFlushStrategy[int64](cfg.Wal.MaxItems))
+		require.NoError(t, err)
+
+		// the process dies after writing the fileblock, before its flush is recorded
+		wal.(*diskWal[int64, *db.Kv]).memoryWal.onFlushed = func(string, uint64) error { return errors.New("crash") }
+		require.NoError(t, wal.Append(db.NewKv("instance1", "cpu", []int64{1, 2, 3}, []int32{1, 2, 3})))
+		require.Error(t, wal.Append(db.NewKv("instance1", "cpu", []int64{4, 5, 6}, []int32{4, 5, 6})))
+		require.Equal(t, 1, fbc.newFileblockCount)
+		require.NoError(t, wal.(*diskWal[int64, *db.Kv]).log.close())
+
+		wal, err = newDiskWal[int64, *db.Kv](cfg, fbc, newItemLimitWalFlushStrategy[int64](cfg.Wal.MaxItems))
+		require.NoError(t, err)
+
+		// the entries are already in the fileblock, replaying them would write them twice
+		_, found := wal.Find("instance1", "cpu", 0, 10)
+		assert.False(t, found)
+
+		// new entries follow the ones in the fileblock, even if no segment holds those anymore
+		require.NoError(t, wal.Append(db.NewKv("instance1", "cpu", []int64{7}, []int32{7})))
+		assert.Greater(t, wal.(*diskWal[int64, *db.Kv]).log.lsn, fbc.lsns["instance1"])
+
+		require.NoError(t, wal.Close())
+		assert.Equal(t, 2, fbc.newFileblockCount)
+		assert.Empty(t, segments())
+	})
+}
+
+func TestDiskWalSyncModes(t *testing.T) {
+	t.Cleanup(func() { os.RemoveAll("/tmp/db/disk_wal_sync") })
+
+	for _, mode := range []db.WalSyncMode{db.WAL_SYNC_ALWAYS, db.WAL_SYNC_BATCH, db.WAL_SYNC_NONE} {
+		cfg := db.NewDefaultConfig()
+		cfg.DbPath = "/tmp/db/disk_wal_sync"
+		cfg.Wal.SyncMode = mode
+		cfg.Wal.SegmentSizeBytes = 4096
+
+		fbc := &mockFileblockCreator[int64]{
+			newFileblock: func(es *db.EntriesMap[int64], builder *db.MetadataBuilder[int64]) error { return nil },
+		}
+
+		wal, err := newDiskWal[int64, *db.Kv](cfg, fbc)
+		require.NoError(t, err)
+
+		// every goroutine appends to its own primary index, so that each one ends up in a single fileblock
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < 50; j++ {
+					assert.NoError(t, wal.Append(db.NewKv(fmt.Sprintf("instance%d", i), "cpu", []int64{int64(j)}, []int32{int32(j)})))
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		require.NoError(t, wal.(*diskWal[int64, *db.Kv]).log.close())
+
+		wal, err = newDiskWal[int64, *db.Kv](cfg, fbc)
+		require.NoError(t, err)
+		for i := 0; i < 8; i++ {
+			iter, found := wal.Find(fmt.Sprintf("instance%d", i), "cpu", 0, 50)
+			require.True(t, found)
+			entry, _, err := iter.Next()
+			require.NoError(t, err)
+			assert.Equal(t, 50, entry.Len())
+		}
+
+		require.NoError(t, wal.Close())
+		assert.Equal(t, 8, fbc.newFileblockCount)
+	}
+}
+
+func TestDiskWalConcurrentAppendsToPrimaryIndex(t *testing.T) {
+	t.Cleanup(func() { os.RemoveAll("/tmp/db/disk_wal_concurrent") })
+
+	cfg := db.NewDefaultConfig()
+	cfg.DbPath = "/tmp/db/disk_wal_concurrent"
+	cfg.Wal.SyncMode = db.WAL_SYNC_BATCH
+	cfg.Wal.MaxItems = 7
+	cfg.Wal.MaxElapsedTimeMs = 0
+
+	flushed := make(map[int64]struct{})
+	fbc := &mockFileblockCreator[int64]{
+		newFileblock: func(es *db.EntriesMap[int64], builder *db.MetadataBuilder[int64]) error {
+			for _, ts := range es.Get("cpu").(*db.Kv).Ts {
+				flushed[ts] = struct{}{}
+			}
+			return nil
+		},
+	}
+
+	wal, err := newDiskWal[int64, *db.Kv](cfg, fbc, newItemLimitWalFlushStrategy[int64](cfg.Wal.MaxItems))
+	require.NoError(t, err)
+
+	// every goroutine appends to the same primary index while it's being flushed. The appends covered
+	// by the same group commit return from the log at the same time
+	goroutines, appends := 32, 20
+	var wg sync.WaitGroup
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < appends; j++ {
+				ts := int64(i*appends + j)
+				assert.NoError(t, wal.Append(db.NewKv("instance1", "cpu", []int64{ts}, []int32{int32(ts)})))
+			}
+		}(i)
+	}
+	wg.Wait()
+	require.NotZero(t, fbc.newFileblockCount)
+
+	// simulate a crash, whatever wasn't written to a fileblock must be replayed
+	require.NoError(t, wal.(*diskWal[int64, *db.Kv]).log.close())
+
+	wal, err = newDiskWal[int64, *db.Kv](cfg, &mockFileblockCreator[int64]{
+		newFileblock: func(es *db.EntriesMap[int64], builder *db.MetadataBuilder[int64]) error { return nil },
+	})
+	require.NoError(t, err)
+
+	replayed := make(map[int64]struct{})
+	if iter, found := wal.Find("instance1", "cpu", 0, int64(goroutines*appends)); found {
+		entry, _, err := iter.Next()
+		require.NoError(t, err)
+		for _, ts := range entry.(*db.Kv).Ts {
+			replayed[ts] = struct{}{}
+		}
+	}
+
+	for ts := int64(0); ts < int64(goroutines*appends); ts++ {
+		_, inFileblock := flushed[ts]
+		_, inWal := replayed[ts]
+		assert.True(t, inFileblock || inWal, "value %d was lost", ts)
+	}
+
+	require.NoError(t, wal.Close())
+}
+
+// walLsnFileblockCreator keeps the wal lsn of the fileblocks it creates, like MultiFsLevels
+type walLsnFileblockCreator struct {
+	mockFileblockCreator[int64]
+	lsns map[string]uint64
+}
+
+func (c *walLsnFileblockCreator) WalLsns() map[string]uint64 {
+	return maps.Clone(c.lsns)
+}
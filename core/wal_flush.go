@@ -2,8 +2,6 @@ package core
 
 import (
 	"cmp"
-	"time"
-	"unsafe"
 
 	db "github.com/sayden/streedb"
 )
@@ -27,27 +25,5 @@ type sizeLimitWalFlushStrategy[O cmp.Ordered] struct {
 }
 
 func (s *sizeLimitWalFlushStrategy[O]) ShouldFlush(es *db.EntriesMap[O]) bool {
-	// TODO: Optimistic way to get the size of a struct
-	size := int(unsafe.Sizeof(es))
-
-	return size*es.SecondaryIndicesLen() >= s.maxSize
-}
-
-// Flush Wal after a time duration
-//
-// Deprecated: There is a need to a wrapper in the Wal to use a time based strategy
-func newTimeLimitWalFlushStrategy(d time.Duration) db.WalFlushStrategy[int64] {
-	return &timeLimitWalFlushStrategy{duration: d}
-}
-
-type timeLimitWalFlushStrategy struct {
-	duration time.Duration
-}
-
-func (s *timeLimitWalFlushStrategy) ShouldFlush(es *db.EntriesMap[int64]) bool {
-	inMs := es.Min()
-	inTimeMs := time.UnixMilli(inMs)
-	since := time.Since(inTimeMs)
-
-	return since > s.duration
+	return es.SizeBytes() >= s.maxSize
 }
@@ -2,7 +2,8 @@ package core
 
 import (
 	"cmp"
-	"os"
+	"errors"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -21,9 +22,8 @@ func (m *mockFileblockCreator[O]) NewFileblock(es *db.EntriesMap[O], builder *db
 }
 
 func TestInMemoryWalFlushStrategy(t *testing.T) {
-	t.Cleanup(func() { os.RemoveAll("/tmp/db") })
-
 	cfg := db.NewDefaultConfig()
+	cfg.DbPath = t.TempDir()
 	cfg.Wal.MaxItems = 3
 	fbcreator := &mockFileblockCreator[int64]{}
 
@@ -88,34 +88,213 @@ func TestInMemoryWalFlushStrategy(t *testing.T) {
 		require.Equal(t, 1, fbcreator.newFileblockCount)
 	})
 
-	t.Run("TimeLimitWalFlushStrategy", func(t *testing.T) {
-		t.Skip("TODO")
+	t.Run("ElapsedTimeFlush", func(t *testing.T) {
+		cfg := db.NewDefaultConfig()
+		cfg.Wal.MaxElapsedTimeMs = 50
 
-		IWal := newNMMemoryWal(
-			cfg,
-			fbcreator,
-			newTimeLimitWalFlushStrategy(time.Millisecond))
+		flushed := make(chan string, 2)
+		fbcreator := &mockFileblockCreator[int64]{
+			newFileblock: func(es *db.EntriesMap[int64], builder *db.MetadataBuilder[int64]) error {
+				flushed <- builder.PrimaryIdx
+				return nil
+			},
+		}
+
+		wal := newNMMemoryWal(cfg, fbcreator, newItemLimitWalFlushStrategy[int64](cfg.Wal.MaxItems))
+		defer wal.Close()
+
+		// old timestamps don't matter, the time is measured since the data arrived to the wal
+		require.NoError(t, wal.Append(db.NewKv("wal_instance1", "wal_cpu", []int64{1, 2}, []int32{1, 2})))
+		time.Sleep(20 * time.Millisecond)
+		_, found := wal.Find("wal_instance1", "wal_cpu", 0, 10)
+		require.True(t, found)
+
+		select {
+		case pIdx := <-flushed:
+			require.Equal(t, "wal_instance1", pIdx)
+		case <-time.After(time.Second):
+			t.Fatal("wal was not flushed after max elapsed time")
+		}
+
+		_, found = wal.Find("wal_instance1", "wal_cpu", 0, 10)
+		require.False(t, found)
+	})
+
+	t.Run("MaxTotalBytes", func(t *testing.T) {
+		cfg := db.NewDefaultConfig()
+
+		flushed := make([]string, 0)
+		fbcreator := &mockFileblockCreator[int64]{
+			newFileblock: func(es *db.EntriesMap[int64], builder *db.MetadataBuilder[int64]) error {
+				flushed = append(flushed, builder.PrimaryIdx)
+				return nil
+			},
+		}
+
+		kv := func(pIdx string, n int) *db.Kv {
+			return db.NewKv(pIdx, "wal_cpu", make([]int64, n), make([]int32, n))
+		}
+		cfg.Wal.MaxTotalBytes = kv("wal_instance1", 100).SizeBytes() + kv("wal_instance2", 10).SizeBytes() + kv("wal_instance3", 50).SizeBytes()
+
+		wal := newNMMemoryWal(cfg, fbcreator, newItemLimitWalFlushStrategy[int64](cfg.Wal.MaxItems))
+		require.NoError(t, wal.Append(kv("wal_instance1", 100)))
+		require.NoError(t, wal.Append(kv("wal_instance2", 10)))
+		require.NoError(t, wal.Append(kv("wal_instance3", 50)))
+		require.Empty(t, flushed)
+
+		// going over the limit flushes the largest primary index only
+		require.NoError(t, wal.Append(kv("wal_instance2", 10)))
+		require.Equal(t, []string{"wal_instance1"}, flushed)
+		require.Equal(t, kv("wal_instance2", 20).SizeBytes()+kv("wal_instance3", 50).SizeBytes(), wal.(*memoryWal[int64]).sizeBytes)
+	})
+
+	t.Run("BackgroundFlushersAndWriteStall", func(t *testing.T) {
+		cfg := db.NewDefaultConfig()
+		cfg.Wal.MaxItems = 2
+		cfg.Wal.Flushers = 1
+		cfg.Wal.MaxPendingFlushes = 2
+		cfg.Wal.FailOnWriteStall = true
 
+		// fileblocks aren't written until release is closed
+		release := make(chan struct{})
+		var created atomic.Int32
+		fbcreator := &mockFileblockCreator[int64]{
+			newFileblock: func(es *db.EntriesMap[int64], builder *db.MetadataBuilder[int64]) error {
+				<-release
+				created.Add(1)
+				return nil
+			},
+		}
+
+		IWal := newNMMemoryWal(cfg, fbcreator, newItemLimitWalFlushStrategy[int64](cfg.Wal.MaxItems))
 		wal := IWal.(*memoryWal[int64])
 
-		cfg.Wal.MaxItems = 100
-		fbcreator.newFileblockCount = 0
-		wal.Append(db.NewKv("wal_instance1", "wal_cpu", ts2, []int32{1, 2}))
-		wal.Append(db.NewKv("wal_instance1", "wal_cpu", ts[2:], []int32{3, 4}))
-		require.Equal(t, 0, fbcreator.newFileblockCount)
+		// two flushes are frozen, appends don't wait for them
+		require.NoError(t, wal.Append(db.NewKv("wal_instance1", "wal_cpu", []int64{1, 2}, []int32{1, 2})))
+		require.NoError(t, wal.Append(db.NewKv("wal_instance2", "wal_cpu", []int64{1, 2}, []int32{1, 2})))
 
-		wal.flushStrategies = []db.WalFlushStrategy[int64]{
-			newTimeLimitWalFlushStrategy(time.Millisecond),
+		// frozen entries are still found
+		_, found := wal.Find("wal_instance1", "wal_cpu", 0, 10)
+		require.True(t, found)
+
+		err := wal.Append(db.NewKv("wal_instance3", "wal_cpu", []int64{1}, []int32{1}))
+		require.ErrorIs(t, err, db.ErrWriteStall)
+
+		// without FailOnWriteStall, appends block until a flush finishes
+		cfg.Wal.FailOnWriteStall = false
+		appended := make(chan error)
+		go func() {
+			appended <- wal.Append(db.NewKv("wal_instance3", "wal_cpu", []int64{1}, []int32{1}))
+		}()
+
+		select {
+		case <-appended:
+			t.Fatal("append didn't stall")
+		case <-time.After(50 * time.Millisecond):
 		}
-		fbcreator.newFileblock = func(es *db.EntriesMap[int64], builder *db.MetadataBuilder[int64]) error {
-			require.Equal(t, 1, es.SecondaryIndicesLen())
-			return nil
+
+		close(release)
+		require.NoError(t, <-appended)
+		require.Eventually(t, func() bool { return created.Load() == 2 }, time.Second, time.Millisecond)
+
+		_, found = wal.Find("wal_instance1", "wal_cpu", 0, 10)
+		require.False(t, found)
+
+		require.NoError(t, wal.Close())
+		require.Equal(t, int32(3), created.Load())
+	})
+
+	t.Run("DeleteFrozen", func(t *testing.T) {
+		cfg := db.NewDefaultConfig()
+		cfg.Wal.MaxItems = 3
+		cfg.Wal.Flushers = 1
+
+		// the first write fails, so the map stays frozen waiting to be retried
+		var failing atomic.Bool
+		failing.Store(true)
+		failed := make(chan struct{}, 1)
+		written := make(chan []int64, 1)
+		fbcreator := &mockFileblockCreator[int64]{
+			newFileblock: func(es *db.EntriesMap[int64], builder *db.MetadataBuilder[int64]) error {
+				if failing.Load() {
+					select {
+					case failed <- struct{}{}:
+					default:
+					}
+					return errors.New("disk full")
+				}
+				written <- es.Get("wal_cpu").(*db.Kv).Ts
+				return nil
+			},
 		}
 
-		wal.Append(db.NewKv("wal_cpu", "wal_instance1", ts3, []int32{5, 6, 7}))
-		time.Sleep(time.Millisecond * 500)
-		wal.Append(db.NewKv("wal_cpu", "wal_instance1", ts3, []int32{5, 6, 7}))
+		wal := newNMMemoryWal(cfg, fbcreator, newItemLimitWalFlushStrategy[int64](cfg.Wal.MaxItems))
+		require.NoError(t, wal.Append(db.NewKv("wal_instance1", "wal_cpu", []int64{1, 2, 3}, []int32{1, 2, 3})))
+		<-failed
 
-		require.Equal(t, 1, fbcreator.newFileblockCount)
+		// the delete lands on the frozen map before it's written
+		tombstone, err := db.NewTombstone[int64]("wal_instance1", "wal_cpu", 2, 2)
+		require.NoError(t, err)
+		require.NoError(t, wal.Delete(tombstone, nil))
+
+		iter, found := wal.Find("wal_instance1", "wal_cpu", 0, 10)
+		require.True(t, found)
+		entry, _, err := iter.Next()
+		require.NoError(t, err)
+		require.Equal(t, []int64{1, 3}, entry.(*db.Kv).Ts)
+
+		failing.Store(false)
+		require.NoError(t, wal.Close())
+		require.Equal(t, []int64{1, 3}, <-written)
+	})
+
+	t.Run("DeleteInFlight", func(t *testing.T) {
+		cfg := db.NewDefaultConfig()
+		cfg.Wal.MaxItems = 3
+		cfg.Wal.Flushers = 1
+
+		// the map is being written until release is closed
+		writing := make(chan struct{})
+		release := make(chan struct{})
+		written := make(chan []int64, 1)
+		fbcreator := &mockFileblockCreator[int64]{
+			newFileblock: func(es *db.EntriesMap[int64], builder *db.MetadataBuilder[int64]) error {
+				close(writing)
+				<-release
+				written <- es.Get("wal_cpu").(*db.Kv).Ts
+				return nil
+			},
+		}
+
+		wal := newNMMemoryWal(cfg, fbcreator, newItemLimitWalFlushStrategy[int64](cfg.Wal.MaxItems))
+		require.NoError(t, wal.Append(db.NewKv("wal_instance1", "wal_cpu", []int64{1, 2, 3}, []int32{1, 2, 3})))
+		<-writing
+
+		// the delete waits for the map being written, its values are masked in the fileblock
+		tombstone, err := db.NewTombstone[int64]("wal_instance1", "wal_cpu", 2, 2)
+		require.NoError(t, err)
+		var masked atomic.Bool
+		deleted := make(chan error)
+		go func() {
+			deleted <- wal.Delete(tombstone, func() error {
+				masked.Store(true)
+				return nil
+			})
+		}()
+
+		select {
+		case <-deleted:
+			t.Fatal("delete didn't wait for the map being written")
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		close(release)
+		require.NoError(t, <-deleted)
+		require.Equal(t, []int64{1, 2, 3}, <-written)
+		require.True(t, masked.Load())
+
+		_, found := wal.Find("wal_instance1", "wal_cpu", 0, 10)
+		require.False(t, found)
+		require.NoError(t, wal.Close())
 	})
 }
@@ -2,82 +2,658 @@ package core
 
 import (
 	"cmp"
+	"errors"
+	"slices"
+	"sync"
 	"time"
 
 	"github.com/puzpuzpuz/xsync/v3"
 	db "github.com/sayden/streedb"
+	"github.com/thehivecorporation/log"
 )
 
+// flushRetryInterval is the time a background flusher waits before retrying a frozen map that
+// failed to be written
+const flushRetryInterval = time.Second
+
 func newNMMemoryWal[O cmp.Ordered](cfg *db.Config, fbc db.FileblockCreator[O], persistStrategies ...db.WalFlushStrategy[O]) db.Wal[O] {
-	return &memoryWal[O]{
+	w := &memoryWal[O]{
 		entries:          xsync.NewMapOf[string, *db.EntriesMap[O]](),
+		arrivedAt:        make(map[string]time.Time),
+		lsn:              make(map[string]uint64),
 		cfg:              cfg,
 		fileblockCreator: fbc,
 		flushStrategies:  persistStrategies,
+		done:             make(chan struct{}),
+	}
+	w.workCond = sync.NewCond(&w.mu)
+	w.flushedCond = sync.NewCond(&w.mu)
+
+	if cfg.Wal.MaxElapsedTimeMs > 0 {
+		w.wg.Add(1)
+		go w.flushOnElapsedTime(time.Duration(cfg.Wal.MaxElapsedTimeMs) * time.Millisecond)
+	}
+
+	for i := 0; i < cfg.Wal.Flushers; i++ {
+		w.wg.Add(1)
+		go w.flusher()
 	}
+
+	return w
 }
 
 // memoryWal is a write-ahead log that stores entries in memory.
 // That means that the entries are not persisted to disk until the
 // a persist strategies is met or the WAL is closed (usually when
-// closing the database)
+// closing the database). A background routine also flushes every
+// primary index that has been in memory longer than WalCfg.MaxElapsedTimeMs,
+// so that primary indexes that don't receive new data are flushed too.
+//
+// When WalCfg.Flushers is set, flushing a primary index only freezes its
+// entries: they are moved to a queue that a pool of background flushers
+// writes to fileblocks, while a fresh map accepts new writes. Frozen maps are
+// still returned by Find until they are written. Appends stall once
+// WalCfg.MaxPendingFlushes frozen maps are waiting.
 type memoryWal[O cmp.Ordered] struct {
+	// mu serializes appends and flushes, so that no entry is appended to a map that is being flushed
+	mu sync.Mutex
+
 	entries          *xsync.MapOf[string, *db.EntriesMap[O]]
 	cfg              *db.Config
 	fileblockCreator db.FileblockCreator[O]
 	flushStrategies  []db.WalFlushStrategy[O]
+
+	// arrivedAt stores when the first entry of each primary index arrived to the wal
+	arrivedAt map[string]time.Time
+
+	// lsn stores the highest log sequence number appended to each primary index, see diskWal
+	lsn map[string]uint64
+
+	// onFlushed is called after the entries of a primary index up to lsn have been written to a
+	// fileblock
+	onFlushed func(pIdx string, lsn uint64) error
+
+	// sizeBytes is the approximate memory used by the entries of every primary index, not counting
+	// the frozen ones
+	sizeBytes int
+
+	// frozen is the queue of maps waiting to be written by the flushers, oldest first
+	frozen   []*frozenWalMap[O]
+	workCond *sync.Cond
+	// flushedCond is broadcast every time a flusher finishes writing a map, successfully or not
+	flushedCond *sync.Cond
+	closing     bool
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// frozenWalMap is an immutable set of entries of a primary index waiting to be written. Deletes made
+// after it was frozen are kept in tombstones and applied when it's written.
+type frozenWalMap[O cmp.Ordered] struct {
+	pIdx       string
+	entries    *db.EntriesMap[O]
+	tombstones []db.Tombstone[O]
+	lsn        uint64
+	inFlight   bool
+	retryAt    time.Time
 }
 
-func (w *memoryWal[O]) Append(d db.Entry[O]) (err error) {
-	fileEntries, _ := w.entries.LoadOrStore(d.PrimaryIndex(), db.NewEntriesMap[O]())
+func (w *memoryWal[O]) Append(d db.Entry[O]) error {
+	if err := w.admit(); err != nil {
+		return err
+	}
+
+	return w.append(d, nil)
+}
+
+// admit blocks while the wal is stalled, or returns ErrWriteStall if WalCfg.FailOnWriteStall is set.
+// It's checked before an entry is accepted, so that a rejected entry is never written to the log.
+func (w *memoryWal[O]) admit() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for w.isStalled() {
+		if w.cfg.Wal.FailOnWriteStall {
+			return db.ErrWriteStall
+		}
+		w.flushedCond.Wait()
+	}
+
+	return nil
+}
+
+// append inserts an entry in the wal. logEntry, if set, writes the entry to the log of a diskWal and
+// returns its lsn. It's called holding mu, so that the entries of a primary index are inserted in
+// the order of their lsns and a flushed map never covers an lsn that hasn't been inserted yet.
+func (w *memoryWal[O]) append(d db.Entry[O], logEntry func() (uint64, error)) (err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closing {
+		return ErrWalClosed
+	}
+
+	var lsn uint64
+	if logEntry != nil {
+		if lsn, err = logEntry(); err != nil {
+			return err
+		}
+	}
+
+	fileEntries, found := w.entries.LoadOrStore(d.PrimaryIndex(), db.NewEntriesMap[O]())
+	if !found {
+		w.arrivedAt[d.PrimaryIndex()] = time.Now()
+	}
+	w.lsn[d.PrimaryIndex()] = max(w.lsn[d.PrimaryIndex()], lsn)
+
+	before := fileEntries.SizeBytes()
 	fileEntries.Append(d)
+	w.sizeBytes += fileEntries.SizeBytes() - before
 
 	for _, strategy := range w.flushStrategies {
 		if strategy.ShouldFlush(fileEntries) {
-			builder := db.NewMetadataBuilder[O](w.cfg).
-				WithPrimaryIndex(d.PrimaryIndex()).
-				WithLevel(0).
-				WithCreatedAt(time.Now())
-
-			if err = w.fileblockCreator.NewFileblock(fileEntries, builder); err != nil {
+			if err = w.flush(d.PrimaryIndex(), fileEntries); err != nil {
 				return err
 			}
+			break
+		}
+	}
+
+	return w.flushLargest()
+}
+
+// isStalled must be called holding mu.
+func (w *memoryWal[O]) isStalled() bool {
+	maxPending := w.cfg.Wal.MaxPendingFlushes
+	return !w.closing && w.cfg.Wal.Flushers > 0 && maxPending > 0 && len(w.frozen) >= maxPending
+}
+
+// flushLargest flushes primary indexes, largest first, until the memory used by the wal is below
+// WalCfg.MaxTotalBytes. It must be called holding mu.
+func (w *memoryWal[O]) flushLargest() error {
+	maxTotal := w.cfg.Wal.MaxTotalBytes
+	if maxTotal <= 0 || w.sizeBytes <= maxTotal {
+		return nil
+	}
+
+	type candidate struct {
+		pIdx    string
+		entries *db.EntriesMap[O]
+	}
+	candidates := make([]candidate, 0)
+	w.entries.Range(func(pIdx string, fileEntries *db.EntriesMap[O]) bool {
+		candidates = append(candidates, candidate{pIdx: pIdx, entries: fileEntries})
+		return true
+	})
+	slices.SortFunc(candidates, func(a, b candidate) int {
+		return b.entries.SizeBytes() - a.entries.SizeBytes()
+	})
+
+	for _, c := range candidates {
+		if w.sizeBytes <= maxTotal {
+			break
+		}
+
+		if err := w.flush(c.pIdx, c.entries); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
 
-			w.entries.Delete(d.PrimaryIndex())
+// flush removes the entries of a primary index from the wal and writes them into a new fileblock.
+// Without background flushers the fileblock is written before returning, otherwise the entries are
+// frozen and queued for the flushers. It must be called holding mu.
+func (w *memoryWal[O]) flush(pIdx string, fileEntries *db.EntriesMap[O]) error {
+	frozen := &frozenWalMap[O]{pIdx: pIdx, entries: fileEntries, lsn: w.lsn[pIdx]}
 
-			return nil
+	if w.cfg.Wal.Flushers <= 0 {
+		if err := w.persist(frozen); err != nil {
+			return err
 		}
 	}
 
+	w.entries.Delete(pIdx)
+	delete(w.arrivedAt, pIdx)
+	delete(w.lsn, pIdx)
+	w.sizeBytes -= fileEntries.SizeBytes()
+
+	if w.cfg.Wal.Flushers > 0 {
+		// the flushers write the map without holding mu, while Find keeps reading it. Sorting and
+		// caching min and max here leaves nothing for NewFileblock to modify
+		fileEntries.Range(func(key string, entry db.Entry[O]) bool {
+			entry.Sort()
+			entry.Min()
+			entry.Max()
+			return true
+		})
+
+		w.frozen = append(w.frozen, frozen)
+		w.workCond.Signal()
+	}
+
 	return nil
 }
 
+func (w *memoryWal[O]) persist(frozen *frozenWalMap[O]) error {
+	entries := frozen.entries
+	if len(frozen.tombstones) > 0 {
+		entries = entries.Clone()
+		entries.ApplyTombstones(frozen.tombstones...)
+	}
+
+	// everything might have been deleted
+	if entries.SecondaryIndicesLen() > 0 {
+		builder := db.NewMetadataBuilder[O](w.cfg).
+			WithPrimaryIndex(frozen.pIdx).
+			WithLevel(0).
+			WithWalLsn(frozen.lsn).
+			WithCreatedAt(time.Now())
+
+		if err := w.fileblockCreator.NewFileblock(entries, builder); err != nil {
+			return err
+		}
+	}
+
+	if w.onFlushed != nil {
+		return w.onFlushed(frozen.pIdx, frozen.lsn)
+	}
+
+	return nil
+}
+
+// flusher writes frozen maps until the wal is closed. Maps of the same primary index are written in
+// the order they were frozen and never at the same time, so that onFlushed is called with
+// increasing lsns.
+func (w *memoryWal[O]) flusher() {
+	defer w.wg.Done()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for {
+		if w.closing {
+			return
+		}
+
+		frozen, retryIn := w.nextFrozen()
+		if frozen == nil {
+			if retryIn > 0 {
+				w.mu.Unlock()
+				select {
+				case <-w.done:
+				case <-time.After(retryIn):
+				}
+				w.mu.Lock()
+				continue
+			}
+
+			w.workCond.Wait()
+			continue
+		}
+
+		frozen.inFlight = true
+		w.mu.Unlock()
+		err := w.persist(frozen)
+		w.mu.Lock()
+		frozen.inFlight = false
+
+		if err != nil {
+			log.WithError(err).WithField("primary_index", frozen.pIdx).Error("error flushing frozen wal map, retrying")
+			frozen.retryAt = time.Now().Add(flushRetryInterval)
+		} else {
+			w.frozen = slices.DeleteFunc(w.frozen, func(f *frozenWalMap[O]) bool { return f == frozen })
+		}
+		w.flushedCond.Broadcast()
+
+		// the next map of the same primary index can be written now
+		w.workCond.Broadcast()
+	}
+}
+
+// nextFrozen returns the oldest frozen map that can be written now. If there is none but some are
+// waiting to be retried, it returns how long until the first retry. It must be called holding mu.
+func (w *memoryWal[O]) nextFrozen() (*frozenWalMap[O], time.Duration) {
+	var retryIn time.Duration
+	blocked := make(map[string]struct{})
+
+	for _, frozen := range w.frozen {
+		if _, ok := blocked[frozen.pIdx]; ok {
+			continue
+		}
+		blocked[frozen.pIdx] = struct{}{}
+
+		if frozen.inFlight {
+			continue
+		}
+
+		if wait := time.Until(frozen.retryAt); wait > 0 {
+			if retryIn == 0 || wait < retryIn {
+				retryIn = wait
+			}
+			continue
+		}
+
+		return frozen, 0
+	}
+
+	return nil, retryIn
+}
+
+// flushOnElapsedTime checks periodically for primary indexes that have been buffered for longer
+// than maxElapsed and flushes them.
+func (w *memoryWal[O]) flushOnElapsedTime(maxElapsed time.Duration) {
+	defer w.wg.Done()
+
+	interval := min(maxElapsed/2, time.Second)
+	if interval <= 0 {
+		interval = time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+		}
+
+		w.mu.Lock()
+		for pIdx, arrivedAt := range w.arrivedAt {
+			if time.Since(arrivedAt) < maxElapsed {
+				continue
+			}
+
+			fileEntries, found := w.entries.Load(pIdx)
+			if !found {
+				delete(w.arrivedAt, pIdx)
+				continue
+			}
+
+			if err := w.flush(pIdx, fileEntries); err != nil {
+				log.WithError(err).WithField("primary_index", pIdx).Error("error flushing wal after max elapsed time")
+			}
+		}
+		w.mu.Unlock()
+	}
+}
+
+// Find returns copies of the matching entries, so that the results aren't modified by later appends.
 func (w *memoryWal[O]) Find(pIdx, sIdx string, min, max O) (db.EntryIterator[O], bool) {
-	if pIdx == "" {
-		entries := make([]db.Entry[O], 0)
-		w.entries.Range(func(key string, fileEntries *db.EntriesMap[O]) bool {
-			fileEntries.Range(func(key string, entry db.Entry[O]) bool {
-				if sIdx == "" || entry.SecondaryIndex() == sIdx {
+	return w.find(pIdx, func(entry db.Entry[O]) bool {
+		return walEntryMatches(entry, pIdx, sIdx, min, max)
+	})
+}
+
+func (w *memoryWal[O]) FindMatching(min, max O, filters ...db.EntryFilter) (db.EntryIterator[O], bool) {
+	return w.find("", matchingWalEntries(min, max, filters...))
+}
+
+func (w *memoryWal[O]) FindSeries(pIdx, sIdx string) (db.EntryIterator[O], bool) {
+	return w.find(pIdx, seriesWalEntries[O](pIdx, sIdx))
+}
+
+func matchingWalEntries[O cmp.Ordered](min, max O, filters ...db.EntryFilter) func(db.Entry[O]) bool {
+	return func(entry db.Entry[O]) bool {
+		return db.MatchesFilters(entry, filters...) && entry.Min() <= max && min <= entry.Max()
+	}
+}
+
+func seriesWalEntries[O cmp.Ordered](pIdx, sIdx string) func(db.Entry[O]) bool {
+	return func(entry db.Entry[O]) bool {
+		return (pIdx == "" || entry.PrimaryIndex() == pIdx) && (sIdx == "" || entry.SecondaryIndex() == sIdx)
+	}
+}
+
+// find returns sorted copies of the entries of a primary index, or of every one if pIdx is empty,
+// that match
+func (w *memoryWal[O]) find(pIdx string, matches func(db.Entry[O]) bool) (db.EntryIterator[O], bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.findLocked(pIdx, matches)
+}
+
+// findLocked is find for callers holding mu
+func (w *memoryWal[O]) findLocked(pIdx string, matches func(db.Entry[O]) bool) (db.EntryIterator[O], bool) {
+	entries := make([]db.Entry[O], 0)
+	appendMatching := func(tombstones []db.Tombstone[O]) func(string, db.Entry[O]) bool {
+		return func(key string, entry db.Entry[O]) bool {
+			if matches(entry) {
+				entry = entry.Clone()
+				if db.ApplyTombstones(entry, tombstones...) {
 					entry.Sort()
 					entries = append(entries, entry)
 				}
-				return true
-			})
+			}
+			return true
+		}
+	}
+
+	if pIdx == "" {
+		w.entries.Range(func(key string, fileEntries *db.EntriesMap[O]) bool {
+			fileEntries.Range(appendMatching(nil))
 			return true
 		})
+	} else if fileEntries, found := w.entries.Load(pIdx); found {
+		fileEntries.Range(appendMatching(nil))
+	}
 
-		return db.NewListIterator(entries), len(entries) > 0
+	for _, frozen := range w.frozen {
+		if pIdx == "" || frozen.pIdx == pIdx {
+			frozen.entries.Range(appendMatching(frozen.tombstones))
+		}
 	}
 
-	fileEntries, found := w.entries.Load(pIdx)
-	if !found {
+	if len(entries) == 0 {
 		return nil, false
 	}
 
-	return fileEntries.Find(sIdx, min, max)
+	// the series of every map are merged with the fileblocks in order
+	db.SortFragments(entries)
+
+	return db.NewListIterator(entries), true
+}
+
+// View waits for the flushers to finish the maps they are writing and calls onView holding mu, so
+// that no entry can move from the wal to a fileblock meanwhile.
+func (w *memoryWal[O]) View(onView func(db.WalReader[O]) error) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.waitInFlight()
+
+	return onView(&lockedWalReader[O]{w: w})
+}
+
+// lockedWalReader reads a memoryWal whose mu is already held
+type lockedWalReader[O cmp.Ordered] struct {
+	w *memoryWal[O]
+}
+
+func (r *lockedWalReader[O]) Find(pIdx, sIdx string, min, max O) (db.EntryIterator[O], bool) {
+	return r.w.findLocked(pIdx, func(entry db.Entry[O]) bool {
+		return walEntryMatches(entry, pIdx, sIdx, min, max)
+	})
+}
+
+func (r *lockedWalReader[O]) FindMatching(min, max O, filters ...db.EntryFilter) (db.EntryIterator[O], bool) {
+	return r.w.findLocked("", matchingWalEntries(min, max, filters...))
+}
+
+func (r *lockedWalReader[O]) FindSeries(pIdx, sIdx string) (db.EntryIterator[O], bool) {
+	return r.w.findLocked(pIdx, seriesWalEntries[O](pIdx, sIdx))
+}
+
+// Snapshot waits for the flushers to finish the maps they are writing and returns a copy of every
+// entry in the wal. onSnapshot is called before the wal is unlocked, while no entry can move from
+// the wal to a fileblock.
+func (w *memoryWal[O]) Snapshot(onSnapshot func()) []db.Entry[O] {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.waitInFlight()
+
+	entries := make([]db.Entry[O], 0)
+	appendAll := func(tombstones []db.Tombstone[O]) func(string, db.Entry[O]) bool {
+		return func(key string, entry db.Entry[O]) bool {
+			entry = entry.Clone()
+			if db.ApplyTombstones(entry, tombstones...) {
+				entry.Sort()
+				entries = append(entries, entry)
+			}
+			return true
+		}
+	}
+
+	w.entries.Range(func(key string, fileEntries *db.EntriesMap[O]) bool {
+		fileEntries.Range(appendAll(nil))
+		return true
+	})
+	for _, frozen := range w.frozen {
+		frozen.entries.Range(appendAll(frozen.tombstones))
+	}
+
+	onSnapshot()
+
+	return entries
 }
 
+func (w *memoryWal[O]) Delete(t db.Tombstone[O], onDelete func() error) error {
+	return w.delete(t, nil, onDelete)
+}
+
+// delete removes the values masked by the tombstone from the wal. logTombstone, if set, writes the
+// tombstone to the log of a diskWal and returns its lsn, once the wal doesn't need it anymore it's
+// marked as flushed. Like in append, it's called holding mu.
+func (w *memoryWal[O]) delete(t db.Tombstone[O], logTombstone func() (uint64, error), onDelete func() error) (err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closing {
+		return ErrWalClosed
+	}
+
+	w.waitInFlight()
+
+	var lsn uint64
+	if logTombstone != nil {
+		if lsn, err = logTombstone(); err != nil {
+			return err
+		}
+	}
+
+	// fileblocks first, a tombstone that is marked as flushed is never replayed
+	if onDelete != nil {
+		if err := onDelete(); err != nil {
+			return err
+		}
+	}
+
+	pIdx := t.PrimaryIdx
+	if fileEntries, found := w.entries.Load(pIdx); found {
+		before := fileEntries.SizeBytes()
+		fileEntries.ApplyTombstones(t)
+		w.sizeBytes += fileEntries.SizeBytes() - before
+
+		if fileEntries.SecondaryIndicesLen() == 0 {
+			lsn = max(lsn, w.lsn[pIdx])
+			w.entries.Delete(pIdx)
+			delete(w.arrivedAt, pIdx)
+			delete(w.lsn, pIdx)
+		}
+	}
+
+	var lastFrozen *frozenWalMap[O]
+	for _, frozen := range w.frozen {
+		if frozen.pIdx == pIdx {
+			frozen.tombstones = append(frozen.tombstones, t)
+			lastFrozen = frozen
+		}
+	}
+
+	// the tombstone is done with once the entries that were in the wal before it are written
+	switch _, found := w.entries.Load(pIdx); {
+	case found:
+		w.lsn[pIdx] = max(w.lsn[pIdx], lsn)
+	case lastFrozen != nil:
+		lastFrozen.lsn = max(lastFrozen.lsn, lsn)
+	case lsn > 0 && w.onFlushed != nil:
+		return w.onFlushed(pIdx, lsn)
+	}
+
+	return nil
+}
+
+// waitInFlight waits until no frozen map is being written. A map being written might already be in
+// a fileblock, or not yet. It must be called holding mu.
+func (w *memoryWal[O]) waitInFlight() {
+	for slices.ContainsFunc(w.frozen, func(f *frozenWalMap[O]) bool { return f.inFlight }) {
+		w.flushedCond.Wait()
+	}
+}
+
+// walEntryMatches tells if an entry of the wal is part of the results of Find. When pIdx is empty
+// every entry of the secondary index matches, regardless of its values.
+func walEntryMatches[O cmp.Ordered](entry db.Entry[O], pIdx, sIdx string, min, max O) bool {
+	if sIdx != "" && entry.SecondaryIndex() != sIdx {
+		return false
+	}
+	if pIdx == "" {
+		return true
+	}
+	if entry.PrimaryIndex() != pIdx {
+		return false
+	}
+
+	// Overlap would copy the values of an unsorted entry, they are trimmed after cloning it
+	return entry.Min() <= max && min <= entry.Max()
+}
+
+// stop rejects new entries and stops the background routines without waiting for them. Appends
+// stalled waiting for the flushers return ErrWalClosed, even if the flushers keep failing.
+func (w *memoryWal[O]) stop() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.closing {
+		w.closing = true
+		close(w.done)
+	}
+	w.workCond.Broadcast()
+	w.flushedCond.Broadcast()
+}
+
+// Close stops the background routines and writes, synchronously, every frozen map and every entry
+// left in the wal.
 func (w *memoryWal[O]) Close() error {
+	w.stop()
+	w.wg.Wait()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	errs := make([]error, 0)
+	failed := make(map[string]struct{})
+	for _, frozen := range w.frozen {
+		// keep the order of the maps of a primary index, even when one of them can't be written
+		if _, ok := failed[frozen.pIdx]; ok {
+			continue
+		}
+
+		if err := w.persist(frozen); err != nil {
+			failed[frozen.pIdx] = struct{}{}
+			errs = append(errs, err)
+		}
+	}
+	w.frozen = slices.DeleteFunc(w.frozen, func(f *frozenWalMap[O]) bool {
+		_, ok := failed[f.pIdx]
+		return !ok
+	})
+
 	var err error
 	w.entries.Range(func(key string, fileEntries *db.EntriesMap[O]) bool {
 		// TODO: I don't think that this can actually happen
@@ -86,16 +662,21 @@ func (w *memoryWal[O]) Close() error {
 			panic("unreachable")
 		}
 
-		builder := db.NewMetadataBuilder[O](w.cfg).
-			WithLevel(0).
-			WithPrimaryIndex(pIdx).
-			WithCreatedAt(time.Now())
+		if _, ok := failed[key]; ok {
+			return true
+		}
 
-		if err = w.fileblockCreator.NewFileblock(fileEntries, builder); err != nil {
+		frozen := &frozenWalMap[O]{pIdx: key, entries: fileEntries, lsn: w.lsn[key]}
+		if err = w.persist(frozen); err != nil {
 			return false
 		}
+		w.entries.Delete(key)
+		delete(w.arrivedAt, key)
+		delete(w.lsn, key)
+		w.sizeBytes -= fileEntries.SizeBytes()
+
 		return true
 	})
 
-	return err
+	return errors.Join(append(errs, err)...)
 }
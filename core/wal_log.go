@@ -0,0 +1,577 @@
+package core
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	db "github.com/sayden/streedb"
+	"github.com/thehivecorporation/log"
+)
+
+var ErrWalClosed = errors.New("wal is closed")
+
+// ErrWalSyncFailed is returned by every write to the log once an fsync has failed. The kernel might
+// have dropped the pages it couldn't write, so a later fsync succeeding doesn't mean they are on disk.
+// The log can only be closed and opened again, which replays whatever its segments hold.
+var ErrWalSyncFailed = errors.New("wal failed to sync and must be reopened")
+
+const (
+	walSegmentPrefix    = "wal_"
+	walSegmentExtension = ".log"
+
+	// every record is framed as [length uint32][crc32 uint32][body]
+	walRecordHeaderSize = 8
+)
+
+type walRecordKind int
+
+const (
+	walRecordEntry walRecordKind = iota
+	walRecordFlush
+	walRecordTombstone
+)
+
+// walRecord is the unit written to the log. Entry records carry the serialized entry, tombstone
+// records the serialized tombstone in Entry, and flush records mark every record of PrimaryIdx up to
+// FlushedLsn as persisted in a fileblock.
+type walRecord struct {
+	Lsn        uint64
+	Kind       walRecordKind
+	PrimaryIdx string
+	FlushedLsn uint64          `json:",omitempty"`
+	Entry      json.RawMessage `json:",omitempty"`
+}
+
+type walSegment struct {
+	seq  uint64
+	path string
+
+	// highest lsn written to this segment for each primary index
+	maxLsn map[string]uint64
+}
+
+// walLog is an append-only log split in segments of roughly SegmentSizeBytes. Segments are removed
+// once every entry they contain has been flushed to a fileblock.
+//
+// Writes and fsyncs are decoupled: records are written under mu and fsynced afterwards according to
+// the sync mode. Only one fsync runs at a time (syncMu), so appends that arrive while an fsync is
+// running are covered by the next one instead of issuing one each.
+//
+// A failed fsync is fatal: from then on appends, syncs and flush records return ErrWalSyncFailed.
+type walLog struct {
+	mu sync.Mutex
+
+	dir            string
+	maxSegmentSize int64
+	syncMode       db.WalSyncMode
+	syncBytes      int64
+
+	// segments are ordered by sequence, the last one is the active one
+	segments   []*walSegment
+	active     *os.File
+	activeSize int64
+	unsynced   int64
+
+	lsn     uint64
+	flushed map[string]uint64
+	closed  bool
+
+	syncMu sync.Mutex
+
+	// syncedLsn is the highest lsn known to be on disk, guarded by syncCond.L. syncErr is set by the
+	// first failed fsync and never cleared
+	syncCond  *sync.Cond
+	syncedLsn uint64
+	syncErr   error
+
+	kick chan struct{}
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// openWalLog reads every segment in dir and returns the records that were never flushed, in
+// the order they were written. A new active segment is always created, so a torn write at the end
+// of a previous segment is never appended to.
+//
+// written holds the highest lsn already in fileblocks of every primary index. Records up to it are
+// flushed too, and new lsns follow it even if the segments holding it were removed.
+func openWalLog(dir string, cfg *db.WalCfg, written map[string]uint64) (*walLog, []*walRecord, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, nil, errors.Join(errors.New("error creating wal folder"), err)
+	}
+
+	l := &walLog{
+		dir:            dir,
+		maxSegmentSize: int64(cfg.SegmentSizeBytes),
+		syncMode:       cfg.SyncMode,
+		syncBytes:      int64(cfg.SyncBytes),
+		flushed:        make(map[string]uint64),
+		syncCond:       sync.NewCond(&sync.Mutex{}),
+	}
+
+	for pIdx, lsn := range written {
+		l.flushed[pIdx] = lsn
+		l.lsn = max(l.lsn, lsn)
+	}
+
+	seqs, err := l.listSegments()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	records := make([]*walRecord, 0)
+	for _, seq := range seqs {
+		segment := &walSegment{seq: seq, path: l.segmentPath(seq), maxLsn: make(map[string]uint64)}
+		segmentRecords, err := readWalSegment(segment.path)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for _, record := range segmentRecords {
+			if record.Lsn > l.lsn {
+				l.lsn = record.Lsn
+			}
+
+			switch record.Kind {
+			case walRecordEntry, walRecordTombstone:
+				segment.maxLsn[record.PrimaryIdx] = record.Lsn
+				records = append(records, record)
+			case walRecordFlush:
+				if record.FlushedLsn > l.flushed[record.PrimaryIdx] {
+					l.flushed[record.PrimaryIdx] = record.FlushedLsn
+				}
+			}
+		}
+
+		l.segments = append(l.segments, segment)
+	}
+
+	pending := make([]*walRecord, 0, len(records))
+	for _, record := range records {
+		if record.Lsn > l.flushed[record.PrimaryIdx] {
+			pending = append(pending, record)
+		}
+	}
+
+	var next uint64
+	if len(seqs) > 0 {
+		next = seqs[len(seqs)-1] + 1
+	}
+	if err = l.newSegment(next); err != nil {
+		return nil, nil, err
+	}
+	l.syncedLsn = l.lsn
+
+	if l.syncMode == db.WAL_SYNC_BATCH {
+		interval := time.Duration(cfg.SyncIntervalMs) * time.Millisecond
+		if interval <= 0 {
+			interval = time.Millisecond
+		}
+
+		l.kick = make(chan struct{}, 1)
+		l.done = make(chan struct{})
+		l.wg.Add(1)
+		go l.syncLoop(interval)
+	}
+
+	return l, pending, nil
+}
+
+func (l *walLog) listSegments() ([]uint64, error) {
+	files, err := os.ReadDir(l.dir)
+	if err != nil {
+		return nil, errors.Join(errors.New("error reading wal folder"), err)
+	}
+
+	seqs := make([]uint64, 0, len(files))
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || !strings.HasPrefix(name, walSegmentPrefix) || path.Ext(name) != walSegmentExtension {
+			continue
+		}
+
+		var seq uint64
+		if _, err = fmt.Sscanf(name, walSegmentPrefix+"%d"+walSegmentExtension, &seq); err != nil {
+			log.WithField("file", name).Warn("ignoring unknown file in wal folder")
+			continue
+		}
+		seqs = append(seqs, seq)
+	}
+
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+
+	return seqs, nil
+}
+
+func (l *walLog) segmentPath(seq uint64) string {
+	return path.Join(l.dir, fmt.Sprintf("%s%010d%s", walSegmentPrefix, seq, walSegmentExtension))
+}
+
+func (l *walLog) newSegment(seq uint64) error {
+	segment := &walSegment{seq: seq, path: l.segmentPath(seq), maxLsn: make(map[string]uint64)}
+
+	file, err := os.OpenFile(segment.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return errors.Join(errors.New("error creating wal segment"), err)
+	}
+
+	l.segments = append(l.segments, segment)
+	l.active = file
+	l.activeSize = 0
+
+	return nil
+}
+
+// append writes an entry or tombstone record to the active segment and returns the lsn assigned to
+// it. The record isn't as durable as the sync mode requires until sync returns.
+func (l *walLog) append(kind walRecordKind, pIdx string, body []byte) (uint64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// write might rotate the active segment, keep the one the record goes to
+	segment := l.segments[len(l.segments)-1]
+
+	record := &walRecord{Kind: kind, PrimaryIdx: pIdx, Entry: body}
+	if err := l.write(record); err != nil {
+		return 0, err
+	}
+
+	segment.maxLsn[pIdx] = record.Lsn
+
+	return record.Lsn, nil
+}
+
+// sync waits until the record with lsn is as durable as the sync mode requires.
+func (l *walLog) sync(lsn uint64) error {
+	switch l.syncMode {
+	case db.WAL_SYNC_ALWAYS:
+		return l.syncUpTo(lsn)
+	case db.WAL_SYNC_BATCH:
+		l.mu.Lock()
+		unsynced := l.unsynced
+		l.mu.Unlock()
+
+		if unsynced >= l.syncBytes {
+			select {
+			case l.kick <- struct{}{}:
+			default:
+			}
+		}
+		return l.waitSynced(lsn)
+	default:
+		return nil
+	}
+}
+
+// markFlushed records that every entry of pIdx up to lsn has been persisted and removes the
+// segments that don't contain unflushed entries anymore. Flush records are always synced, losing one
+// would replay entries that are already in a fileblock.
+func (l *walLog) markFlushed(pIdx string, lsn uint64) error {
+	l.mu.Lock()
+
+	if lsn <= l.flushed[pIdx] {
+		l.mu.Unlock()
+		return nil
+	}
+
+	record := &walRecord{Kind: walRecordFlush, PrimaryIdx: pIdx, FlushedLsn: lsn}
+	if err := l.write(record); err != nil {
+		l.mu.Unlock()
+		return err
+	}
+	l.mu.Unlock()
+
+	if err := l.syncUpTo(record.Lsn); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if lsn > l.flushed[pIdx] {
+		l.flushed[pIdx] = lsn
+	}
+
+	return l.truncate()
+}
+
+// syncUpTo fsyncs the active segment unless another fsync already covered lsn. Callers that wait on
+// syncMu while an fsync is running usually find their record synced when they get the lock.
+func (l *walLog) syncUpTo(lsn uint64) error {
+	l.syncMu.Lock()
+	defer l.syncMu.Unlock()
+
+	if l.synced() >= lsn {
+		return nil
+	}
+	if err := l.syncFailure(); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	file, target := l.active, l.lsn
+	l.unsynced = 0
+	l.mu.Unlock()
+
+	// a segment is synced before being closed on rotation, so a closed file is already on disk
+	if err := file.Sync(); err != nil && !errors.Is(err, os.ErrClosed) {
+		err = errors.Join(ErrWalSyncFailed, errors.New("error syncing wal segment"), err)
+		l.setSynced(0, err)
+		return err
+	}
+
+	l.setSynced(target, nil)
+
+	return nil
+}
+
+// syncLoop is the group commit of WAL_SYNC_BATCH. It fsyncs every interval or when an append kicks
+// it because too many bytes are pending, and wakes up the appends covered by the fsync.
+func (l *walLog) syncLoop(interval time.Duration) {
+	defer l.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-ticker.C:
+		case <-l.kick:
+		}
+
+		l.mu.Lock()
+		target := l.lsn
+		l.mu.Unlock()
+
+		// the waiting appends get the error, no later fsync can succeed
+		if err := l.syncUpTo(target); err != nil {
+			log.WithError(err).Error("error syncing wal")
+			return
+		}
+	}
+}
+
+func (l *walLog) waitSynced(lsn uint64) error {
+	l.syncCond.L.Lock()
+	defer l.syncCond.L.Unlock()
+
+	for l.syncedLsn < lsn && l.syncErr == nil {
+		l.syncCond.Wait()
+	}
+
+	if l.syncedLsn >= lsn {
+		return nil
+	}
+
+	return l.syncErr
+}
+
+func (l *walLog) syncFailure() error {
+	l.syncCond.L.Lock()
+	defer l.syncCond.L.Unlock()
+
+	return l.syncErr
+}
+
+func (l *walLog) synced() uint64 {
+	l.syncCond.L.Lock()
+	defer l.syncCond.L.Unlock()
+
+	return l.syncedLsn
+}
+
+func (l *walLog) setSynced(lsn uint64, err error) {
+	l.syncCond.L.Lock()
+	defer l.syncCond.L.Unlock()
+
+	if lsn > l.syncedLsn {
+		l.syncedLsn = lsn
+	}
+	if err != nil {
+		l.syncErr = err
+	}
+
+	l.syncCond.Broadcast()
+}
+
+func (l *walLog) write(record *walRecord) error {
+	if l.closed {
+		return ErrWalClosed
+	}
+	if err := l.syncFailure(); err != nil {
+		return err
+	}
+
+	record.Lsn = l.lsn + 1
+
+	body, err := json.Marshal(record)
+	if err != nil {
+		return errors.Join(errors.New("error encoding wal record"), err)
+	}
+
+	frame := make([]byte, walRecordHeaderSize+len(body))
+	binary.LittleEndian.PutUint32(frame[0:4], uint32(len(body)))
+	binary.LittleEndian.PutUint32(frame[4:8], crc32.ChecksumIEEE(body))
+	copy(frame[walRecordHeaderSize:], body)
+
+	if _, err = l.active.Write(frame); err != nil {
+		return errors.Join(errors.New("error writing wal record"), err)
+	}
+
+	l.lsn = record.Lsn
+	l.activeSize += int64(len(frame))
+	l.unsynced += int64(len(frame))
+
+	if l.activeSize >= l.maxSegmentSize {
+		if err = l.syncAndCloseActive(); err != nil {
+			return err
+		}
+
+		return l.newSegment(l.segments[len(l.segments)-1].seq + 1)
+	}
+
+	return nil
+}
+
+// syncAndCloseActive must be called holding mu.
+func (l *walLog) syncAndCloseActive() error {
+	if err := l.active.Sync(); err != nil {
+		err = errors.Join(ErrWalSyncFailed, errors.New("error syncing wal segment"), err)
+		l.setSynced(0, err)
+		return err
+	}
+	if err := l.active.Close(); err != nil {
+		return errors.Join(errors.New("error closing wal segment"), err)
+	}
+
+	l.unsynced = 0
+	l.setSynced(l.lsn, nil)
+
+	return nil
+}
+
+// truncate removes, oldest first, the segments whose entries have all been flushed. It stops at the
+// first segment that still holds unflushed data, so flush records are never removed before the
+// entries they refer to. The active segment is never removed.
+func (l *walLog) truncate() error {
+	for len(l.segments) > 1 && l.isFlushed(l.segments[0]) {
+		if err := os.Remove(l.segments[0].path); err != nil {
+			return errors.Join(errors.New("error removing wal segment"), err)
+		}
+		l.segments = l.segments[1:]
+	}
+
+	return nil
+}
+
+func (l *walLog) isFlushed(s *walSegment) bool {
+	for pIdx, lsn := range s.maxLsn {
+		if l.flushed[pIdx] < lsn {
+			return false
+		}
+	}
+
+	return true
+}
+
+// close closes the active segment. If every entry has been flushed, no segment is needed to
+// recover and all of them are removed.
+func (l *walLog) close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+
+	// the sync loop takes mu, it can't be waited for while holding it
+	if l.done != nil {
+		close(l.done)
+		l.mu.Unlock()
+		l.wg.Wait()
+		l.mu.Lock()
+	}
+
+	// every segment is kept, the next open replays what reached the disk
+	if err := l.syncFailure(); err != nil {
+		if cerr := l.active.Close(); cerr != nil && !errors.Is(cerr, os.ErrClosed) {
+			err = errors.Join(err, cerr)
+		}
+		return err
+	}
+
+	if err := l.syncAndCloseActive(); err != nil {
+		return err
+	}
+
+	for _, segment := range l.segments {
+		if !l.isFlushed(segment) {
+			return nil
+		}
+	}
+
+	for _, segment := range l.segments {
+		if err := os.Remove(segment.path); err != nil {
+			return errors.Join(errors.New("error removing wal segment"), err)
+		}
+	}
+	l.segments = nil
+
+	return nil
+}
+
+// readWalSegment reads every valid record of a segment. Reading stops at the first truncated or
+// corrupted record, which is what a crash in the middle of a write leaves behind.
+func readWalSegment(p string) ([]*walRecord, error) {
+	file, err := os.Open(p)
+	if err != nil {
+		return nil, errors.Join(errors.New("error opening wal segment"), err)
+	}
+	defer file.Close()
+
+	records := make([]*walRecord, 0)
+	reader := bufio.NewReader(file)
+	header := make([]byte, walRecordHeaderSize)
+
+	for {
+		if _, err = io.ReadFull(reader, header); err != nil {
+			if err != io.EOF {
+				log.WithField("segment", p).Warn("truncated record header found in wal segment")
+			}
+			return records, nil
+		}
+
+		body := make([]byte, binary.LittleEndian.Uint32(header[0:4]))
+		if _, err = io.ReadFull(reader, body); err != nil {
+			log.WithField("segment", p).Warn("truncated record found in wal segment")
+			return records, nil
+		}
+
+		if crc32.ChecksumIEEE(body) != binary.LittleEndian.Uint32(header[4:8]) {
+			log.WithField("segment", p).Warn("corrupted record found in wal segment")
+			return records, nil
+		}
+
+		record := &walRecord{}
+		if err = json.Unmarshal(body, record); err != nil {
+			log.WithField("segment", p).Warn("undecodable record found in wal segment")
+			return records, nil
+		}
+
+		records = append(records, record)
+	}
+}
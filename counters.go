@@ -0,0 +1,66 @@
+package streedb
+
+// The functions over counters look at the change between consecutive values, starting from the last
+// value of the previous step when there is one. A counter that goes down is taken as reset to 0, so
+// the value after the reset is all increase.
+
+// delta is the difference between the last and the first value, for gauges. It doesn't handle
+// resets.
+func delta(s samples) (float64, bool) {
+	if len(s.values) < 2 {
+		return 0, false
+	}
+
+	return s.values[len(s.values)-1] - s.values[0], true
+}
+
+// increase is how much a counter grew during the step
+func increase(s samples) (float64, bool) {
+	if len(s.values) < 2 {
+		return 0, false
+	}
+
+	res := 0.0
+	for i := 1; i < len(s.values); i++ {
+		res += counterIncrease(s.values[i-1], s.values[i])
+	}
+
+	return res, true
+}
+
+// rate is the per second increase of a counter, over the time between the first and the last value
+func rate(s samples) (float64, bool) {
+	inc, ok := increase(s)
+	if !ok {
+		return 0, false
+	}
+
+	return perSecond(inc, s.ts[len(s.ts)-1]-s.ts[0])
+}
+
+// irate is the per second increase of a counter between its last two values, it follows fast
+// changes that rate averages out
+func irate(s samples) (float64, bool) {
+	if len(s.values) < 2 {
+		return 0, false
+	}
+
+	last := len(s.values) - 1
+	return perSecond(counterIncrease(s.values[last-1], s.values[last]), s.ts[last]-s.ts[last-1])
+}
+
+func counterIncrease(prev, current float64) float64 {
+	if current < prev {
+		return current
+	}
+
+	return current - prev
+}
+
+func perSecond(v float64, elapsedMs int64) (float64, bool) {
+	if elapsedMs <= 0 {
+		return 0, false
+	}
+
+	return v * 1000 / float64(elapsedMs), true
+}
@@ -0,0 +1,50 @@
+package streedb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCounterFunctions(t *testing.T) {
+	aggs := make([]Aggregation, 0)
+	for _, name := range []string{"delta", "increase", "rate", "irate"} {
+		agg, err := ParseAggregation(name)
+		require.NoError(t, err)
+		aggs = append(aggs, agg)
+	}
+
+	// a counter sampled every 10 seconds in steps of a minute, reset at 70s
+	iter := NewListIterator([]Entry[int64]{
+		NewKv("instance1", "gc_cycles_total", []int64{0, 10_000, 20_000, 50_000, 60_000, 70_000, 80_000, 200_000}, []int32{0, 10, 30, 40, 50, 5, 15, 100}),
+	})
+
+	series, err := Downsample(iter, 60_000, aggs...)
+	require.NoError(t, err)
+	require.Len(t, series, 1)
+	require.Len(t, series[0].Buckets, 3)
+
+	first := series[0].Buckets[0]
+	assert.Equal(t, int64(0), first.Ts)
+	assert.Equal(t, map[string]float64{"delta": 40, "increase": 40, "rate": 0.8, "irate": 1 / 3.0}, first.Values)
+
+	// starts from the last value of the first step
+	second := series[0].Buckets[1]
+	assert.Equal(t, int64(60_000), second.Ts)
+	assert.Equal(t, 25.0, second.Values["increase"])
+	assert.Equal(t, -25.0, second.Values["delta"])
+	assert.InDelta(t, 25/30.0, second.Values["rate"], 1e-9)
+	assert.Equal(t, 1.0, second.Values["irate"])
+
+	// the previous step has no values, a single value has no change
+	third := series[0].Buckets[2]
+	assert.Equal(t, int64(180_000), third.Ts)
+	assert.Empty(t, third.Values)
+}
+
+func TestCounterIncrease(t *testing.T) {
+	assert.Equal(t, 5.0, counterIncrease(10, 15))
+	assert.Equal(t, 3.0, counterIncrease(10, 3))
+	assert.Equal(t, 0.0, counterIncrease(10, 10))
+}
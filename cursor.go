@@ -0,0 +1,170 @@
+package streedb
+
+import (
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+var (
+	ErrInvalidCursor = errors.New("invalid cursor")
+	ErrInvalidLimit  = errors.New("the limit must be greater than 0")
+)
+
+// Cursor is the position of the last value of a page: the series it belongs to and its timestamp.
+// It's handed to clients as an opaque token.
+type Cursor[O cmp.Ordered] struct {
+	PrimaryIdx   string `json:"p"`
+	SecondaryIdx string `json:"s"`
+	Ts           O      `json:"t"`
+}
+
+func (c *Cursor[O]) Encode() string {
+	if c == nil {
+		return ""
+	}
+
+	// it can't fail, the fields are strings and an ordered type
+	byt, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(byt)
+}
+
+// DecodeCursor returns nil for an empty token, so that the first page has no cursor
+func DecodeCursor[O cmp.Ordered](token string) (*Cursor[O], error) {
+	if token == "" {
+		return nil, nil
+	}
+
+	byt, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, errors.Join(ErrInvalidCursor, err)
+	}
+
+	c := &Cursor[O]{}
+	if err = json.Unmarshal(byt, c); err != nil {
+		return nil, errors.Join(ErrInvalidCursor, err)
+	}
+
+	return c, nil
+}
+
+// compare orders the series of an entry against the series of the cursor
+func (c *Cursor[O]) compare(e Indexer) int {
+	return cmp.Or(strings.Compare(e.PrimaryIndex(), c.PrimaryIdx), strings.Compare(e.SecondaryIndex(), c.SecondaryIdx))
+}
+
+// Filter drops the series that come before the one of the cursor, so that their fileblocks are never
+// loaded
+func (c *Cursor[O]) Filter() EntryFilter {
+	return &cursorFilter[O]{c}
+}
+
+type cursorFilter[O cmp.Ordered] struct{ c *Cursor[O] }
+
+func (f *cursorFilter[O]) Filter(i Indexer) bool {
+	return f.c.compare(i) >= 0
+}
+
+func (f *cursorFilter[O]) Kind() EntryFilterKind {
+	return SecondaryIndexFilterKind
+}
+
+// pager is an iterator that tells if it has values left without reading them
+type pager interface {
+	more() (bool, error)
+}
+
+// ReadPage reads up to limit values from the iterator, starting after the cursor if it's not nil.
+// The iterator must return the series ordered by primary and secondary index, with their values in
+// ascending order across entries, like LsmTree.Find does. The returned cursor points to the last
+// value of the page, it's nil when no values are left. The iterator is closed.
+//
+// With the iterators of NewSortedChunkIterator, a full page doesn't read any further. The cursor is
+// returned if there might be values left, the next page is empty when they have all been deleted.
+func ReadPage[O cmp.Ordered](iter EntryIterator[O], limit int, after *Cursor[O]) ([]Entry[O], *Cursor[O], error) {
+	defer iter.Close()
+
+	if limit <= 0 {
+		return nil, nil, ErrInvalidLimit
+	}
+
+	page := make([]Entry[O], 0)
+	read := 0
+	for {
+		entry, found, err := iter.Next()
+		if err != nil {
+			return nil, nil, err
+		}
+		if !found {
+			return page, nil, nil
+		}
+
+		entry = skipUntilCursor(entry, after)
+		if entry == nil {
+			continue
+		}
+
+		// the page is full, this entry tells that there are more values left
+		if read >= limit {
+			return page, cursorAt(page[len(page)-1]), nil
+		}
+
+		if remaining := limit - read; entry.Len() > remaining {
+			// a page never ends between values with the same timestamp, the cursor couldn't tell them apart
+			cut := remaining
+			for cut < entry.Len() && entry.Slice(cut, cut+1).Min() == entry.Slice(cut-1, cut).Min() {
+				cut++
+			}
+			if cut < entry.Len() {
+				entry = entry.Slice(0, cut)
+				return append(page, entry), cursorAt(entry), nil
+			}
+		}
+
+		page = append(page, entry)
+		read += entry.Len()
+
+		if p, ok := iter.(pager); ok && read >= limit {
+			more, err := p.more()
+			if err != nil {
+				return nil, nil, err
+			}
+			if !more {
+				return page, nil, nil
+			}
+			return page, cursorAt(entry), nil
+		}
+	}
+}
+
+func cursorAt[O cmp.Ordered](last Entry[O]) *Cursor[O] {
+	return &Cursor[O]{PrimaryIdx: last.PrimaryIndex(), SecondaryIdx: last.SecondaryIndex(), Ts: last.Last()}
+}
+
+// skipUntilCursor returns the values of the entry that come after the cursor, nil if there are none
+func skipUntilCursor[O cmp.Ordered](entry Entry[O], after *Cursor[O]) Entry[O] {
+	if after != nil {
+		switch after.compare(entry) {
+		case -1:
+			return nil
+		case 0:
+			if entry.Max() <= after.Ts {
+				return nil
+			}
+			entry, _ = entry.Overlap(after.Ts, entry.Max())
+
+			// values with the same timestamp as the cursor were returned along with it
+			for entry.Min() == after.Ts {
+				entry = entry.Slice(1, entry.Len())
+			}
+		}
+	}
+
+	if entry.Len() == 0 {
+		return nil
+	}
+
+	return entry
+}
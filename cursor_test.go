@@ -0,0 +1,151 @@
+package streedb
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCursor(t *testing.T) {
+	c := &Cursor[int64]{PrimaryIdx: "instance1", SecondaryIdx: "cpu", Ts: 42}
+
+	decoded, err := DecodeCursor[int64](c.Encode())
+	require.NoError(t, err)
+	assert.Equal(t, c, decoded)
+
+	decoded, err = DecodeCursor[int64]("")
+	require.NoError(t, err)
+	assert.Nil(t, decoded)
+	assert.Equal(t, "", decoded.Encode())
+
+	_, err = DecodeCursor[int64]("not a cursor")
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+}
+
+func TestReadPage(t *testing.T) {
+	series := func() EntryIterator[int64] {
+		return NewListIterator([]Entry[int64]{
+			NewKv("instance1", "cpu", []int64{1, 2, 2, 3}, []int32{1, 2, 2, 3}),
+			NewKv("instance1", "mem", []int64{1, 2}, []int32{1, 2}),
+			NewKv("instance2", "cpu", []int64{5}, []int32{5}),
+		})
+	}
+
+	type point struct {
+		pIdx string
+		sIdx string
+		ts   int64
+	}
+	read := make([]point, 0)
+	pages := 0
+
+	var after *Cursor[int64]
+	for {
+		page, next, err := ReadPage(series(), 2, after)
+		require.NoError(t, err)
+		pages++
+
+		for _, entry := range page {
+			for _, ts := range entry.(*Kv).Ts {
+				read = append(read, point{pIdx: entry.PrimaryIndex(), sIdx: entry.SecondaryIndex(), ts: ts})
+			}
+		}
+
+		if next == nil {
+			break
+		}
+		after = next
+	}
+
+	// the first page holds both values at 2 of cpu, they can't be told apart by a cursor
+	assert.Equal(t, 3, pages)
+	assert.Equal(t, []point{
+		{"instance1", "cpu", 1}, {"instance1", "cpu", 2}, {"instance1", "cpu", 2}, {"instance1", "cpu", 3},
+		{"instance1", "mem", 1}, {"instance1", "mem", 2},
+		{"instance2", "cpu", 5},
+	}, read)
+
+	_, _, err := ReadPage(series(), 0, nil)
+	assert.ErrorIs(t, err, ErrInvalidLimit)
+}
+
+// createMockSeriesFileblock is createMockFileblock with a value at every timestamp between min and max
+func createMockSeriesFileblock(p, s string, min, max int64) *Fileblock[int64] {
+	ts := make([]int64, 0)
+	vals := make([]int32, 0)
+	for i := min; i <= max; i++ {
+		ts = append(ts, i)
+		vals = append(vals, int32(i))
+	}
+
+	fb := createMockFileblock(p, s, min, max)
+	fb.filesystem.(*mockFilesystem[int64]).emap.Store(s, NewKv(p, s, ts, vals))
+
+	return fb
+}
+
+func TestReadPageLoads(t *testing.T) {
+	blocks := []*Fileblock[int64]{
+		createMockSeriesFileblock("instance1", "cpu", 1, 3),
+		createMockSeriesFileblock("instance1", "cpu", 4, 6),
+		createMockSeriesFileblock("instance1", "mem", 2, 4),
+		createMockSeriesFileblock("instance1", "swap", 1, 2),
+	}
+	index := NewBtreeIndex[int64, int64](3, LLFComp)
+	for _, fb := range blocks {
+		index.Upsert(*fb.Min, fb)
+	}
+
+	// loaded returns the number of times every fileblock has been loaded since the last call
+	loads := make([]int, len(blocks))
+	loaded := func() []int {
+		res := make([]int, 0, len(blocks))
+		for i, fb := range blocks {
+			load := fb.filesystem.(*mockFilesystem[int64]).extra.load
+			res = append(res, load-loads[i])
+			loads[i] = load
+		}
+		return res
+	}
+
+	readPage := func(after *Cursor[int64]) ([]int64, *Cursor[int64]) {
+		filters := []EntryFilter{PrimaryIndexFilter("instance1")}
+		if after != nil {
+			filters = append(filters, after.Filter())
+		}
+
+		iter, found, err := index.AscendRangeWithFilters(context.Background(), 0, 20, filters...)
+		require.NoError(t, err)
+		require.True(t, found)
+
+		page, next, err := ReadPage(NewSortedChunkIterator(NewRangeIterator(iter, 0, 20)), 3, after)
+		require.NoError(t, err)
+
+		ts := make([]int64, 0)
+		for _, entry := range page {
+			ts = append(ts, entry.(*Kv).Ts...)
+		}
+		return ts, next
+	}
+
+	// the page ends with the first fileblock, the next one isn't loaded to know that there's more
+	ts, next := readPage(nil)
+	assert.Equal(t, []int64{1, 2, 3}, ts)
+	assert.Equal(t, []int{1, 0, 0, 0}, loaded())
+
+	ts, next = readPage(next)
+	assert.Equal(t, []int64{4, 5, 6}, ts)
+	assert.Equal(t, []int{1, 1, 0, 0}, loaded())
+
+	ts, next = readPage(next)
+	assert.Equal(t, []int64{2, 3, 4}, ts)
+	assert.Equal(t, []int{1, 1, 1, 0}, loaded())
+
+	// the fileblocks of cpu come before the cursor, they are never loaded
+	ts, next = readPage(next)
+	assert.Equal(t, []int64{1, 2}, ts)
+	assert.Nil(t, next)
+	assert.Equal(t, []int{0, 0, 1, 1}, loaded())
+}
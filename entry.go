@@ -2,6 +2,7 @@ package streedb
 
 import (
 	"cmp"
+	"sync/atomic"
 
 	"github.com/puzpuzpuz/xsync/v3"
 )
@@ -23,8 +24,16 @@ type Entry[O cmp.Ordered] interface {
 	Comparable[O]
 
 	Append(Entry[O]) error
+	// Clone returns a deep copy that doesn't share memory with the entry
+	Clone() Entry[O]
+	// DeleteRange removes the values between min and max, both included
+	DeleteRange(min, max O)
 	Merge(Entry[O]) error
+	// Reverse reverses the order of the values
+	Reverse()
 	SetPrimaryIndex(string)
+	// Slice returns the values from position from to position to, excluded, sharing their memory
+	Slice(from, to int) Entry[O]
 	Sort()
 
 	Last() O
@@ -32,7 +41,26 @@ type Entry[O cmp.Ordered] interface {
 	Max() O
 	Min() O
 
-	Overlap(O, O) (Entry[O], bool)
+	// Overlap returns the values between min and max, both included, and false if there are none.
+	// The result can share memory with the entry.
+	Overlap(min, max O) (Entry[O], bool)
+}
+
+// Sizer is implemented by entries that can report the approximate amount of memory they use
+type Sizer interface {
+	SizeBytes() int
+}
+
+// defaultItemSizeBytes is the size assumed for every item of an entry that doesn't implement Sizer
+const defaultItemSizeBytes = 16
+
+// EntrySize returns the approximate amount of memory used by an entry
+func EntrySize[O cmp.Ordered](e Entry[O]) int {
+	if s, ok := e.(Sizer); ok {
+		return s.SizeBytes()
+	}
+
+	return e.Len() * defaultItemSizeBytes
 }
 
 func NewEntriesMap[O cmp.Ordered]() *EntriesMap[O] {
@@ -43,6 +71,9 @@ func NewEntriesMap[O cmp.Ordered]() *EntriesMap[O] {
 
 type EntriesMap[O cmp.Ordered] struct {
 	*xsync.MapOf[string, Entry[O]]
+
+	// sizeBytes is updated on every Append, so it's only accurate for maps filled using Append
+	sizeBytes int64
 }
 
 func (em *EntriesMap[O]) SecondaryIndices() []string {
@@ -60,17 +91,55 @@ func (em *EntriesMap[O]) Append(entry Entry[O]) {
 
 	oldEntry, found := em.LoadOrStore(secondaryIdx, entry)
 	if !found {
+		atomic.AddInt64(&em.sizeBytes, int64(EntrySize(entry)))
 		return
 	}
 
+	before := EntrySize(oldEntry)
 	err := oldEntry.Append(entry)
 	if err != nil {
 		panic(err)
 	}
 
+	atomic.AddInt64(&em.sizeBytes, int64(EntrySize(oldEntry)-before))
+
 	// em.Store(secondaryIdx, oldEntry)
 }
 
+// ApplyTombstones deletes the values masked by the tombstones from the entries of the map. Entries
+// left empty are removed from it.
+func (em *EntriesMap[O]) ApplyTombstones(ts ...Tombstone[O]) {
+	em.Range(func(key string, entry Entry[O]) bool {
+		before := EntrySize(entry)
+		if !ApplyTombstones(entry, ts...) {
+			em.Delete(key)
+			atomic.AddInt64(&em.sizeBytes, -int64(before))
+			return true
+		}
+
+		atomic.AddInt64(&em.sizeBytes, int64(EntrySize(entry)-before))
+		return true
+	})
+}
+
+// Clone returns a copy of the map with every entry cloned, so that it can be modified without
+// affecting the original
+func (em *EntriesMap[O]) Clone() *EntriesMap[O] {
+	dest := NewEntriesMap[O]()
+	em.Range(func(key string, entry Entry[O]) bool {
+		dest.Store(key, entry.Clone())
+		return true
+	})
+	dest.sizeBytes = atomic.LoadInt64(&em.sizeBytes)
+
+	return dest
+}
+
+// SizeBytes returns the approximate amount of memory used by the entries appended to the map
+func (em *EntriesMap[O]) SizeBytes() int {
+	return int(atomic.LoadInt64(&em.sizeBytes))
+}
+
 // FIXME: This is doing nothing at the moment
 func (em *EntriesMap[O]) Merge(d *EntriesMap[O]) (*EntriesMap[O], error) {
 	dest := NewEntriesMap[O]()
@@ -165,8 +234,8 @@ func (em *EntriesMap[O]) Find(sIdx string, min, max O) (EntryIterator[O], bool)
 	if sIdx == "" {
 		entries := make([]Entry[O], 0)
 		em.Range(func(key string, entry Entry[O]) bool {
-			if _, isOverlapped := entry.Overlap(min, max); isOverlapped {
-				entries = append(entries, entry)
+			if res, isOverlapped := entry.Overlap(min, max); isOverlapped {
+				entries = append(entries, res)
 			}
 			return true
 		})
@@ -2,71 +2,194 @@ package streedb
 
 import (
 	"cmp"
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
 )
 
+// EntryIterator must be closed once the caller is done with it, even if it wasn't fully read, so
+// that the resources it holds are released. Next returns the error that stopped the iteration,
+// including the one of a cancelled context.
 type EntryIterator[O cmp.Ordered] interface {
 	Next() (Entry[O], bool, error)
+	Close() error
 }
 
 type IteratorFilter[O cmp.Ordered] func(EntriesMap[O]) bool
 
-func newIteratorWithFilters[O cmp.Ordered](data []*Fileblock[O], filters []EntryFilter) *btreeWrapperIterator[O] {
-	sFilters := make([]EntryFilter, 0)
+// newIteratorWithFilters returns an iterator over the entries with values between min and max, both
+// included, of the fileblocks, which must be pinned. See fileblockIterator
+func newIteratorWithFilters[O cmp.Ordered](ctx context.Context, data []*Fileblock[O], min, max O, descending bool, filters []EntryFilter) *fileblockIterator[O] {
+	it := &fileblockIterator[O]{
+		ctx:        ctx,
+		min:        min,
+		max:        max,
+		descending: descending,
+		pending:    fragmentHeap[O]{descending: descending},
+		loaded:     fragmentHeap[O]{descending: descending},
+	}
+
 	for _, filter := range filters {
 		if filter.Kind() == SecondaryIndexFilterKind {
-			sFilters = append(sFilters, filter)
+			it.filters = append(it.filters, filter)
 		}
 	}
 
-	tree := &btreeWrapperIterator[O]{
-		ch: make(chan Entry[O]),
+	for _, fb := range data {
+		it.pending.items = append(it.pending.items, fragment[O]{key: it.fileblockKey(fb), fb: fb})
 	}
+	heap.Init(&it.pending)
+
+	return it
+}
+
+// fileblockIterator returns the entries of the fileblocks ordered by primary index, secondary index
+// and min value, or max value when descending. A fileblock is only loaded once the next entry might
+// be in it, which is known from the rows of its metadata, and released straight away. The fileblocks
+// left are released when a load fails, the context is cancelled or the iterator is closed.
+type fileblockIterator[O cmp.Ordered] struct {
+	ctx        context.Context
+	min, max   O
+	descending bool
+	filters    []EntryFilter
 
-	tree.startFilters(data, sFilters)
+	// pending holds the fileblocks not loaded yet, loaded the entries of the ones that were
+	pending fragmentHeap[O]
+	loaded  fragmentHeap[O]
 
-	return tree
+	closed bool
+	err    error
 }
 
-type btreeWrapperIterator[O cmp.Ordered] struct {
-	ch    chan Entry[O]
-	btree *BtreeIndex[O, O]
+// fileblockKey returns the key of the first entry that the fileblock can hold in the range. Without
+// rows, every series of its primary index is assumed to start at its min value.
+func (f *fileblockIterator[O]) fileblockKey(fb *Fileblock[O]) fragmentKey[O] {
+	key := fragmentKey[O]{pIdx: fb.PrimaryIdx, bound: *fb.Min}
+	if f.descending {
+		key.bound = *fb.Max
+	}
+
+	first := true
+	for i := range fb.Rows {
+		row := &fb.Rows[i]
+		if row.Min > f.max || row.Max < f.min || !MatchesFilters(&rowIndexer[O]{pIdx: fb.PrimaryIdx, row: row}, f.filters...) {
+			continue
+		}
+
+		rowKey := fragmentKey[O]{pIdx: fb.PrimaryIdx, sIdx: row.SecondaryIdx, bound: row.Min}
+		if f.descending {
+			rowKey.bound = row.Max
+		}
+		if first || f.pending.less(rowKey, key) {
+			key = rowKey
+			first = false
+		}
+	}
+
+	return key
 }
 
-func (b *btreeWrapperIterator[O]) startFilters(data []*Fileblock[O], filters []EntryFilter) {
-	go func() {
-		defer close(b.ch)
+func (f *fileblockIterator[O]) Next() (Entry[O], bool, error) {
+	if f.closed {
+		return nil, false, nil
+	}
+	if f.err != nil {
+		return nil, false, f.err
+	}
 
-		for _, e := range data {
-			entriesMap, err := e.Load()
-			if err != nil {
-				return
-			}
+	if err := f.ctx.Err(); err != nil {
+		return nil, false, f.fail(err)
+	}
 
-			entriesMap.Range(func(key string, entry Entry[O]) bool {
-				valid := true
-				for _, filter := range filters {
-					if !filter.Filter(entry) {
-						valid = false
-						break
-					}
-				}
-				if valid {
-					b.ch <- entry
-				}
+	// a fileblock that can hold an entry before the next loaded one must be loaded first
+	for f.pending.Len() > 0 && (f.loaded.Len() == 0 || f.pending.less(f.pending.items[0].key, f.loaded.items[0].key)) {
+		if err := f.load(heap.Pop(&f.pending).(fragment[O]).fb); err != nil {
+			return nil, false, f.fail(err)
+		}
+	}
+
+	if f.loaded.Len() == 0 {
+		return nil, false, nil
+	}
 
-				return true
-			})
+	return heap.Pop(&f.loaded).(fragment[O]).entry, true, nil
+}
+
+// load reads the entries of the fileblock that pass the filters into loaded and releases it. The
+// release deletes the files of a fileblock removed meanwhile, its error is returned too.
+func (f *fileblockIterator[O]) load(fb *Fileblock[O]) error {
+	entriesMap, err := fb.Load()
+	if err != nil {
+		return errors.Join(fmt.Errorf("failed to load block '%s'", fb.Metadata().DataFilepath), err, fb.Release())
+	}
+	if err = fb.Release(); err != nil {
+		return err
+	}
+
+	entriesMap.Range(func(key string, entry Entry[O]) bool {
+		if entry.Len() == 0 || entry.Min() > f.max || entry.Max() < f.min || !MatchesFilters(entry, f.filters...) {
+			return true
 		}
-	}()
+
+		entry.Sort()
+		heap.Push(&f.loaded, fragment[O]{key: newFragmentKey(entry, f.descending), entry: entry})
+		return true
+	})
+
+	return nil
 }
 
-func (b *btreeWrapperIterator[O]) Next() (Entry[O], bool, error) {
-	entry := <-b.ch
-	if entry == nil {
-		return nil, false, nil
+// fail releases the fileblocks that weren't loaded, Next returns err from now on
+func (f *fileblockIterator[O]) fail(err error) error {
+	f.err = errors.Join(err, f.release())
+
+	return f.err
+}
+
+func (f *fileblockIterator[O]) release() (err error) {
+	for _, pending := range f.pending.items {
+		err = errors.Join(err, pending.fb.Release())
 	}
+	f.pending.items = nil
+	f.loaded.items = nil
 
-	return entry, true, nil
+	return err
+}
+
+// nextKey returns a key that is not after the one of the next entry, without loading any fileblock.
+// It's false when there are no entries left.
+func (f *fileblockIterator[O]) nextKey() (fragmentKey[O], bool) {
+	if f.closed {
+		return fragmentKey[O]{}, false
+	}
+	// Next must be called to get the error
+	if f.err != nil {
+		return fragmentKey[O]{}, true
+	}
+
+	switch {
+	case f.loaded.Len() == 0 && f.pending.Len() == 0:
+		return fragmentKey[O]{}, false
+	case f.loaded.Len() == 0:
+		return f.pending.items[0].key, true
+	case f.pending.Len() == 0 || f.loaded.less(f.loaded.items[0].key, f.pending.items[0].key):
+		return f.loaded.items[0].key, true
+	}
+
+	return f.pending.items[0].key, true
+}
+
+// Close releases the fileblocks that weren't loaded
+func (f *fileblockIterator[O]) Close() error {
+	if f.closed {
+		return nil
+	}
+	f.closed = true
+
+	return f.release()
 }
 
 func NewSingleItemIterator[O cmp.Ordered](data Entry[O]) EntryIterator[O] {
@@ -88,6 +211,11 @@ func (l *singleItemIterator[O]) Next() (Entry[O], bool, error) {
 	return data, true, nil
 }
 
+func (l *singleItemIterator[O]) Close() error {
+	l.data = nil
+	return nil
+}
+
 func NewListIterator[O cmp.Ordered](data []Entry[O]) *listIterator[O] {
 	return &listIterator[O]{data: data}
 }
@@ -108,6 +236,11 @@ func (l *listIterator[O]) Next() (Entry[O], bool, error) {
 	return data, true, nil
 }
 
+func (l *listIterator[O]) Close() error {
+	l.index = len(l.data)
+	return nil
+}
+
 func NewIteratorMerger[O cmp.Ordered](iterators ...EntryIterator[O]) *iteratorMerger[O] {
 	return &iteratorMerger[O]{iterators: iterators}
 }
@@ -130,3 +263,338 @@ func (m *iteratorMerger[O]) Next() (Entry[O], bool, error) {
 
 	return nil, false, nil
 }
+
+func (m *iteratorMerger[O]) Close() error {
+	errs := make([]error, 0)
+	for _, it := range m.iterators {
+		if err := it.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// NewRangeIterator trims the entries of the iterator to the values between min and max, both
+// included. Entries left empty are skipped.
+func NewRangeIterator[O cmp.Ordered](it EntryIterator[O], min, max O) *rangeIterator[O] {
+	return &rangeIterator[O]{it: it, min: min, max: max}
+}
+
+type rangeIterator[O cmp.Ordered] struct {
+	it       EntryIterator[O]
+	min, max O
+}
+
+func (r *rangeIterator[O]) Next() (Entry[O], bool, error) {
+	for {
+		entry, found, err := r.it.Next()
+		if err != nil || !found {
+			return nil, false, err
+		}
+
+		if res, isOverlapped := entry.Overlap(r.min, r.max); isOverlapped {
+			return res, true, nil
+		}
+	}
+}
+
+func (r *rangeIterator[O]) Close() error {
+	return r.it.Close()
+}
+
+// NewReverseIterator reverses the order of the values of every entry of the iterator
+func NewReverseIterator[O cmp.Ordered](it EntryIterator[O]) *reverseIterator[O] {
+	return &reverseIterator[O]{it: it}
+}
+
+type reverseIterator[O cmp.Ordered] struct {
+	it EntryIterator[O]
+}
+
+func (r *reverseIterator[O]) Next() (Entry[O], bool, error) {
+	entry, found, err := r.it.Next()
+	if err != nil || !found {
+		return nil, false, err
+	}
+
+	entry.Reverse()
+	return entry, true, nil
+}
+
+func (r *reverseIterator[O]) Close() error {
+	return r.it.Close()
+}
+
+// NewSortedMergeIterator returns every series found by the iterators once, its fragments merged into
+// a single entry with its values in ascending order. Series are returned ordered by primary and
+// secondary index, and every iterator must return its entries in that order too. Only the next entry
+// of every iterator is held, an iterator is read again once its entry has been returned.
+// Values aren't deduplicated: a timestamp found by several iterators, like the wal and a level that
+// were both written with it, is returned once for each of them.
+func NewSortedMergeIterator[O cmp.Ordered](iterators ...EntryIterator[O]) *sortedMergeIterator[O] {
+	return newSortedMergeIterator(false, iterators)
+}
+
+// NewSortedChunkIterator is NewSortedMergeIterator returning a series in several entries, as soon as
+// the values of an entry can't overlap with the ones still to be read. Their values are ascending
+// across entries. The iterators must return the entries of a series in ascending order of their min
+// value. Fileblocks are only loaded when the next values might be in them, see ReadPage.
+func NewSortedChunkIterator[O cmp.Ordered](iterators ...EntryIterator[O]) *sortedMergeIterator[O] {
+	return newSortedMergeIterator(true, iterators)
+}
+
+func newSortedMergeIterator[O cmp.Ordered](chunks bool, iterators []EntryIterator[O]) *sortedMergeIterator[O] {
+	m := &sortedMergeIterator[O]{
+		iterators: iterators,
+		nextKeys:  make([]func() (fragmentKey[O], bool), len(iterators)),
+		chunks:    chunks,
+	}
+	for i, it := range iterators {
+		m.nextKeys[i] = nextKeyOf(it)
+	}
+
+	return m
+}
+
+// sortedMergeIterator holds a fragment per iterator: its next entry, or the key of its next entry for
+// the iterators that know it without reading it, so that no fileblock is loaded ahead of time.
+type sortedMergeIterator[O cmp.Ordered] struct {
+	iterators []EntryIterator[O]
+	nextKeys  []func() (fragmentKey[O], bool)
+	heads     fragmentHeap[O]
+	started   bool
+	chunks    bool
+}
+
+// nextKeyOf returns the nextKey function of an iterator that has one, nil otherwise
+func nextKeyOf[O cmp.Ordered](it EntryIterator[O]) func() (fragmentKey[O], bool) {
+	switch it := it.(type) {
+	case *fileblockIterator[O]:
+		if !it.descending {
+			return it.nextKey
+		}
+	case *rangeIterator[O]:
+		// trimming the values of an entry never moves it before its key
+		return nextKeyOf(it.it)
+	}
+
+	return nil
+}
+
+func (m *sortedMergeIterator[O]) Next() (Entry[O], bool, error) {
+	if err := m.start(); err != nil {
+		return nil, false, m.fail(err)
+	}
+
+	first, found, err := m.pop()
+	if err != nil || !found {
+		return nil, false, err
+	}
+
+	// fragments only need sorting if they overlap or don't come in ascending order
+	series := first
+	merged := false
+	sorted := true
+	for m.heads.Len() > 0 {
+		top := m.heads.items[0]
+		if top.key.pIdx != series.PrimaryIndex() || top.key.sIdx != series.SecondaryIndex() {
+			break
+		}
+		if m.chunks && top.key.bound > series.Max() {
+			break
+		}
+
+		if top.entry == nil {
+			// the key was a lower bound, the entry might belong to the next series
+			if err := m.read(heap.Pop(&m.heads).(fragment[O]).it); err != nil {
+				return nil, false, m.fail(err)
+			}
+			continue
+		}
+
+		fragment, _, err := m.pop()
+		if err != nil {
+			return nil, false, err
+		}
+
+		if !merged {
+			series = series.Clone()
+			merged = true
+		}
+		if fragment.Min() <= series.Max() {
+			sorted = false
+		}
+		if err := series.Append(fragment); err != nil {
+			return nil, false, m.fail(errors.Join(errors.New("failed to merge fragments of a series"), err))
+		}
+	}
+	if !sorted {
+		series.Sort()
+	}
+
+	return series, true, nil
+}
+
+// more tells if there might be values left without loading any fileblock, see ReadPage
+func (m *sortedMergeIterator[O]) more() (bool, error) {
+	if err := m.start(); err != nil {
+		return false, m.fail(err)
+	}
+
+	return m.heads.Len() > 0, nil
+}
+
+func (m *sortedMergeIterator[O]) start() error {
+	if m.started {
+		return nil
+	}
+	m.started = true
+
+	for i := range m.iterators {
+		if err := m.advance(i); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// pop returns the next entry and advances the iterator it was read from
+func (m *sortedMergeIterator[O]) pop() (Entry[O], bool, error) {
+	for m.heads.Len() > 0 {
+		top := heap.Pop(&m.heads).(fragment[O])
+		if top.entry == nil {
+			if err := m.read(top.it); err != nil {
+				return nil, false, m.fail(err)
+			}
+			continue
+		}
+
+		if err := m.advance(top.it); err != nil {
+			return nil, false, m.fail(err)
+		}
+
+		return top.entry, true, nil
+	}
+
+	return nil, false, nil
+}
+
+// advance pushes the key of the next entry of the i-th iterator if it knows it, or reads the entry
+func (m *sortedMergeIterator[O]) advance(i int) error {
+	if m.nextKeys[i] == nil {
+		return m.read(i)
+	}
+
+	if key, found := m.nextKeys[i](); found {
+		heap.Push(&m.heads, fragment[O]{key: key, it: i})
+	}
+
+	return nil
+}
+
+// read pushes the next entry of the i-th iterator, with its values sorted
+func (m *sortedMergeIterator[O]) read(i int) error {
+	for {
+		entry, found, err := m.iterators[i].Next()
+		if err != nil || !found {
+			return err
+		}
+		if entry.Len() == 0 {
+			continue
+		}
+
+		entry.Sort()
+		heap.Push(&m.heads, fragment[O]{key: newFragmentKey(entry, false), entry: entry, it: i})
+		return nil
+	}
+}
+
+// fail closes the iterators, Next doesn't return anything else after err
+func (m *sortedMergeIterator[O]) fail(err error) error {
+	m.heads.items = nil
+	return errors.Join(err, m.Close())
+}
+
+func (m *sortedMergeIterator[O]) Close() error {
+	errs := make([]error, 0)
+	for _, it := range m.iterators {
+		if err := it.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// SortFragments sorts the entries in the order that NewSortedMergeIterator expects from its iterators
+func SortFragments[O cmp.Ordered](entries []Entry[O]) {
+	h := fragmentHeap[O]{}
+	slices.SortFunc(entries, func(a, b Entry[O]) int {
+		return h.compare(newFragmentKey(a, false), newFragmentKey(b, false))
+	})
+}
+
+// fragmentKey is the position of an entry, or the first one a fileblock can hold, in a merge
+type fragmentKey[O cmp.Ordered] struct {
+	pIdx, sIdx string
+	bound      O
+}
+
+func newFragmentKey[O cmp.Ordered](entry Entry[O], descending bool) fragmentKey[O] {
+	key := fragmentKey[O]{pIdx: entry.PrimaryIndex(), sIdx: entry.SecondaryIndex(), bound: entry.Min()}
+	if descending {
+		key.bound = entry.Max()
+	}
+
+	return key
+}
+
+// fragment is an entry read from the it-th iterator of a merge, or a fileblock not loaded yet
+type fragment[O cmp.Ordered] struct {
+	key   fragmentKey[O]
+	entry Entry[O]
+	fb    *Fileblock[O]
+	it    int
+}
+
+// fragmentHeap orders fragments by primary index, secondary index and min value, or max value in
+// descending order
+type fragmentHeap[O cmp.Ordered] struct {
+	items      []fragment[O]
+	descending bool
+}
+
+func (h *fragmentHeap[O]) compare(a, b fragmentKey[O]) int {
+	if c := strings.Compare(a.pIdx, b.pIdx); c != 0 {
+		return c
+	}
+	if c := strings.Compare(a.sIdx, b.sIdx); c != 0 {
+		return c
+	}
+	if h.descending {
+		return cmp.Compare(b.bound, a.bound)
+	}
+
+	return cmp.Compare(a.bound, b.bound)
+}
+
+func (h *fragmentHeap[O]) less(a, b fragmentKey[O]) bool { return h.compare(a, b) < 0 }
+
+func (h *fragmentHeap[O]) Len() int { return len(h.items) }
+
+func (h *fragmentHeap[O]) Less(i, j int) bool { return h.less(h.items[i].key, h.items[j].key) }
+
+func (h *fragmentHeap[O]) Swap(i, j int) { h.items[i], h.items[j] = h.items[j], h.items[i] }
+
+func (h *fragmentHeap[O]) Push(x any) { h.items = append(h.items, x.(fragment[O])) }
+
+func (h *fragmentHeap[O]) Pop() any {
+	old := h.items
+	n := len(old)
+	x := old[n-1]
+	h.items = old[:n-1]
+
+	return x
+}
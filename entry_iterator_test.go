@@ -1,6 +1,8 @@
 package streedb
 
 import (
+	"context"
+	"errors"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -13,7 +15,7 @@ func TestEntryIterator(t *testing.T) {
 
 	btree := createMockIndex(t)
 
-	iter, found, err := btree.AscendRangeWithFilters(1, 8, PrimaryIndexFilter("instance1"), SecondaryIndexFilter[int64]("cpu"))
+	iter, found, err := btree.AscendRangeWithFilters(context.Background(), 1, 8, PrimaryIndexFilter("instance1"), SecondaryIndexFilter[int64]("cpu"))
 	assert.True(t, found)
 	require.Nil(t, err)
 
@@ -61,7 +63,7 @@ func TestEntryIterator(t *testing.T) {
 
 	// By just requesting the instance1:cpu entries, we should get a total of 3 entries: 2 for cpu and 1 for mem
 	// But the btree actually contains 4 entries, one extra for instance2:cpu
-	iter, found, err = btree.AscendRangeWithFilters(1, 8, PrimaryIndexFilter("instance1"))
+	iter, found, err = btree.AscendRangeWithFilters(context.Background(), 1, 8, PrimaryIndexFilter("instance1"))
 	assert.True(t, found)
 	require.Nil(t, err)
 
@@ -84,7 +86,7 @@ func TestEntryIterator(t *testing.T) {
 
 	// By just requesting the cpu entries, we should get a total of 3 entries: 2 for instance1 and 1 for instance2
 	// But the btree actually contains 4 entries, one extra for instance1:mem
-	iter, found, err = btree.AscendRangeWithFilters(1, 8, SecondaryIndexFilter[int64]("cpu"))
+	iter, found, err = btree.AscendRangeWithFilters(context.Background(), 1, 8, SecondaryIndexFilter[int64]("cpu"))
 	assert.True(t, found)
 	require.Nil(t, err)
 	cpuTotal = 0
@@ -118,7 +120,7 @@ func TestEntryIterator(t *testing.T) {
 	assert.Equal(t, 2, instance1Total)
 	assert.Equal(t, 1, instance2Total)
 
-	iter, found, err = btree.AscendRangeWithFilters(1, 8)
+	iter, found, err = btree.AscendRangeWithFilters(context.Background(), 1, 8)
 	assert.True(t, found)
 	require.Nil(t, err)
 	cpuTotal = 0
@@ -136,3 +138,284 @@ func TestEntryIterator(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Nil(t, entry)
 }
+
+// pinnedFileblocks counts the fileblocks of the index that some reader still has pinned
+func pinnedFileblocks(btree *BtreeIndex[int64, int64]) int {
+	pinned := 0
+	btree.Ascend(func(i *BtreeItem[int64, int64]) bool {
+		i.Val.Each(func(fb *Fileblock[int64]) bool {
+			fb.mu.Lock()
+			defer fb.mu.Unlock()
+			if fb.refs > 0 {
+				pinned++
+			}
+			return true
+		})
+		return true
+	})
+
+	return pinned
+}
+
+func TestEntryIteratorClose(t *testing.T) {
+	t.Run("Early", func(t *testing.T) {
+		btree := createMockIndex(t)
+
+		iter, found, err := btree.AscendRangeWithFilters(context.Background(), 1, 8)
+		require.NoError(t, err)
+		require.True(t, found)
+
+		_, found, err = iter.Next()
+		require.NoError(t, err)
+		require.True(t, found)
+
+		require.NoError(t, iter.Close())
+		assert.Equal(t, 0, pinnedFileblocks(btree))
+
+		entry, found, err := iter.Next()
+		assert.NoError(t, err)
+		assert.False(t, found)
+		assert.Nil(t, entry)
+	})
+
+	t.Run("ContextCancelled", func(t *testing.T) {
+		btree := createMockIndex(t)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		iter, found, err := btree.AscendRangeWithFilters(ctx, 1, 8)
+		require.NoError(t, err)
+		require.True(t, found)
+		cancel()
+
+		// an entry that was already on its way can still be returned
+		for _, found, err = iter.Next(); found; _, found, err = iter.Next() {
+		}
+		assert.ErrorIs(t, err, context.Canceled)
+
+		require.NoError(t, iter.Close())
+		assert.Equal(t, 0, pinnedFileblocks(btree))
+	})
+
+	t.Run("LoadError", func(t *testing.T) {
+		btree := NewBtreeIndex[int64, int64](3, LLFComp)
+		btree.Upsert(1, createMockFileblock("instance1", "cpu", 1, 4))
+		broken := createMockFileblock("instance1", "cpu", 5, 9)
+		broken.filesystem.(*mockFilesystem[int64]).loadErr = errors.New("disk error")
+		btree.Upsert(5, broken)
+		btree.Upsert(10, createMockFileblock("instance1", "cpu", 10, 12))
+
+		iter, found, err := btree.AscendRangeWithFilters(context.Background(), 1, 12)
+		require.NoError(t, err)
+		require.True(t, found)
+
+		_, found, err = iter.Next()
+		require.NoError(t, err)
+		require.True(t, found)
+
+		_, found, err = iter.Next()
+		assert.False(t, found)
+		assert.ErrorContains(t, err, "disk error")
+
+		// the fileblocks that weren't loaded were released
+		assert.Equal(t, 0, pinnedFileblocks(btree))
+		require.NoError(t, iter.Close())
+	})
+	t.Run("ReleaseError", func(t *testing.T) {
+		btree := NewBtreeIndex[int64, int64](3, LLFComp)
+		btree.Upsert(1, createMockFileblock("instance1", "cpu", 1, 4))
+		loaded := createMockFileblock("instance1", "cpu", 5, 9)
+		loaded.filesystem.(*mockFilesystem[int64]).removeErr = errors.New("disk error")
+		btree.Upsert(5, loaded)
+		pending := createMockFileblock("instance1", "cpu", 10, 12)
+		pending.filesystem.(*mockFilesystem[int64]).removeErr = errors.New("disk full")
+		btree.Upsert(10, pending)
+
+		iter, found, err := btree.AscendRangeWithFilters(context.Background(), 1, 12)
+		require.NoError(t, err)
+		require.True(t, found)
+
+		// removed while pinned, their files are deleted by the last release
+		require.NoError(t, loaded.Remove())
+		require.NoError(t, pending.Remove())
+
+		_, found, err = iter.Next()
+		require.NoError(t, err)
+		require.True(t, found)
+
+		_, found, err = iter.Next()
+		assert.False(t, found)
+		assert.ErrorContains(t, err, "disk error")
+		assert.ErrorContains(t, err, "disk full")
+		assert.Equal(t, 0, pinnedFileblocks(btree))
+		require.NoError(t, iter.Close())
+
+		// Close returns the error of a release that Next didn't
+		btree = NewBtreeIndex[int64, int64](3, LLFComp)
+		btree.Upsert(1, createMockFileblock("instance1", "cpu", 1, 4))
+		pending = createMockFileblock("instance1", "cpu", 5, 9)
+		pending.filesystem.(*mockFilesystem[int64]).removeErr = errors.New("disk error")
+		btree.Upsert(5, pending)
+
+		iter, found, err = btree.AscendRangeWithFilters(context.Background(), 1, 9)
+		require.NoError(t, err)
+		require.True(t, found)
+		require.NoError(t, pending.Remove())
+
+		_, found, err = iter.Next()
+		require.NoError(t, err)
+		require.True(t, found)
+		assert.ErrorContains(t, iter.Close(), "disk error")
+		assert.Equal(t, 0, pinnedFileblocks(btree))
+	})
+}
+
+func TestSortedMergeIterator(t *testing.T) {
+	wal := NewListIterator([]Entry[int64]{
+		NewKv("instance1", "cpu", []int64{9, 7}, []int32{9, 7}),
+		NewKv("instance2", "cpu", []int64{1}, []int32{1}),
+	})
+	levels := NewListIterator([]Entry[int64]{
+		NewKv("instance1", "cpu", []int64{1, 3}, []int32{1, 3}),
+		NewKv("instance1", "cpu", []int64{4, 8}, []int32{4, 8}),
+		NewKv("instance1", "mem", []int64{2}, []int32{2}),
+	})
+
+	iter := NewSortedMergeIterator[int64](wal, levels)
+	expected := []*Kv{
+		NewKv("instance1", "cpu", []int64{1, 3, 4, 7, 8, 9}, []int32{1, 3, 4, 7, 8, 9}),
+		NewKv("instance1", "mem", []int64{2}, []int32{2}),
+		NewKv("instance2", "cpu", []int64{1}, []int32{1}),
+	}
+	for _, e := range expected {
+		entry, found, err := iter.Next()
+		require.NoError(t, err)
+		require.True(t, found)
+		kv := entry.(*Kv)
+		assert.Equal(t, e.PrimaryIdx, kv.PrimaryIdx)
+		assert.Equal(t, e.Key, kv.Key)
+		assert.Equal(t, e.Ts, kv.Ts)
+		assert.Equal(t, e.Val, kv.Val)
+	}
+
+	_, found, err := iter.Next()
+	assert.NoError(t, err)
+	assert.False(t, found)
+	require.NoError(t, iter.Close())
+
+	t.Run("LoadError", func(t *testing.T) {
+		btree := createMockIndex(t)
+		btree.Upsert(6, createMockFileblock("instance1", "cpu", 6, 7))
+		ll, _ := btree.Get(6)
+		head, _ := ll.Head()
+		head.Val.filesystem.(*mockFilesystem[int64]).loadErr = errors.New("disk error")
+
+		dbIter, found, err := btree.AscendRangeWithFilters(context.Background(), 1, 8)
+		require.NoError(t, err)
+		require.True(t, found)
+
+		_, found, err = NewSortedMergeIterator(dbIter).Next()
+		assert.False(t, found)
+		assert.ErrorContains(t, err, "disk error")
+		assert.Equal(t, 0, pinnedFileblocks(btree))
+	})
+}
+
+func TestSortedMergeIteratorStreams(t *testing.T) {
+	btree := NewBtreeIndex[int64, int64](3, LLFComp)
+	btree.Upsert(1, createMockFileblock("instance1", "cpu", 1, 4))
+	btree.Upsert(2, createMockFileblock("instance1", "mem", 2, 3))
+	btree.Upsert(3, createMockFileblock("instance2", "cpu", 3, 4))
+	btree.Upsert(5, createMockFileblock("instance1", "cpu", 5, 9))
+	loads := func() []int {
+		res := make([]int, 0)
+		btree.Ascend(func(i *BtreeItem[int64, int64]) bool {
+			i.Val.Each(func(fb *Fileblock[int64]) bool {
+				res = append(res, fb.filesystem.(*mockFilesystem[int64]).extra.load)
+				return true
+			})
+			return true
+		})
+		return res
+	}
+
+	dbIter, found, err := btree.AscendRangeWithFilters(context.Background(), 1, 9)
+	require.NoError(t, err)
+	require.True(t, found)
+	iter := NewSortedMergeIterator(dbIter)
+	defer iter.Close()
+
+	entry, found, err := iter.Next()
+	require.NoError(t, err)
+	require.True(t, found)
+	assert.Equal(t, "cpu", entry.SecondaryIndex())
+	assert.Equal(t, 2, entry.Len())
+
+	// both cpu fileblocks have been loaded and instance2 hasn't been yet, in ascending order of min:
+	// cpu 1-4, mem 2-3, instance2 3-4, cpu 5-9
+	loaded := loads()
+	assert.Equal(t, 1, loaded[0])
+	assert.Equal(t, 0, loaded[2])
+	assert.Equal(t, 1, loaded[3])
+
+	for _, sIdx := range []string{"mem", "cpu"} {
+		entry, found, err = iter.Next()
+		require.NoError(t, err)
+		require.True(t, found)
+		assert.Equal(t, sIdx, entry.SecondaryIndex())
+	}
+	assert.Equal(t, []int{1, 1, 1, 1}, loads())
+	assert.Equal(t, 0, pinnedFileblocks(btree))
+}
+
+func TestDescendRangeWithFilters(t *testing.T) {
+	btree := createMockIndex(t)
+
+	iter, found, err := btree.DescendRangeWithFilters(context.Background(), 8, 1, PrimaryIndexFilter("instance1"), SecondaryIndexFilter[int64]("cpu"))
+	require.NoError(t, err)
+	require.True(t, found)
+	defer iter.Close()
+
+	// the fileblock with the greatest key comes first
+	for _, min := range []int64{5, 1} {
+		entry, found, err := iter.Next()
+		require.NoError(t, err)
+		require.True(t, found)
+		assert.Equal(t, min, entry.Min())
+	}
+
+	_, found, err = iter.Next()
+	assert.NoError(t, err)
+	assert.False(t, found)
+
+	_, found, err = btree.DescendRangeWithFilters(context.Background(), 0, -10)
+	assert.NoError(t, err)
+	assert.False(t, found)
+}
+
+func TestSortedMergeIteratorNextKeys(t *testing.T) {
+	blocks := []*Fileblock[int64]{
+		createMockFileblock("instance1", "cpu", 1, 4),
+		createMockFileblock("instance1", "mem", 2, 3),
+		createMockFileblock("instance1", "cpu", 5, 9),
+	}
+	btree := NewBtreeIndex[int64, int64](3, LLFComp)
+	for _, fb := range blocks {
+		btree.Upsert(*fb.Min, fb)
+	}
+
+	dbIter, found, err := btree.AscendRangeWithFilters(context.Background(), 1, 9)
+	require.NoError(t, err)
+	require.True(t, found)
+	iter := NewSortedMergeIterator(dbIter)
+	defer iter.Close()
+
+	entry, found, err := iter.Next()
+	require.NoError(t, err)
+	require.True(t, found)
+	assert.Equal(t, "cpu", entry.SecondaryIndex())
+
+	// the key of mem is known from the rows of its fileblock, it's loaded on the next call
+	for i, loads := range []int{1, 0, 1} {
+		assert.Equal(t, loads, blocks[i].filesystem.(*mockFilesystem[int64]).extra.load)
+	}
+}
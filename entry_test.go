@@ -26,3 +26,53 @@ func TestEntries(t *testing.T) {
 	assert.Equal(t, "hello", first.PrimaryIndex())
 	assert.Equal(t, "hello 15", first.SecondaryIndex())
 }
+
+func TestEntriesMapSizeBytes(t *testing.T) {
+	em := NewEntriesMap[int64]()
+	assert.Equal(t, 0, em.SizeBytes())
+
+	cpu := NewKv("instance1", "cpu", make([]int64, 100, 100), make([]int32, 100, 100))
+	em.Append(cpu)
+	assert.Equal(t, cpu.SizeBytes(), em.SizeBytes())
+	assert.Greater(t, em.SizeBytes(), 100*(8+4))
+
+	em.Append(NewKv("instance1", "mem", make([]int64, 10, 10), make([]int32, 10, 10)))
+	em.Append(NewKv("instance1", "cpu", make([]int64, 1000, 1000), make([]int32, 1000, 1000)))
+
+	total := 0
+	em.Range(func(key string, value Entry[int64]) bool {
+		total += EntrySize(value)
+		return true
+	})
+	assert.Equal(t, total, em.SizeBytes())
+	assert.Greater(t, em.SizeBytes(), 1110*(8+4))
+}
+
+func TestEntriesMapApplyTombstones(t *testing.T) {
+	em := NewEntriesMap[int64]()
+	em.Append(NewKv("instance1", "cpu", []int64{1, 2, 3, 4, 5}, []int32{1, 2, 3, 4, 5}))
+	em.Append(NewKv("instance1", "mem", []int64{1, 2}, []int32{6, 7}))
+	em.Append(NewKv("instance1", "disk", []int64{1, 2}, []int32{8, 9}))
+
+	_, err := NewTombstone[int64]("", "cpu", 1, 2)
+	assert.ErrorIs(t, err, ErrInvalidTombstone)
+
+	cpu, err := NewTombstone[int64]("instance1", "cpu", 2, 3)
+	require.NoError(t, err)
+	all, err := NewTombstone[int64]("instance1", "", 1, 2)
+	require.NoError(t, err)
+	other, err := NewTombstone[int64]("instance2", "", 0, 10)
+	require.NoError(t, err)
+
+	em.ApplyTombstones(cpu, other)
+	assert.Equal(t, []int64{1, 4, 5}, em.Get("cpu").(*Kv).Ts)
+	assert.Equal(t, []int32{1, 4, 5}, em.Get("cpu").(*Kv).Val)
+	assert.Equal(t, []int64{1, 2}, em.Get("mem").(*Kv).Ts)
+
+	// entries left empty are removed
+	em.ApplyTombstones(all)
+	assert.Equal(t, []int64{4, 5}, em.Get("cpu").(*Kv).Ts)
+	assert.Equal(t, []int32{4, 5}, em.Get("cpu").(*Kv).Val)
+	assert.Equal(t, 1, em.SecondaryIndicesLen())
+	assert.Equal(t, EntrySize(em.Get("cpu")), em.SizeBytes())
+}
@@ -6,12 +6,27 @@ import (
 	"fmt"
 	"slices"
 	"strings"
+	"sync"
 )
 
 type FileblockCreator[O cmp.Ordered] interface {
 	NewFileblock(es *EntriesMap[O], builder *MetadataBuilder[O]) error
 }
 
+// TombstoneWriter records a tombstone in every fileblock it affects
+type TombstoneWriter[O cmp.Ordered] interface {
+	AddTombstone(Tombstone[O]) error
+	// ReplayTombstone is AddTombstone for a tombstone with the wal lsn lsn, fileblocks holding
+	// entries up to lsn or later are skipped
+	ReplayTombstone(t Tombstone[O], lsn uint64) error
+}
+
+// WalLsnReader returns the highest wal lsn of the fileblocks of every primary index. Entries up to
+// it are in fileblocks even if the wal didn't record their flush.
+type WalLsnReader interface {
+	WalLsns() map[string]uint64
+}
+
 type FileblockListener[O cmp.Ordered] interface {
 	OnFileblockCreated(*Fileblock[O])
 	OnFileblockRemoved(*Fileblock[O])
@@ -25,15 +40,150 @@ func NewFileblock[O cmp.Ordered](cfg *Config, meta *MetaFile[O], filesystem File
 	}
 }
 
+// Fileblock is reference counted: readers pin it with Acquire before loading it, and Remove defers
+// the deletion of its files until the last of them calls Release.
 type Fileblock[O cmp.Ordered] struct {
 	MetaFile[O]
 
 	cfg        *Config
 	filesystem Filesystem[O]
+
+	mu      sync.Mutex
+	refs    int
+	removed bool
+
+	// origin is the fileblock a view was made of. A view is pinned by pinning its origin
+	origin *Fileblock[O]
+}
+
+// Acquire pins the fileblock so that its files are not deleted until Release is called. It returns
+// false if the fileblock has been removed and nobody else has it pinned, its files might be gone.
+func (l *Fileblock[O]) Acquire() bool {
+	if l.origin != nil {
+		return l.origin.Acquire()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.removed && l.refs == 0 {
+		return false
+	}
+	l.refs++
+
+	return true
+}
+
+// Release unpins the fileblock. The last release of a removed fileblock deletes its files.
+func (l *Fileblock[O]) Release() error {
+	if l.origin != nil {
+		return l.origin.Release()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.refs == 0 {
+		return errors.New("fileblock released more times than acquired")
+	}
+	l.refs--
+
+	if l.refs == 0 && l.removed {
+		return l.delete()
+	}
+
+	return nil
+}
+
+// Remove marks the fileblock as removed and deletes its files from the filesystem, straight away
+// if no reader has it pinned or on the last Release otherwise. Listeners must have been notified of
+// the removal already, so that no new reader can find it.
+func (l *Fileblock[O]) Remove() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.removed {
+		return nil
+	}
+	l.removed = true
+
+	if l.refs == 0 {
+		return l.delete()
+	}
+
+	return nil
+}
+
+// delete must be called holding mu
+func (l *Fileblock[O]) delete() error {
+	if err := l.filesystem.Remove(l, nil); err != nil {
+		return errors.Join(fmt.Errorf("error deleting fileblock '%s'", l.Uuid), err)
+	}
+
+	return nil
 }
 
+// Load returns the entries of the fileblock without the values masked by its tombstones
 func (l *Fileblock[O]) Load() (*EntriesMap[O], error) {
-	return l.filesystem.Load(l)
+	entries, err := l.filesystem.Load(l)
+	if err != nil {
+		return nil, err
+	}
+
+	l.mu.Lock()
+	tombstones := slices.Clone(l.Tombstones)
+	l.mu.Unlock()
+
+	if len(tombstones) > 0 {
+		entries.ApplyTombstones(tombstones...)
+	}
+
+	return entries, nil
+}
+
+// View returns a copy of the fileblock that keeps the tombstones it has now. It shares the files of
+// the original, so it must only be loaded while the original is pinned. Acquire and Release on the
+// view pin and unpin the original.
+func (l *Fileblock[O]) View() *Fileblock[O] {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	meta := l.MetaFile
+	meta.Tombstones = slices.Clone(l.Tombstones)
+
+	view := NewFileblock(l.cfg, &meta, l.filesystem)
+	view.origin = l
+	if l.origin != nil {
+		view.origin = l.origin
+	}
+
+	return view
+}
+
+// HasTombstones tells if some values of the fileblock might be masked, its rows count them anyway
+func (l *Fileblock[O]) HasTombstones() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return len(l.Tombstones) > 0
+}
+
+// AddTombstone records a tombstone in the metadata of the fileblock
+func (l *Fileblock[O]) AddTombstone(t Tombstone[O]) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if slices.Contains(l.Tombstones, t) {
+		return nil
+	}
+
+	l.Tombstones = append(l.Tombstones, t)
+	if err := l.filesystem.UpdateMetadata(l); err != nil {
+		l.Tombstones = l.Tombstones[:len(l.Tombstones)-1]
+		return errors.Join(fmt.Errorf("error recording tombstone in fileblock '%s'", l.Uuid), err)
+	}
+
+	return nil
 }
 
 func (l *Fileblock[O]) Find(v Entry[O]) bool {
@@ -46,6 +196,56 @@ func (l *Fileblock[O]) Find(v Entry[O]) bool {
 	return false
 }
 
+// Matches tells if the fileblock passes the filters of the primary index, and if any of its rows
+// passes the filters of the secondary index. The bloom filter is checked before the rows are
+// scanned. Fileblocks without rows can't be pruned, they always pass the latter.
+func (l *Fileblock[O]) Matches(filters ...EntryFilter) bool {
+	return l.matches(nil, filters)
+}
+
+// MatchesRange is Matches for the rows with values between min and max, both included, so that a
+// fileblock isn't loaded when none of the series it holds in the range passes the filters.
+func (l *Fileblock[O]) MatchesRange(min, max O, filters ...EntryFilter) bool {
+	return l.matches(func(r *Row[O]) bool { return r.Min <= max && min <= r.Max }, filters)
+}
+
+// matches is Matches for the rows that pass inRange, every row if it's nil
+func (l *Fileblock[O]) matches(inRange func(*Row[O]) bool, filters []EntryFilter) bool {
+	rowFilters := make([]EntryFilter, 0, len(filters))
+	for _, filter := range filters {
+		if filter.Kind() != PrimaryIndexFilterKind {
+			rowFilters = append(rowFilters, filter)
+		} else if !filter.Filter(l) {
+			return false
+		}
+	}
+
+	if l.Bloom != nil {
+		for _, filter := range rowFilters {
+			if m, ok := filter.(MembershipFilter); ok {
+				if key, found := m.MembershipKey(); found && !l.Bloom.Contains(key) {
+					return false
+				}
+			}
+		}
+	}
+
+	if (len(rowFilters) == 0 && inRange == nil) || len(l.Rows) == 0 {
+		return true
+	}
+
+	for i := range l.Rows {
+		if inRange != nil && !inRange(&l.Rows[i]) {
+			continue
+		}
+		if MatchesFilters(&rowIndexer[O]{pIdx: l.PrimaryIdx, row: &l.Rows[i]}, rowFilters...) {
+			return true
+		}
+	}
+
+	return false
+}
+
 func (l *Fileblock[O]) Metadata() *MetaFile[O] {
 	return &l.MetaFile
 }
@@ -97,14 +297,14 @@ func Merge[O cmp.Ordered](a *Fileblock[O], b ...*Fileblock[O]) (*MetadataBuilder
 	}
 
 	builder := NewMetadataBuilder[O](a.cfg)
-	var res *EntriesMap[O]
+	res := entries
 	for _, c := range b {
 		entries2, err := c.Load()
 		if err != nil {
 			return nil, nil, errors.Join(fmt.Errorf("failed to load block '%s'", c.Metadata().DataFilepath), err)
 		}
 
-		res, err = entries.Merge(entries2)
+		res, err = res.Merge(entries2)
 		if err != nil {
 			return nil, nil, errors.Join(errors.New("failed to merge entries"), err)
 		}
@@ -117,7 +317,8 @@ func Merge[O cmp.Ordered](a *Fileblock[O], b ...*Fileblock[O]) (*MetadataBuilder
 		builder.WithLevel(higherLevel).
 			WithPrimaryIndex(a.PrimaryIdx).
 			WithMin(*a.Min).WithMin(*c.Min).
-			WithMax(*a.Max).WithMax(*c.Max)
+			WithMax(*a.Max).WithMax(*c.Max).
+			WithWalLsn(a.WalLsn).WithWalLsn(c.WalLsn)
 	}
 
 	return builder, res, nil
@@ -39,3 +39,10 @@ type Filesystem[O cmp.Ordered] interface {
 	Remove(*Fileblock[O], []FileblockListener[O]) error
 	UpdateMetadata(*Fileblock[O]) error
 }
+
+// FileblockReplacer is implemented by the filesystems that keep a manifest. Replace creates a
+// fileblock that takes the place of old in a single edit of the manifest, the files of old are still
+// deleted by its Remove.
+type FileblockReplacer[O cmp.Ordered] interface {
+	Replace(cfg *Config, old *Fileblock[O], entries *EntriesMap[O], builder *MetadataBuilder[O], listeners []FileblockListener[O]) (*Fileblock[O], error)
+}
@@ -0,0 +1,218 @@
+package streedb
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+)
+
+var ErrInvalidFilter = errors.New("invalid filter")
+
+// PrefixedFilter is implemented by filters that only match indexes starting with a literal prefix,
+// so that the primary index can be scanned from it instead of reading every fileblock
+type PrefixedFilter interface {
+	// Prefix returns the prefix, empty when it can match any index
+	Prefix() string
+}
+
+func (p *primaryIndexFilter) Prefix() string {
+	return p.pIdx
+}
+
+// RegexFilter matches the indexes of the kind with the regular expression. It isn't anchored, use ^
+// and $ to match whole indexes.
+func RegexFilter(kind EntryFilterKind, expr string) (EntryFilter, error) {
+	return newIndexFilter(kind, indexOfKind(kind), true, regexMatcher, expr)
+}
+
+func PrefixFilter(kind EntryFilterKind, prefix string) EntryFilter {
+	f, _ := newIndexFilter(kind, indexOfKind(kind), true, prefixMatcher, prefix)
+	return f
+}
+
+// GlobFilter matches the indexes of the kind with a shell pattern, like instance-*. See path.Match
+// for the syntax.
+func GlobFilter(kind EntryFilterKind, pattern string) (EntryFilter, error) {
+	return newIndexFilter(kind, indexOfKind(kind), true, globMatcher, pattern)
+}
+
+// NotFilter matches what the filter doesn't
+func NotFilter(f EntryFilter) EntryFilter {
+	return &notFilter{f: f}
+}
+
+// ParseFilter builds a filter of the kind from an expression: a plain value matches it exactly,
+// while prefix:, glob: and regex: use the matching filter. A leading ! negates the expression.
+func ParseFilter(kind EntryFilterKind, expr string) (EntryFilter, error) {
+	return parseFilter(kind, indexOfKind(kind), true, expr)
+}
+
+// parseFilter is ParseFilter matching the value returned by index. prefixed tells if the prefix of
+// the expression is a prefix of the indexes of the kind.
+func parseFilter(kind EntryFilterKind, index func(Indexer) string, prefixed bool, expr string) (EntryFilter, error) {
+	if negated, found := strings.CutPrefix(expr, "!"); found {
+		f, err := parseFilter(kind, index, prefixed, negated)
+		if err != nil {
+			return nil, err
+		}
+		return NotFilter(f), nil
+	}
+
+	if prefix, found := strings.CutPrefix(expr, "prefix:"); found {
+		return newIndexFilter(kind, index, prefixed, prefixMatcher, prefix)
+	}
+	if pattern, found := strings.CutPrefix(expr, "glob:"); found {
+		return newIndexFilter(kind, index, prefixed, globMatcher, pattern)
+	}
+	if re, found := strings.CutPrefix(expr, "regex:"); found {
+		return newIndexFilter(kind, index, prefixed, regexMatcher, re)
+	}
+	if expr == "" {
+		return nil, fmt.Errorf("%w: empty expression", ErrInvalidFilter)
+	}
+
+	f, err := newIndexFilter(kind, index, prefixed, exactMatcher, expr)
+	if err != nil {
+		return nil, err
+	}
+	f.(*indexFilter).value = expr
+
+	return f, nil
+}
+
+// a matcher returns the function that matches the expression, and the prefix of every match
+type matcher func(expr string) (func(string) bool, string, error)
+
+func exactMatcher(value string) (func(string) bool, string, error) {
+	return func(s string) bool { return s == value }, value, nil
+}
+
+func prefixMatcher(prefix string) (func(string) bool, string, error) {
+	return func(s string) bool { return strings.HasPrefix(s, prefix) }, prefix, nil
+}
+
+func globMatcher(pattern string) (func(string) bool, string, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, "", errors.Join(ErrInvalidFilter, err)
+	}
+
+	// the prefix ends at the first special character
+	prefix := pattern
+	if i := strings.IndexAny(pattern, `*?[\`); i >= 0 {
+		prefix = pattern[:i]
+	}
+
+	return func(s string) bool {
+		matched, _ := path.Match(pattern, s)
+		return matched
+	}, prefix, nil
+}
+
+func regexMatcher(expr string) (func(string) bool, string, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, "", errors.Join(ErrInvalidFilter, err)
+	}
+
+	return re.MatchString, regexPrefix(expr), nil
+}
+
+// regexPrefix returns the literal that follows a leading ^, the one every match must start with
+func regexPrefix(expr string) string {
+	re, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return ""
+	}
+
+	re = re.Simplify()
+	if re.Op != syntax.OpConcat || len(re.Sub) < 2 || re.Sub[0].Op != syntax.OpBeginText {
+		return ""
+	}
+	if lit := re.Sub[1]; lit.Op == syntax.OpLiteral && lit.Flags&syntax.FoldCase == 0 {
+		return string(lit.Rune)
+	}
+
+	return ""
+}
+
+func indexOfKind(kind EntryFilterKind) func(Indexer) string {
+	if kind == PrimaryIndexFilterKind {
+		return Indexer.PrimaryIndex
+	}
+
+	return Indexer.SecondaryIndex
+}
+
+// MatchesFilters tells if the indexes pass every filter
+func MatchesFilters(i Indexer, filters ...EntryFilter) bool {
+	for _, filter := range filters {
+		if !filter.Filter(i) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// FilterPrefix returns the longest prefix that the indexes of the kind must start with to pass
+// every filter, empty if there's none
+func FilterPrefix(kind EntryFilterKind, filters ...EntryFilter) string {
+	res := ""
+	for _, filter := range filters {
+		if p, ok := filter.(PrefixedFilter); ok && filter.Kind() == kind && len(p.Prefix()) > len(res) {
+			res = p.Prefix()
+		}
+	}
+
+	return res
+}
+
+func newIndexFilter(kind EntryFilterKind, index func(Indexer) string, prefixed bool, m matcher, expr string) (EntryFilter, error) {
+	match, prefix, err := m(expr)
+	if err != nil {
+		return nil, err
+	}
+	if !prefixed {
+		prefix = ""
+	}
+
+	return &indexFilter{kind: kind, index: index, match: match, prefix: prefix}, nil
+}
+
+type indexFilter struct {
+	kind   EntryFilterKind
+	index  func(Indexer) string
+	match  func(string) bool
+	prefix string
+	// value is set when the filter only matches it
+	value string
+}
+
+func (f *indexFilter) Filter(c Indexer) bool {
+	return f.match(f.index(c))
+}
+
+func (f *indexFilter) Kind() EntryFilterKind {
+	return f.kind
+}
+
+func (f *indexFilter) Prefix() string {
+	return f.prefix
+}
+
+func (f *indexFilter) MembershipKey() (string, bool) {
+	return secondaryIndexKey(f.value), f.kind == SecondaryIndexFilterKind && f.value != ""
+}
+
+type notFilter struct{ f EntryFilter }
+
+func (n *notFilter) Filter(c Indexer) bool {
+	return !n.f.Filter(c)
+}
+
+func (n *notFilter) Kind() EntryFilterKind {
+	return n.f.Kind()
+}
@@ -0,0 +1,90 @@
+package streedb
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFilter(t *testing.T) {
+	kv := func(pIdx, sIdx string) *Kv { return NewKv(pIdx, sIdx, nil, nil) }
+
+	tests := []struct {
+		expr    string
+		kind    EntryFilterKind
+		matches []*Kv
+		misses  []*Kv
+		prefix  string
+	}{
+		{"instance-1", PrimaryIndexFilterKind, []*Kv{kv("instance-1", "cpu")}, []*Kv{kv("instance-10", "cpu")}, "instance-1"},
+		{"prefix:cpu_", SecondaryIndexFilterKind, []*Kv{kv("a", "cpu_user"), kv("a", "cpu_")}, []*Kv{kv("a", "mem"), kv("cpu_user", "mem")}, "cpu_"},
+		{"glob:instance-*", PrimaryIndexFilterKind, []*Kv{kv("instance-1", "cpu")}, []*Kv{kv("host-1", "cpu")}, "instance-"},
+		{"glob:*-1", PrimaryIndexFilterKind, []*Kv{kv("instance-1", "cpu")}, []*Kv{kv("instance-2", "cpu")}, ""},
+		{"regex:^instance-[0-9]+$", PrimaryIndexFilterKind, []*Kv{kv("instance-12", "cpu")}, []*Kv{kv("instance-a", "cpu")}, "instance-"},
+		{"regex:[0-9]$", PrimaryIndexFilterKind, []*Kv{kv("instance-1", "cpu")}, []*Kv{kv("instance", "cpu")}, ""},
+		{"regex:^a|b", PrimaryIndexFilterKind, []*Kv{kv("a", "cpu"), kv("xb", "cpu")}, []*Kv{kv("x", "cpu")}, ""},
+		{"!glob:cpu_*", SecondaryIndexFilterKind, []*Kv{kv("a", "mem")}, []*Kv{kv("a", "cpu_user")}, ""},
+	}
+
+	for _, test := range tests {
+		filter, err := ParseFilter(test.kind, test.expr)
+		require.NoError(t, err, test.expr)
+		assert.Equal(t, test.kind, filter.Kind(), test.expr)
+
+		for _, kv := range test.matches {
+			assert.True(t, filter.Filter(kv), "%s should match %s/%s", test.expr, kv.PrimaryIdx, kv.Key)
+		}
+		for _, kv := range test.misses {
+			assert.False(t, filter.Filter(kv), "%s shouldn't match %s/%s", test.expr, kv.PrimaryIdx, kv.Key)
+		}
+
+		assert.Equal(t, test.prefix, FilterPrefix(test.kind, filter), test.expr)
+	}
+
+	for _, expr := range []string{"", "glob:[", "regex:(", "!"} {
+		_, err := ParseFilter(PrimaryIndexFilterKind, expr)
+		assert.ErrorIs(t, err, ErrInvalidFilter, expr)
+	}
+}
+
+func TestAscendPrefixWithFilters(t *testing.T) {
+	index := NewBtreeIndex[int64, string](3, LLFComp)
+	for _, fb := range []*Fileblock[int64]{
+		createMockFileblock("instance-1", "cpu_user", 5, 9),
+		createMockFileblock("instance-1", "cpu_user", 1, 4),
+		createMockFileblock("instance-2", "mem", 1, 4),
+		createMockFileblock("instance-3", "cpu_user", 20, 30),
+		createMockFileblock("host-1", "cpu_user", 1, 4),
+	} {
+		index.Upsert(fb.PrimaryIdx, fb)
+	}
+
+	primary, err := GlobFilter(PrimaryIndexFilterKind, "instance-*")
+	require.NoError(t, err)
+	secondary, err := GlobFilter(SecondaryIndexFilterKind, "cpu_*")
+	require.NoError(t, err)
+
+	// fileblocks are read by their min value, also when it's below the min of the range
+	iter, found, err := AscendPrefixWithFilters(context.Background(), index, "instance-", 3, 10, primary, secondary)
+	require.NoError(t, err)
+	require.True(t, found)
+	defer iter.Close()
+
+	for _, min := range []int64{1, 5} {
+		entry, found, err := iter.Next()
+		require.NoError(t, err)
+		require.True(t, found)
+		assert.Equal(t, "instance-1", entry.PrimaryIndex())
+		assert.Equal(t, min, entry.Min())
+	}
+
+	_, found, err = iter.Next()
+	assert.NoError(t, err)
+	assert.False(t, found)
+
+	_, found, err = AscendPrefixWithFilters(context.Background(), index, "other-", 0, 100)
+	assert.NoError(t, err)
+	assert.False(t, found)
+}
@@ -43,8 +43,35 @@ func (b *BasicLevel[O]) Create(es *db.EntriesMap[O], builder *db.MetadataBuilder
 	return fileblock, nil
 }
 
+// RemoveFile unlinks the fileblock straight away, so that no new reader can find it, but its files
+// are only deleted once the readers that have it pinned release it.
 func (b *BasicLevel[O]) RemoveFile(f *db.Fileblock[O]) error {
-	return b.filesystem.Remove(f, b.fileblockListeners)
+	for _, listener := range b.fileblockListeners {
+		listener.OnFileblockRemoved(f)
+	}
+
+	return f.Remove()
+}
+
+// Replace creates a fileblock that takes the place of old and removes old. When the filesystem keeps
+// a manifest both are recorded in a single edit, so that a crash never leaves both of them.
+func (b *BasicLevel[O]) Replace(old *db.Fileblock[O], es *db.EntriesMap[O], builder *db.MetadataBuilder[O]) (*db.Fileblock[O], error) {
+	replacer, ok := b.filesystem.(db.FileblockReplacer[O])
+	if !ok {
+		fileblock, err := b.Create(es, builder)
+		if err != nil {
+			return nil, err
+		}
+
+		return fileblock, b.RemoveFile(old)
+	}
+
+	fileblock, err := replacer.Replace(b.cfg, old, es, builder, b.fileblockListeners)
+	if err != nil {
+		return nil, errors.Join(fmt.Errorf("error replacing block at level: "), err)
+	}
+
+	return fileblock, b.RemoveFile(old)
 }
 
 func (b *BasicLevel[O]) Close() error {
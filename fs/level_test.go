@@ -59,6 +59,7 @@ func (m *mockFilesystem[O]) UpdateMetadata(*db.Fileblock[O]) error {
 
 func TestLevelBasic(t *testing.T) {
 	cfg := db.NewDefaultConfig()
+	cfg.DbPath = t.TempDir()
 	fs := mockFilesystem[int64]{}
 
 	levels, err := NewLeveledFilesystem[int64, *db.Kv](cfg, nil)
@@ -93,6 +94,23 @@ func TestLevelBasic(t *testing.T) {
 			assert.NoError(t, err)
 			assert.Equal(t, 1, fs.extra.remove)
 		})
+
+		t.Run("RemovePinned", func(t *testing.T) {
+			fb, err := level.Create(data, builder)
+			assert.NoError(t, err)
+			assert.True(t, fb.Acquire())
+
+			// a reader has it pinned, so the files are kept and can still be pinned by others
+			assert.NoError(t, level.RemoveFile(fb))
+			assert.Equal(t, 1, fs.extra.remove)
+			assert.True(t, fb.Acquire())
+
+			assert.NoError(t, fb.Release())
+			assert.NoError(t, fb.Release())
+			assert.Equal(t, 2, fs.extra.remove)
+			assert.False(t, fb.Acquire())
+			assert.Error(t, fb.Release())
+		})
 	})
 
 }
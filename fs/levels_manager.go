@@ -2,7 +2,10 @@ package fs
 
 import (
 	"cmp"
+	"context"
 	"errors"
+	"slices"
+	"sync"
 
 	db "github.com/sayden/streedb"
 	local "github.com/sayden/streedb/fs/local"
@@ -15,8 +18,9 @@ func NewLeveledFilesystem[O cmp.Ordered, E db.Entry[O]](cfg *db.Config, listener
 		cfg:                cfg,
 		promoters:          promoter,
 		fileblockListeners: listeners,
-		Index:              db.NewBtreeIndex(5, db.LLFComp[O, O]),
+		Index:              db.NewIntervalIndex[O](),
 		PrimaryIndex:       db.NewBtreeIndex(5, db.LLFComp[O, string]),
+		Postings:           db.NewPostingsIndex[O](),
 	}
 
 	// add self to the listeners
@@ -68,26 +72,72 @@ func NewLeveledFilesystem[O cmp.Ordered, E db.Entry[O]](cfg *db.Config, listener
 	return levels, nil
 }
 
+// MultiFsLevels is safe for concurrent use. Readers get a snapshot of the indexes, fileblocks
+// created or removed afterwards don't change the result of a previous call.
 type MultiFsLevels[O cmp.Ordered] struct {
-	cfg                *db.Config
-	promoters          []db.LevelPromoter[O]
-	levels             map[int]*BasicLevel[O]
-	Index              *db.BtreeIndex[O, O]
-	PrimaryIndex       *db.BtreeIndex[O, string]
+	cfg       *db.Config
+	promoters []db.LevelPromoter[O]
+	levels    map[int]*BasicLevel[O]
+
+	// mu guards the indexes, they must not be accessed directly while the levels are in use
+	mu sync.RWMutex
+	// Index holds the fileblocks by the interval of their values
+	Index        *db.IntervalIndex[O]
+	PrimaryIndex *db.BtreeIndex[O, string]
+	// Postings index the labels of the series, see db.Labeled
+	Postings           *db.PostingsIndex[O]
 	fileblockListeners []db.FileblockListener[O]
 }
 
 func (b *MultiFsLevels[O]) OnFileblockCreated(block *db.Fileblock[O]) {
-	b.Index.Upsert(*block.Metadata().Min, block)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.Index.Insert(block)
 	b.PrimaryIndex.Upsert(*&block.Metadata().PrimaryIdx, block)
+	b.Postings.Add(block)
 }
 
 func (b *MultiFsLevels[O]) OnFileblockRemoved(block *db.Fileblock[O]) {
-	b.Index.Remove(*block.Metadata().Min, block)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.Index.Remove(block)
 	b.PrimaryIndex.Remove(*&block.Metadata().PrimaryIdx, block)
+	b.Postings.Remove(block)
 }
 
 func (b *MultiFsLevels[O]) NewFileblock(es *db.EntriesMap[O], builder *db.MetadataBuilder[O]) error {
+	withEntries(es, builder)
+
+	for _, promoter := range b.promoters {
+		if err := promoter.Promote(builder); err != nil {
+			return errors.Join(errors.New("failed during promotion"), err)
+		}
+	}
+
+	_, err := b.levels[builder.Level].Create(es, builder)
+	if err != nil {
+		return errors.Join(errors.New("failed to create new fileblock in mfs"), err)
+	}
+
+	return nil
+}
+
+// ReplaceFile creates a fileblock with the entries in the level of old, and removes old in the same
+// edit of the manifest of the level. Promoters aren't run, the fileblock takes the place of old.
+func (b *MultiFsLevels[O]) ReplaceFile(old *db.Fileblock[O], es *db.EntriesMap[O], builder *db.MetadataBuilder[O]) error {
+	withEntries(es, builder)
+
+	if _, err := b.levels[old.Level].Replace(old, es, builder.WithLevel(old.Level)); err != nil {
+		return errors.Join(errors.New("failed to replace fileblock in mfs"), err)
+	}
+
+	return nil
+}
+
+// withEntries adds the entries to the metadata of the fileblock being built
+func withEntries[O cmp.Ordered](es *db.EntriesMap[O], builder *db.MetadataBuilder[O]) {
 	es.Range(func(key string, entry db.Entry[O]) bool {
 		if entry.Len() == 0 {
 			return true
@@ -97,21 +147,67 @@ func (b *MultiFsLevels[O]) NewFileblock(es *db.EntriesMap[O], builder *db.Metada
 
 		return true
 	})
+}
 
-	for _, promoter := range b.promoters {
-		if err := promoter.Promote(builder); err != nil {
-			return errors.Join(errors.New("failed during promotion"), err)
-		}
+// AddTombstone records the tombstone in the metadata of the fileblocks that hold values it deletes.
+// Compactions must not run meanwhile, or a fileblock being merged could miss it.
+func (b *MultiFsLevels[O]) AddTombstone(t db.Tombstone[O]) error {
+	return b.addTombstone(t, 0)
+}
+
+// ReplayTombstone records a tombstone replayed from the wal like AddTombstone, but only in the
+// fileblocks written before it. The others hold values appended after the tombstone.
+func (b *MultiFsLevels[O]) ReplayTombstone(t db.Tombstone[O], lsn uint64) error {
+	return b.addTombstone(t, lsn)
+}
+
+// addTombstone records the tombstone in the fileblocks it affects, if lsn isn't 0 the fileblocks
+// with a wal lsn of lsn or higher are skipped
+func (b *MultiFsLevels[O]) addTombstone(t db.Tombstone[O], lsn uint64) error {
+	b.mu.RLock()
+	affected := make([]*db.Fileblock[O], 0)
+	if ll, found := b.PrimaryIndex.Get(t.PrimaryIdx); found {
+		ll.Each(func(fb *db.Fileblock[O]) bool {
+			if lsn > 0 && fb.WalLsn >= lsn {
+				return true
+			}
+			if !t.Overlaps(*fb.Min, *fb.Max) {
+				return true
+			}
+			if t.SecondaryIdx == "" || slices.ContainsFunc(fb.Rows, func(r db.Row[O]) bool { return r.SecondaryIdx == t.SecondaryIdx }) {
+				affected = append(affected, fb)
+			}
+			return true
+		})
 	}
+	b.mu.RUnlock()
 
-	_, err := b.levels[builder.Level].Create(es, builder)
-	if err != nil {
-		return errors.Join(errors.New("failed to create new fileblock in mfs"), err)
+	for _, fb := range affected {
+		if err := fb.AddTombstone(t); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
+// WalLsns implements db.WalLsnReader
+func (b *MultiFsLevels[O]) WalLsns() map[string]uint64 {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	lsns := make(map[string]uint64, b.PrimaryIndex.Len())
+	b.PrimaryIndex.Ascend(func(i *db.BtreeItem[O, string]) bool {
+		i.Val.Each(func(fb *db.Fileblock[O]) bool {
+			lsns[i.Key] = max(lsns[i.Key], fb.WalLsn)
+			return true
+		})
+		return true
+	})
+
+	return lsns
+}
+
 func (b *MultiFsLevels[O]) RemoveFile(a *db.Fileblock[O]) error {
 	meta := a.Metadata()
 	level := meta.Level
@@ -126,17 +222,63 @@ func (b *MultiFsLevels[O]) Create(es *db.EntriesMap[O], meta *db.MetadataBuilder
 	return nil, errors.New("unreachable")
 }
 
-func (b *MultiFsLevels[O]) FindSingle(pIdx, sIdx string, min, max O) (db.EntryIterator[O], bool, error) {
-	return b.Index.AscendRangeWithFilters(min, max, db.PrimaryIndexFilter(pIdx), db.SecondaryIndexFilter[O](sIdx))
+func (b *MultiFsLevels[O]) FindSingle(ctx context.Context, pIdx, sIdx string, min, max O) (db.EntryIterator[O], bool, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	return b.Index.AscendRangeWithFilters(ctx, min, max, db.PrimaryIndexFilter(pIdx), db.SecondaryIndexFilter[O](sIdx))
+}
+
+// FindMatching returns the entries with values between min and max of the series that pass every
+// filter. Label filters are resolved with the postings when they can narrow down the series, and
+// when the filters of the primary index share a prefix only the primary indexes starting with it
+// are read.
+func (b *MultiFsLevels[O]) FindMatching(ctx context.Context, min, max O, filters ...db.EntryFilter) (db.EntryIterator[O], bool, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if blocks, ok := b.Postings.Fileblocks(filters...); ok {
+		return db.AscendFileblocksWithFilters(ctx, blocks, min, max, filters...)
+	}
+
+	if prefix := db.FilterPrefix(db.PrimaryIndexFilterKind, filters...); prefix != "" {
+		return db.AscendPrefixWithFilters(ctx, b.PrimaryIndex, prefix, min, max, filters...)
+	}
+
+	return b.Index.AscendRangeWithFilters(ctx, min, max, filters...)
+}
+
+// FindSingleDescending is FindSingle reading the fileblocks with the newest values first
+func (b *MultiFsLevels[O]) FindSingleDescending(ctx context.Context, pIdx, sIdx string, min, max O) (db.EntryIterator[O], bool, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	return b.Index.DescendRangeWithFilters(ctx, min, max, db.PrimaryIndexFilter(pIdx), db.SecondaryIndexFilter[O](sIdx))
 }
 
 func (b *MultiFsLevels[O]) Fileblocks() []*db.Fileblock[O] {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
 	var blocks []*db.Fileblock[O]
 
-	b.Index.Ascend(func(i *db.BtreeItem[O, O]) bool {
-		ll := i.Val
-		for next, found := ll.Head(); next != nil && found; next = next.Next {
-			blocks = append(blocks, next.Val)
+	b.Index.Ascend(func(fb *db.Fileblock[O]) bool {
+		blocks = append(blocks, fb)
+		return true
+	})
+
+	return blocks
+}
+
+// AcquireFileblocks returns every fileblock pinned, the caller must release them.
+func (b *MultiFsLevels[O]) AcquireFileblocks() []*db.Fileblock[O] {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	blocks := make([]*db.Fileblock[O], 0)
+	b.Index.Ascend(func(fb *db.Fileblock[O]) bool {
+		if fb.Acquire() {
+			blocks = append(blocks, fb)
 		}
 		return true
 	})
@@ -144,6 +286,42 @@ func (b *MultiFsLevels[O]) Fileblocks() []*db.Fileblock[O] {
 	return blocks
 }
 
+// DescendMax calls fn with the fileblocks of a primary index, or every fileblock if pIdx is empty,
+// the ones with the newest values first, until it returns false. fn must pin the fileblocks it keeps.
+func (b *MultiFsLevels[O]) DescendMax(pIdx string, fn func(*db.Fileblock[O]) bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	b.Index.DescendMax(func(fb *db.Fileblock[O]) bool {
+		return (pIdx != "" && fb.PrimaryIdx != pIdx) || fn(fb)
+	})
+}
+
+// FileblocksByPrimaryIndex returns the fileblocks grouped by primary index, in ascending order of
+// primary index. Fileblocks of each group are sorted by their min value. Every fileblock is pinned,
+// the caller must release them.
+func (b *MultiFsLevels[O]) FileblocksByPrimaryIndex() [][]*db.Fileblock[O] {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	groups := make([][]*db.Fileblock[O], 0, b.PrimaryIndex.Len())
+	b.PrimaryIndex.Ascend(func(i *db.BtreeItem[O, string]) bool {
+		blocks := make([]*db.Fileblock[O], 0)
+		i.Val.Each(func(fb *db.Fileblock[O]) bool {
+			if fb.Acquire() {
+				blocks = append(blocks, fb)
+			}
+			return true
+		})
+		if len(blocks) > 0 {
+			groups = append(groups, blocks)
+		}
+		return true
+	})
+
+	return groups
+}
+
 func (b *MultiFsLevels[O]) Level(i int) *BasicLevel[O] {
 	return b.levels[i]
 }
@@ -13,6 +13,7 @@ import (
 
 func TestMultiLevelFs(t *testing.T) {
 	cfg := db.NewDefaultConfig()
+	cfg.DbPath = t.TempDir()
 	// fs := mockFilesystem[int32, *db.Kv]{}
 
 	levels, err := NewLeveledFilesystem[int64, *db.Kv](cfg, nil)
@@ -0,0 +1,64 @@
+package fslocal
+
+import (
+	"errors"
+	"os"
+	"path"
+)
+
+// tmpExtension is added to the files being written, they are renamed once they are complete
+const tmpExtension = ".tmp"
+
+// writeFileAtomic writes a file to a temporary one and renames it once it's synced, so that the
+// file is never found half written. The folder must be synced afterwards for the rename to survive
+// a crash.
+func writeFileAtomic(p string, write func(*os.File) error) error {
+	tmp := p + tmpExtension
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	if err = write(file); err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, p)
+	}
+	if err != nil {
+		return errors.Join(err, removeIfExists(tmp))
+	}
+
+	return nil
+}
+
+// syncDir persists the entries of a folder, like a file that has been renamed into it
+func syncDir(p string) error {
+	dir, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	return dir.Sync()
+}
+
+func removeIfExists(p string) error {
+	if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+// moveFile moves a file into a folder, creating it if it doesn't exist
+func moveFile(p, folder string) error {
+	if err := os.MkdirAll(folder, 0755); err != nil {
+		return err
+	}
+
+	return os.Rename(p, path.Join(folder, path.Base(p)))
+}
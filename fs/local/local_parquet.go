@@ -24,12 +24,29 @@ func InitParquetLocal[O cmp.Ordered, E db.Entry[O]](cfg *db.Config, level int) (
 		if err != nil {
 			return nil, err
 		}
-		rootPath = path.Join(cwd, cfg.DbPath)
+		rootPath = path.Join(cwd, rootPath)
 	}
 
 	os.MkdirAll(rootPath, 0755)
 
+	if err := removeTemporaryFiles(rootPath); err != nil {
+		return nil, errors.Join(errors.New("error removing temporary files"), err)
+	}
+
 	fs := &localParquetFs[O, E]{cfg: cfg, rootPath: rootPath}
+	// without a manifest every metadata file is read anyway
+	verifyAll := true
+	if !cfg.Manifest.Disabled {
+		rebuilt, err := fs.openManifest()
+		if err != nil {
+			return nil, err
+		}
+		verifyAll = rebuilt
+	}
+
+	if err := fs.scrub(verifyAll); err != nil {
+		return nil, errors.Join(errors.New("error scrubbing level"), err)
+	}
 
 	return fs, nil
 }
@@ -37,26 +54,72 @@ func InitParquetLocal[O cmp.Ordered, E db.Entry[O]](cfg *db.Config, level int) (
 type localParquetFs[O cmp.Ordered, E db.Entry[O]] struct {
 	cfg      *db.Config
 	rootPath string
+
+	// manifest records the fileblocks of the level, nil if it's disabled
+	manifest *db.Manifest[O]
+}
+
+// openManifest reads the manifest of the level. It's rebuilt from the metadata files when it doesn't
+// exist, it's corrupted or a rebuild is forced in the config, and then rebuilt is true.
+func (f *localParquetFs[O, _]) openManifest() (rebuilt bool, err error) {
+	store := newManifestStore(f.rootPath)
+
+	if !f.cfg.Manifest.Rebuild {
+		manifest, found, err := db.OpenManifest[O](store, f.cfg.Manifest.CheckpointEdits)
+		if err == nil && found {
+			f.manifest = manifest
+			return false, nil
+		}
+		if err != nil && !errors.Is(err, db.ErrCorruptManifest) {
+			return false, err
+		}
+		if err != nil {
+			log.WithError(err).WithField("level", f.rootPath).Warn("rebuilding corrupted manifest")
+		}
+	}
+
+	metas, err := f.readMetaFiles()
+	if err != nil {
+		return false, err
+	}
+
+	f.manifest = db.NewManifest[O](store, f.cfg.Manifest.CheckpointEdits)
+	if err = f.manifest.Rebuild(metas); err != nil {
+		return false, errors.Join(errors.New("error rebuilding manifest"), err)
+	}
+
+	return true, nil
+}
+
+// manifestErr ignores a failed checkpoint of the manifest, the edit was recorded and the checkpoint
+// is retried on the next one
+func manifestErr(err error) error {
+	if errors.Is(err, db.ErrManifestCheckpoint) {
+		log.WithError(err).Warn("error checkpointing manifest")
+		return nil
+	}
+
+	return err
 }
 
 func (f *localParquetFs[O, _]) UpdateMetadata(b *db.Fileblock[O]) error {
 	meta := b.Metadata()
 
-	file, err := os.Create(meta.MetaFilepath)
-	if err != nil {
+	if err := writeFileAtomic(meta.MetaFilepath, func(file *os.File) error {
+		return json.NewEncoder(file).Encode(meta)
+	}); err != nil {
 		return err
 	}
-	defer file.Close()
 
-	if err = file.Truncate(0); err != nil {
+	if err := syncDir(f.rootPath); err != nil {
 		return err
 	}
 
-	if err = json.NewEncoder(file).Encode(meta); err != nil {
-		return err
+	if f.manifest != nil {
+		return manifestErr(f.manifest.Update(meta))
 	}
 
-	return file.Sync()
+	return nil
 }
 
 // Load the parquet file using the data stored in the metadata file
@@ -83,6 +146,16 @@ func (f *localParquetFs[O, E]) Load(b *db.Fileblock[O]) (*db.EntriesMap[O], erro
 }
 
 func (f *localParquetFs[O, E]) Create(cfg *db.Config, es *db.EntriesMap[O], builder *db.MetadataBuilder[O], ls []db.FileblockListener[O]) (*db.Fileblock[O], error) {
+	return f.create(es, builder, nil, ls)
+}
+
+// Replace creates a fileblock that takes the place of old in the manifest, see db.FileblockReplacer
+func (f *localParquetFs[O, E]) Replace(cfg *db.Config, old *db.Fileblock[O], es *db.EntriesMap[O], builder *db.MetadataBuilder[O], ls []db.FileblockListener[O]) (*db.Fileblock[O], error) {
+	return f.create(es, builder, old, ls)
+}
+
+// create writes a new fileblock, it's recorded in the manifest in place of old if it's set
+func (f *localParquetFs[O, E]) create(es *db.EntriesMap[O], builder *db.MetadataBuilder[O], old *db.Fileblock[O], ls []db.FileblockListener[O]) (*db.Fileblock[O], error) {
 	if es.SecondaryIndicesLen() == 0 {
 		return nil, errors.New("empty data")
 	}
@@ -93,50 +166,38 @@ func (f *localParquetFs[O, E]) Create(cfg *db.Config, es *db.EntriesMap[O], buil
 		return nil, errors.Join(errors.New("error building metadata"), err)
 	}
 
-	dataFile, err := os.Create(meta.DataFilepath)
-	if err != nil {
-		return nil, errors.Join(errors.New("error creating data file: "), err)
-	}
-	defer dataFile.Close()
-
-	parquetWriter, err := writer.NewParquetWriterFromWriter(dataFile, *new(E), db.PARQUET_NUMBER_OF_THREADS)
-	if err != nil {
-		return nil, errors.Join(errors.New("error creating parquet writer: "), err)
-	}
-
-	sIdx := es.SecondaryIndices()
-	for _, sidx := range sIdx {
-		parquetWriter.Write(es.Get(sidx))
-	}
-
-	if err = parquetWriter.WriteStop(); err != nil {
-		return nil, errors.Join(errors.New("error stopping parquet writer: "), err)
-	}
-
-	if err = parquetWriter.Flush(true); err != nil {
-		return nil, errors.Join(errors.New("error flushing parquet writer: "), err)
-	}
-
-	stat, err := dataFile.Stat()
-	if err != nil && len(parquetWriter.Footer.RowGroups) > 0 {
-		meta.Size = parquetWriter.Footer.RowGroups[0].TotalByteSize
-	} else {
-		meta.Size = stat.Size()
+	// the data file is complete before the metadata file points to it, and both are complete before
+	// the fileblock is recorded in the manifest
+	if err = writeFileAtomic(meta.DataFilepath, func(dataFile *os.File) error {
+		size, err := writeParquet[O, E](dataFile, es)
+		meta.Size = size
+		return err
+	}); err != nil {
+		return nil, errors.Join(errors.New("error writing data file"), err)
 	}
 
-	metaFile, err := os.Create(meta.MetaFilepath)
-	if err != nil {
+	if err = writeFileAtomic(meta.MetaFilepath, func(metaFile *os.File) error {
+		return json.NewEncoder(metaFile).Encode(meta)
+	}); err != nil {
 		log.WithFields(log.Fields{"meta_file": meta.MetaFilepath, "data_file": meta.DataFilepath}).Warn("error happened during creating of fileblock, removing files")
 		os.Remove(meta.DataFilepath)
-		os.Remove(meta.MetaFilepath)
-		return nil, errors.Join(errors.New("error creating meta file: "), err)
+		return nil, errors.Join(errors.New("error writing meta file"), err)
 	}
-	defer metaFile.Close()
-	if err = json.NewEncoder(metaFile).Encode(meta); err != nil {
+
+	if err = syncDir(f.rootPath); err != nil {
 		log.WithFields(log.Fields{"meta_file": meta.MetaFilepath, "data_file": meta.DataFilepath}).Warn("error happened during creating of fileblock, removing files")
 		os.Remove(meta.DataFilepath)
 		os.Remove(meta.MetaFilepath)
-		return nil, err
+		return nil, errors.Join(errors.New("error syncing level folder"), err)
+	}
+
+	if f.manifest != nil {
+		if err = manifestErr(addToManifest(f.manifest, meta, old)); err != nil {
+			log.WithFields(log.Fields{"meta_file": meta.MetaFilepath, "data_file": meta.DataFilepath}).Warn("error happened during creating of fileblock, removing files")
+			os.Remove(meta.DataFilepath)
+			os.Remove(meta.MetaFilepath)
+			return nil, err
+		}
 	}
 
 	block := db.NewFileblock(f.cfg, meta, f)
@@ -147,16 +208,37 @@ func (f *localParquetFs[O, E]) Create(cfg *db.Config, es *db.EntriesMap[O], buil
 	return block, nil
 }
 
+// addToManifest records a new fileblock, in the same edit as the removal of old if it's set
+func addToManifest[O cmp.Ordered](manifest *db.Manifest[O], meta *db.MetaFile[O], old *db.Fileblock[O]) error {
+	if old == nil {
+		return manifest.Add(meta)
+	}
+
+	return manifest.Replace(meta, old.Metadata())
+}
+
 func (f *localParquetFs[O, _]) Remove(fb *db.Fileblock[O], ls []db.FileblockListener[O]) error {
 	m := fb.Metadata()
 
+	// once it's out of the manifest the fileblock isn't opened again, even if its files survive
+	if f.manifest != nil {
+		if err := manifestErr(f.manifest.Remove(m)); err != nil {
+			return err
+		}
+	}
+
+	// the metadata file goes first, a data file left without it by a crash is deleted by the scrub
+	log.Debugf("Removing parquet block's meta in '%s'", m.MetaFilepath)
+	if err := os.Remove(m.MetaFilepath); err != nil {
+		return err
+	}
+
 	log.Debugf("Removing parquet block data in '%s'", m.DataFilepath)
 	if err := os.Remove(m.DataFilepath); err != nil {
 		return err
 	}
 
-	log.Debugf("Removing parquet block's meta in '%s'", m.MetaFilepath)
-	if err := os.Remove(m.MetaFilepath); err != nil {
+	if err := syncDir(f.rootPath); err != nil {
 		return err
 	}
 
@@ -167,14 +249,62 @@ func (f *localParquetFs[O, _]) Remove(fb *db.Fileblock[O], ls []db.FileblockList
 	return nil
 }
 
+// OpenMetaFilesInLevel opens the fileblocks recorded in the manifest, or the ones of every metadata
+// file in the level if it's disabled
 func (f *localParquetFs[O, _]) OpenMetaFilesInLevel(listeners []db.FileblockListener[O]) error {
+	metas, err := f.committed()
+	if err != nil {
+		return err
+	}
+
+	for _, meta := range metas {
+		block := db.NewFileblock(f.cfg, meta, f)
+		for _, listener := range listeners {
+			listener.OnFileblockCreated(block)
+		}
+	}
+
+	return nil
+}
+
+// committed returns the metadata of the fileblocks recorded in the manifest, or of every metadata
+// file in the level if it's disabled
+func (f *localParquetFs[O, _]) committed() ([]*db.MetaFile[O], error) {
+	if f.manifest == nil {
+		return f.readMetaFiles()
+	}
+
+	metas := f.manifest.Fileblocks()
+	for _, meta := range metas {
+		f.inLevel(meta)
+	}
+
+	return metas, nil
+}
+
+// inLevel points the paths of the metadata to the folder of the level. They are absolute, and the
+// level might have been moved since the fileblock was written.
+func (f *localParquetFs[O, _]) inLevel(meta *db.MetaFile[O]) *db.MetaFile[O] {
+	meta.MetaFilepath = path.Join(f.rootPath, path.Base(meta.MetaFilepath))
+	meta.DataFilepath = path.Join(f.rootPath, path.Base(meta.DataFilepath))
+
+	return meta
+}
+
+// readMetaFiles decodes every metadata file in the level. The ones that can't be decoded are skipped,
+// the scrub quarantines them.
+func (f *localParquetFs[O, _]) readMetaFiles() ([]*db.MetaFile[O], error) {
 	folder := f.rootPath
 	files, err := os.ReadDir(folder)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
+	metas := make([]*db.MetaFile[O], 0, len(files))
 	for _, file := range files {
+		if file.IsDir() && file.Name() == quarantineFolder {
+			continue
+		}
 		if file.IsDir() {
 			panic("folder not expected")
 		}
@@ -183,20 +313,22 @@ func (f *localParquetFs[O, _]) OpenMetaFilesInLevel(listeners []db.FileblockList
 			continue
 		}
 
-		if _, err = open(f.cfg, f, path.Join(folder, file.Name()), listeners...); err != nil {
-			return err
+		meta, err := readMetaFile[O](path.Join(folder, file.Name()))
+		if err != nil {
+			log.WithError(err).WithField("meta_file", file.Name()).Warn("skipping undecodable metadata file")
+			continue
 		}
-
+		metas = append(metas, f.inLevel(meta))
 	}
 
-	return nil
+	return metas, nil
 }
 
 func (f *localParquetFs[O, _]) FillMetadataBuilder(meta *db.MetadataBuilder[O]) *db.MetadataBuilder[O] {
 	return meta.WithRootPath(f.rootPath).WithExtension(".parquet")
 }
 
-func open[O cmp.Ordered](cfg *db.Config, f db.Filesystem[O], p string, listeners ...db.FileblockListener[O]) (*db.Fileblock[O], error) {
+func readMetaFile[O cmp.Ordered](p string) (*db.MetaFile[O], error) {
 	file, err := os.Open(p)
 	if err != nil {
 		return nil, err
@@ -204,15 +336,37 @@ func open[O cmp.Ordered](cfg *db.Config, f db.Filesystem[O], p string, listeners
 	defer file.Close()
 
 	meta := &db.MetaFile[O]{MetaFilepath: p}
-
 	if err = json.NewDecoder(file).Decode(&meta); err != nil {
 		return nil, err
 	}
 
-	block := db.NewFileblock(cfg, meta, f)
-	for _, listener := range listeners {
-		listener.OnFileblockCreated(block)
+	return meta, nil
+}
+
+// writeParquet writes the entries to the file and returns its size
+func writeParquet[O cmp.Ordered, E db.Entry[O]](dataFile *os.File, es *db.EntriesMap[O]) (int64, error) {
+	parquetWriter, err := writer.NewParquetWriterFromWriter(dataFile, *new(E), db.PARQUET_NUMBER_OF_THREADS)
+	if err != nil {
+		return 0, errors.Join(errors.New("error creating parquet writer: "), err)
 	}
 
-	return block, nil
+	sIdx := es.SecondaryIndices()
+	for _, sidx := range sIdx {
+		parquetWriter.Write(es.Get(sidx))
+	}
+
+	if err = parquetWriter.WriteStop(); err != nil {
+		return 0, errors.Join(errors.New("error stopping parquet writer: "), err)
+	}
+
+	if err = parquetWriter.Flush(true); err != nil {
+		return 0, errors.Join(errors.New("error flushing parquet writer: "), err)
+	}
+
+	stat, err := dataFile.Stat()
+	if err != nil {
+		return 0, errors.Join(errors.New("error getting size of data file"), err)
+	}
+
+	return stat.Size(), nil
 }
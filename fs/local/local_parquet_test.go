@@ -1,7 +1,9 @@
 package fslocal
 
 import (
+	"fmt"
 	"os"
+	"path"
 	"testing"
 
 	db "github.com/sayden/streedb"
@@ -53,11 +55,8 @@ func TestParquetFiles(t *testing.T) {
 }
 
 func TestParquetLocalFilesystem(t *testing.T) {
-	t.Cleanup(func() {
-		os.RemoveAll("/tmp/db")
-	})
-
 	cfg := db.NewDefaultConfig()
+	cfg.DbPath = t.TempDir()
 	fsp, err := InitParquetLocal[int64, *db.Kv](cfg, 0)
 	require.NoError(t, err)
 	require.NotNil(t, fsp)
@@ -143,12 +142,289 @@ func TestParquetLocalFilesystem(t *testing.T) {
 
 type testFileblockListener struct {
 	created, removed int
+	blocks           []*db.Fileblock[int64]
 }
 
 func (l *testFileblockListener) OnFileblockCreated(fb *db.Fileblock[int64]) {
 	l.created++
+	l.blocks = append(l.blocks, fb)
 }
 
 func (l *testFileblockListener) OnFileblockRemoved(fb *db.Fileblock[int64]) {
 	l.removed++
 }
+
+func TestParquetLocalLabeledEntries(t *testing.T) {
+	cfg := db.NewDefaultConfig()
+	cfg.DbPath = t.TempDir()
+	fsp, err := InitParquetLocal[int64, *db.LabeledEntry](cfg, 0)
+	require.NoError(t, err)
+
+	eu, err := db.NewLabels(map[string]string{"host": "a", "region": "eu"})
+	require.NoError(t, err)
+	us, err := db.NewLabels(map[string]string{"host": "b", "region": "us"})
+	require.NoError(t, err)
+
+	entriesMap := db.NewEntriesMap[int64]()
+	entriesMap.Append(db.NewLabeledEntry("cpu", eu, []int64{1, 2}, []float64{0.5, 0.75}))
+	entriesMap.Append(db.NewLabeledEntry("cpu", us, []int64{3}, []float64{1.5}))
+
+	builder := db.NewMetadataBuilder[int64](cfg)
+	entriesMap.Range(func(_ string, entry db.Entry[int64]) bool {
+		builder.WithEntry(entry)
+		return true
+	})
+
+	fb, err := fsp.Create(cfg, entriesMap, builder, nil)
+	require.NoError(t, err)
+
+	// the labels are in the metadata, so that the fileblock can be pruned without loading it
+	require.Len(t, fb.Rows, 2)
+	for _, row := range fb.Rows {
+		assert.Contains(t, []string{eu.String(), us.String()}, row.SecondaryIdx)
+		assert.Contains(t, []db.Labels{eu, us}, row.Labels)
+	}
+
+	// and so is the bloom filter of the series and their labels
+	meta, err := readMetaFile[int64](fb.MetaFilepath)
+	require.NoError(t, err)
+	opened := db.NewFileblock(cfg, meta, fsp)
+	require.NotNil(t, opened.Bloom)
+	assert.Equal(t, fb.Bloom, opened.Bloom)
+	host, err := db.LabelFilter("host", "c")
+	require.NoError(t, err)
+	assert.False(t, opened.Matches(host))
+
+	entries, err := fsp.Load(fb)
+	require.NoError(t, err)
+	require.Equal(t, 2, entries.SecondaryIndicesLen())
+
+	loaded := entries.Get(eu.String()).(*db.LabeledEntry)
+	assert.Equal(t, "cpu", loaded.PrimaryIndex())
+	assert.Equal(t, eu, loaded.Labels())
+	assert.Equal(t, []int64{1, 2}, loaded.Ts)
+	assert.Equal(t, []float64{0.5, 0.75}, loaded.Val)
+}
+
+func TestParquetLocalManifest(t *testing.T) {
+	cfg := db.NewDefaultConfig()
+	cfg.DbPath = t.TempDir()
+	cfg.Manifest.CheckpointEdits = 2
+
+	create := func(fsp db.Filesystem[int64], sIdx string) *db.Fileblock[int64] {
+		entriesMap := db.NewEntriesMap[int64]()
+		entriesMap.Append(db.NewKv("instance1", sIdx, []int64{1, 2}, []int32{1, 2}))
+		builder := db.NewMetadataBuilder[int64](cfg)
+		entriesMap.Range(func(_ string, entry db.Entry[int64]) bool {
+			builder.WithEntry(entry)
+			return true
+		})
+
+		fb, err := fsp.Create(cfg, entriesMap, builder, nil)
+		require.NoError(t, err)
+		return fb
+	}
+
+	opened := func() int {
+		fsp, err := InitParquetLocal[int64, *db.Kv](cfg, 0)
+		require.NoError(t, err)
+		listener := &testFileblockListener{}
+		require.NoError(t, fsp.OpenMetaFilesInLevel([]db.FileblockListener[int64]{listener}))
+		return listener.created
+	}
+
+	fsp, err := InitParquetLocal[int64, *db.Kv](cfg, 0)
+	require.NoError(t, err)
+	cpu := create(fsp, "cpu")
+	mem := create(fsp, "mem")
+	disk := create(fsp, "disk")
+	require.NoError(t, fsp.Remove(disk, nil))
+	assert.Equal(t, 2, opened())
+
+	// the fileblocks are read from the manifest, not from their metadata files
+	require.NoError(t, os.Remove(mem.MetaFilepath))
+	assert.Equal(t, 2, opened())
+
+	t.Run("Torn record", func(t *testing.T) {
+		manifest := path.Join(path.Dir(cpu.MetaFilepath), manifestFilename)
+		stat, err := os.Stat(manifest)
+		require.NoError(t, err)
+
+		file, err := os.OpenFile(manifest, os.O_WRONLY|os.O_APPEND, 0644)
+		require.NoError(t, err)
+		_, err = file.Write([]byte{0xff, 0, 0, 0, 1, 2})
+		require.NoError(t, err)
+		require.NoError(t, file.Close())
+
+		fsp, err := InitParquetLocal[int64, *db.Kv](cfg, 0)
+		require.NoError(t, err)
+		after, err := os.Stat(manifest)
+		require.NoError(t, err)
+		assert.Equal(t, stat.Size(), after.Size())
+
+		create(fsp, "net")
+		assert.Equal(t, 3, opened())
+	})
+
+	t.Run("Rebuild", func(t *testing.T) {
+		cfg.Manifest.Rebuild = true
+		defer func() { cfg.Manifest.Rebuild = false }()
+
+		// mem lost its metadata file
+		assert.Equal(t, 2, opened())
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		cfg.Manifest.Disabled = true
+		defer func() { cfg.Manifest.Disabled = false }()
+
+		assert.Equal(t, 2, opened())
+	})
+
+	t.Run("Replace", func(t *testing.T) {
+		fsp, err := InitParquetLocal[int64, *db.Kv](cfg, 0)
+		require.NoError(t, err)
+
+		entriesMap := db.NewEntriesMap[int64]()
+		entriesMap.Append(db.NewKv("instance1", "cpu", []int64{2}, []int32{2}))
+		builder := db.NewMetadataBuilder[int64](cfg)
+		entriesMap.Range(func(_ string, entry db.Entry[int64]) bool {
+			builder.WithEntry(entry)
+			return true
+		})
+		_, err = fsp.(db.FileblockReplacer[int64]).Replace(cfg, cpu, entriesMap, builder, nil)
+		require.NoError(t, err)
+
+		// a crash before the files of cpu are deleted doesn't open it again
+		_, err = os.Stat(cpu.MetaFilepath)
+		require.NoError(t, err)
+		assert.Equal(t, 2, opened())
+	})
+}
+
+func TestParquetLocalScrub(t *testing.T) {
+	for _, disabled := range []bool{false, true} {
+		t.Run(fmt.Sprintf("Manifest disabled %t", disabled), func(t *testing.T) {
+			cfg := db.NewDefaultConfig()
+			cfg.DbPath = t.TempDir()
+			cfg.Manifest.Disabled = disabled
+
+			fsp, err := InitParquetLocal[int64, *db.Kv](cfg, 0)
+			require.NoError(t, err)
+
+			blocks := make([]*db.Fileblock[int64], 0)
+			for _, sIdx := range []string{"cpu", "mem", "disk"} {
+				entriesMap := db.NewEntriesMap[int64]()
+				entriesMap.Append(db.NewKv("instance1", sIdx, []int64{1, 2}, []int32{1, 2}))
+				builder := db.NewMetadataBuilder[int64](cfg)
+				entriesMap.Range(func(_ string, entry db.Entry[int64]) bool {
+					builder.WithEntry(entry)
+					return true
+				})
+
+				fb, err := fsp.Create(cfg, entriesMap, builder, nil)
+				require.NoError(t, err)
+				blocks = append(blocks, fb)
+			}
+			root := path.Dir(blocks[0].MetaFilepath)
+
+			files, err := os.ReadDir(root)
+			require.NoError(t, err)
+			for _, file := range files {
+				assert.NotContains(t, file.Name(), tmpExtension)
+			}
+
+			// a crash left a temporary file, a data file without metadata, and the data file of mem
+			// truncated
+			require.NoError(t, os.WriteFile(path.Join(root, "interrupted.parquet"+tmpExtension), []byte("PAR1"), 0644))
+			require.NoError(t, os.WriteFile(path.Join(root, "orphan.parquet"), []byte("PAR1"), 0644))
+			require.NoError(t, os.WriteFile(path.Join(root, "meta_bad.json"), []byte("{"), 0644))
+			require.NoError(t, os.Truncate(blocks[1].DataFilepath, blocks[1].Size-1))
+
+			// the metadata of disk was removed but its data file wasn't
+			data, err := os.ReadFile(blocks[2].DataFilepath)
+			require.NoError(t, err)
+			require.NoError(t, fsp.Remove(blocks[2], nil))
+			require.NoError(t, os.WriteFile(blocks[2].DataFilepath, data, 0644))
+
+			fsp, err = InitParquetLocal[int64, *db.Kv](cfg, 0)
+			require.NoError(t, err)
+			listener := &testFileblockListener{}
+			require.NoError(t, fsp.OpenMetaFilesInLevel([]db.FileblockListener[int64]{listener}))
+			assert.Equal(t, 1, listener.created)
+
+			assert.FileExists(t, blocks[0].DataFilepath)
+			assert.FileExists(t, blocks[0].MetaFilepath)
+			assert.NoFileExists(t, path.Join(root, "interrupted.parquet"+tmpExtension))
+			assert.NoFileExists(t, path.Join(root, "orphan.parquet"))
+			assert.NoFileExists(t, blocks[2].DataFilepath)
+
+			quarantine := path.Join(root, quarantineFolder)
+			assert.FileExists(t, path.Join(quarantine, "meta_bad.json"))
+			assert.FileExists(t, path.Join(quarantine, path.Base(blocks[1].DataFilepath)))
+			assert.FileExists(t, path.Join(quarantine, path.Base(blocks[1].MetaFilepath)))
+			assert.NoFileExists(t, blocks[1].DataFilepath)
+
+			// the quarantine folder is left alone
+			_, err = InitParquetLocal[int64, *db.Kv](cfg, 0)
+			require.NoError(t, err)
+			assert.FileExists(t, path.Join(quarantine, "meta_bad.json"))
+		})
+	}
+}
+
+func TestParquetLocalScrubMovedLevel(t *testing.T) {
+	cfg := db.NewDefaultConfig()
+	cfg.DbPath = t.TempDir()
+	cfg.Manifest.CheckpointEdits = 2
+
+	fsp, err := InitParquetLocal[int64, *db.Kv](cfg, 0)
+	require.NoError(t, err)
+
+	blocks := make([]*db.Fileblock[int64], 0)
+	for _, sIdx := range []string{"cpu", "mem", "disk"} {
+		entriesMap := db.NewEntriesMap[int64]()
+		entriesMap.Append(db.NewKv("instance1", sIdx, []int64{1, 2}, []int32{1, 2}))
+		builder := db.NewMetadataBuilder[int64](cfg)
+		entriesMap.Range(func(_ string, entry db.Entry[int64]) bool {
+			builder.WithEntry(entry)
+			return true
+		})
+
+		fb, err := fsp.Create(cfg, entriesMap, builder, nil)
+		require.NoError(t, err)
+		blocks = append(blocks, fb)
+	}
+
+	// the manifest has the paths of the old folder
+	moved := path.Join(t.TempDir(), "db")
+	require.NoError(t, os.Rename(cfg.DbPath, moved))
+	cfg.DbPath = moved
+	root := path.Join(moved, "00")
+
+	// cpu and mem are in the checkpoint, so their data files are only checked to exist
+	require.NoError(t, os.Truncate(path.Join(root, path.Base(blocks[0].DataFilepath)), blocks[0].Size-1))
+	require.NoError(t, os.Remove(path.Join(root, path.Base(blocks[1].DataFilepath))))
+
+	fsp, err = InitParquetLocal[int64, *db.Kv](cfg, 0)
+	require.NoError(t, err)
+	listener := &testFileblockListener{}
+	require.NoError(t, fsp.OpenMetaFilesInLevel([]db.FileblockListener[int64]{listener}))
+	assert.Equal(t, 2, listener.created)
+
+	// the missing fileblock is quarantined, the others are kept and opened from the new folder
+	quarantine := path.Join(root, quarantineFolder)
+	assert.FileExists(t, path.Join(quarantine, path.Base(blocks[1].MetaFilepath)))
+	for _, fb := range listener.blocks {
+		assert.Contains(t, []string{blocks[0].Uuid, blocks[2].Uuid}, fb.Uuid)
+		assert.Equal(t, root, path.Dir(fb.DataFilepath))
+		assert.FileExists(t, fb.DataFilepath)
+		assert.FileExists(t, fb.MetaFilepath)
+
+		if fb.Uuid == blocks[2].Uuid {
+			es, err := fsp.Load(fb)
+			require.NoError(t, err)
+			assert.Equal(t, 1, es.SecondaryIndicesLen())
+		}
+	}
+}
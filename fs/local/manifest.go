@@ -0,0 +1,126 @@
+package fslocal
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path"
+
+	db "github.com/sayden/streedb"
+	"github.com/thehivecorporation/log"
+)
+
+const (
+	manifestFilename = "MANIFEST"
+
+	// every record is framed as [length uint32][crc32 uint32][body], like the records of the wal
+	manifestRecordHeaderSize = 8
+)
+
+// manifestStore keeps the manifest of a level in a single file: the checkpoint is the first record
+// and the edits are appended after it. Checkpoints are written to a new file that replaces the
+// previous one, so the manifest is never left half written.
+type manifestStore struct {
+	path string
+}
+
+func newManifestStore(dir string) *manifestStore {
+	return &manifestStore{path: path.Join(dir, manifestFilename)}
+}
+
+func (s *manifestStore) Read() ([]byte, [][]byte, bool, error) {
+	byt, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil, false, nil
+	}
+	if err != nil {
+		return nil, nil, false, errors.Join(errors.New("error reading manifest"), err)
+	}
+
+	records := make([][]byte, 0)
+	offset := 0
+	for offset < len(byt) {
+		body, ok := readManifestRecord(byt[offset:])
+		if !ok {
+			break
+		}
+		records = append(records, body)
+		offset += manifestRecordHeaderSize + len(body)
+	}
+
+	if len(records) == 0 {
+		return nil, nil, false, fmt.Errorf("%w: '%s' has no checkpoint", db.ErrCorruptManifest, s.path)
+	}
+
+	// a record torn by a crash was never acknowledged, it's dropped so that new ones follow the
+	// last complete record
+	if offset < len(byt) {
+		log.WithField("manifest", s.path).Warn("truncated record found in manifest")
+		if err = os.Truncate(s.path, int64(offset)); err != nil {
+			return nil, nil, false, errors.Join(errors.New("error truncating manifest"), err)
+		}
+	}
+
+	return records[0], records[1:], true, nil
+}
+
+// readManifestRecord returns the body of the record at the start of byt, false if it's incomplete
+// or corrupted
+func readManifestRecord(byt []byte) ([]byte, bool) {
+	if len(byt) < manifestRecordHeaderSize {
+		return nil, false
+	}
+
+	size := int(binary.LittleEndian.Uint32(byt[0:4]))
+	if len(byt)-manifestRecordHeaderSize < size {
+		return nil, false
+	}
+
+	body := byt[manifestRecordHeaderSize : manifestRecordHeaderSize+size]
+	if crc32.ChecksumIEEE(body) != binary.LittleEndian.Uint32(byt[4:8]) {
+		return nil, false
+	}
+
+	return body, true
+}
+
+func manifestRecord(body []byte) []byte {
+	frame := make([]byte, manifestRecordHeaderSize, manifestRecordHeaderSize+len(body))
+	binary.LittleEndian.PutUint32(frame[0:4], uint32(len(body)))
+	binary.LittleEndian.PutUint32(frame[4:8], crc32.ChecksumIEEE(body))
+
+	return append(frame, body...)
+}
+
+func (s *manifestStore) Append(_ uint64, record []byte) error {
+	file, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	if _, err = file.Write(manifestRecord(record)); err != nil {
+		// the records appended later must follow the last complete one
+		return errors.Join(err, file.Truncate(stat.Size()))
+	}
+
+	return file.Sync()
+}
+
+func (s *manifestStore) Checkpoint(_ uint64, checkpoint []byte) error {
+	if err := writeFileAtomic(s.path, func(file *os.File) error {
+		_, err := file.Write(manifestRecord(checkpoint))
+		return err
+	}); err != nil {
+		return err
+	}
+
+	return syncDir(path.Dir(s.path))
+}
@@ -0,0 +1,150 @@
+package fslocal
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	db "github.com/sayden/streedb"
+	"github.com/thehivecorporation/log"
+)
+
+// quarantineFolder holds the files of the fileblocks that the scrub found incomplete
+const quarantineFolder = "quarantine"
+
+// parquet files end with the length of their footer and this magic number, they are written last
+var parquetMagic = []byte("PAR1")
+
+// removeTemporaryFiles deletes the files of the writes interrupted by a crash
+func removeTemporaryFiles(rootPath string) error {
+	files, err := os.ReadDir(rootPath)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), tmpExtension) {
+			continue
+		}
+
+		log.WithField("file", file.Name()).Warn("removing temporary file of an interrupted write")
+		if err = os.Remove(path.Join(rootPath, file.Name())); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// scrub repairs the level after a crash, before its fileblocks are opened. A fileblock is committed
+// once it's recorded in the manifest, or once its metadata file exists if the manifest is disabled.
+// Committed fileblocks with a missing or incomplete data file are moved to the quarantine folder,
+// never deleted, while the files of fileblocks that were never committed, or were being removed,
+// are deleted. Metadata files that can't be decoded are quarantined too.
+//
+// Only the data files of the fileblocks added since the last checkpoint of the manifest, the ones a
+// crash could have left incomplete, are read unless verifyAll is set. The rest are only checked to
+// exist.
+func (f *localParquetFs[O, _]) scrub(verifyAll bool) error {
+	committed, err := f.committed()
+	if err != nil {
+		return err
+	}
+
+	verify := make(map[string]bool)
+	if !verifyAll {
+		for _, meta := range f.manifest.AddedSinceCheckpoint() {
+			verify[meta.Uuid] = true
+		}
+	}
+
+	files, err := os.ReadDir(f.rootPath)
+	if err != nil {
+		return err
+	}
+	found := make(map[string]bool, len(files))
+	for _, file := range files {
+		found[file.Name()] = !file.IsDir()
+	}
+
+	quarantine := path.Join(f.rootPath, quarantineFolder)
+	referenced := make(map[string]bool)
+	for _, meta := range committed {
+		// a fileblock is matched to its files by name, they are in the folder of the level
+		dataFile, metaFile := path.Base(meta.DataFilepath), path.Base(meta.MetaFilepath)
+		referenced[dataFile] = true
+		referenced[metaFile] = true
+
+		// the manifest holds the metadata, the metadata file is only read to rebuild it
+		complete := found[dataFile]
+		if complete && (verifyAll || verify[meta.Uuid]) {
+			complete = dataFileComplete(meta)
+		}
+		if complete {
+			continue
+		}
+
+		log.WithFields(log.Fields{"meta_file": meta.MetaFilepath, "data_file": meta.DataFilepath}).Warn("quarantining incomplete fileblock")
+		for _, p := range []string{meta.MetaFilepath, meta.DataFilepath} {
+			if err := moveFile(p, quarantine); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return errors.Join(errors.New("error quarantining fileblock"), err)
+			}
+		}
+		if f.manifest != nil {
+			if err := manifestErr(f.manifest.Remove(meta)); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, file := range files {
+		ext := path.Ext(file.Name())
+		if file.IsDir() || referenced[file.Name()] || (ext != ".json" && ext != ".parquet") {
+			continue
+		}
+
+		p := path.Join(f.rootPath, file.Name())
+		if ext == ".json" {
+			if _, err = readMetaFile[O](p); err != nil {
+				log.WithError(err).WithField("meta_file", p).Warn("quarantining undecodable metadata file")
+				if err = moveFile(p, quarantine); err != nil {
+					return errors.Join(errors.New("error quarantining metadata file"), err)
+				}
+				continue
+			}
+		}
+
+		log.WithField("file", p).Warn("removing file of an uncommitted fileblock")
+		if err = os.Remove(p); err != nil {
+			return err
+		}
+	}
+
+	return syncDir(f.rootPath)
+}
+
+// dataFileComplete tells if the data file has the size recorded in the metadata, and ends with the
+// magic number of parquet
+func dataFileComplete[O cmp.Ordered](meta *db.MetaFile[O]) bool {
+	file, err := os.Open(meta.DataFilepath)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil || stat.Size() != meta.Size || stat.Size() < int64(len(parquetMagic)) {
+		return false
+	}
+
+	magic := make([]byte, len(parquetMagic))
+	if _, err = file.ReadAt(magic, stat.Size()-int64(len(parquetMagic))); err != nil && err != io.EOF {
+		return false
+	}
+
+	return bytes.Equal(magic, parquetMagic)
+}
@@ -27,11 +27,6 @@ func (m *memoryFs[O]) Create(cfg *db.Config, es *db.EntriesMap[O], builder *db.M
 	if err != nil {
 		return nil, errors.Join(errors.New("error building metadata"), err)
 	}
-	if meta.Level == m.cfg.MaxLevels {
-		// just delete the entries
-		return nil, nil
-	}
-
 	m.data.Store(meta.Uuid, es)
 	block := db.NewFileblock(m.cfg, meta, m)
 
@@ -46,13 +41,15 @@ func (m *memoryFs[O]) FillMetadataBuilder(meta *db.MetadataBuilder[O]) *db.Metad
 	return meta.WithExtension(".memory")
 }
 
+// Load returns a copy of the stored entries, like a filesystem reading from disk would do, so that
+// callers can't modify a fileblock that other readers are using
 func (m *memoryFs[O]) Load(fb *db.Fileblock[O]) (*db.EntriesMap[O], error) {
 	val, found := m.data.Load(fb.Metadata().Uuid)
 	if !found {
 		return nil, errors.New("fileblock not found")
 	}
 
-	return val, nil
+	return val.Clone(), nil
 }
 
 func (m *memoryFs[O]) OpenMetaFilesInLevel([]db.FileblockListener[O]) error {
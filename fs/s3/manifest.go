@@ -0,0 +1,205 @@
+package fss3
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/aws-sdk-go/aws"
+	db "github.com/sayden/streedb"
+	"github.com/thehivecorporation/log"
+)
+
+const (
+	manifestKey = "MANIFEST"
+	// records are kept under this prefix, in a folder for every checkpoint
+	manifestRecordsPrefix = "MANIFEST-records"
+)
+
+// manifestStore keeps the checkpoint of the manifest of a level in an object, and every record
+// appended after it in an object of its own named by its version. Objects can't be appended to, but
+// a put is atomic, so records are never half written and appending one is a single request.
+//
+// Every checkpoint gets a new generation, and the records appended after it go in the folder of
+// that generation. The checkpoint switches to the new folder in a single put, so the records of
+// the previous checkpoint, deleted after it, are never read again even if a crash leaves them.
+type manifestStore struct {
+	client   *s3.Client
+	bucket   string
+	rootPath string
+
+	// generation of the checkpoint in S3. Calls are serialized by the Manifest.
+	generation string
+}
+
+type manifestObject struct {
+	Generation string
+	Checkpoint json.RawMessage
+}
+
+func newManifestStore(client *s3.Client, bucket, rootPath string) *manifestStore {
+	return &manifestStore{client: client, bucket: bucket, rootPath: rootPath}
+}
+
+// Read gets the checkpoint, and the records of its generation with a list and a get for each
+func (s *manifestStore) Read() ([]byte, [][]byte, bool, error) {
+	byt, found, err := s.get(path.Join(s.rootPath, manifestKey))
+	if err != nil {
+		return nil, nil, false, errors.Join(errors.New("error getting manifest from S3"), err)
+	}
+	if !found {
+		return nil, nil, false, nil
+	}
+
+	var object manifestObject
+	if err = json.Unmarshal(byt, &object); err != nil {
+		return nil, nil, false, errors.Join(db.ErrCorruptManifest, err)
+	}
+	if len(object.Checkpoint) == 0 || object.Generation == "" {
+		return nil, nil, false, fmt.Errorf("%w: '%s' has no checkpoint", db.ErrCorruptManifest, path.Join(s.rootPath, manifestKey))
+	}
+
+	keys, err := s.list(s.recordsPrefix(object.Generation))
+	if err != nil {
+		return nil, nil, false, errors.Join(errors.New("error listing manifest records in S3"), err)
+	}
+	// versions are zero padded, so keys sort like them
+	slices.Sort(keys)
+
+	records := make([][]byte, 0, len(keys))
+	for _, key := range keys {
+		record, found, err := s.get(key)
+		if err != nil {
+			return nil, nil, false, errors.Join(fmt.Errorf("error getting manifest record '%s' from S3", key), err)
+		}
+		if found {
+			records = append(records, record)
+		}
+	}
+	s.generation = object.Generation
+
+	return object.Checkpoint, records, true, nil
+}
+
+func (s *manifestStore) Append(version uint64, record []byte) error {
+	if s.generation == "" {
+		return errors.New("manifest records can't be appended before a checkpoint")
+	}
+
+	if err := s.put(s.recordKey(s.generation, version), record); err != nil {
+		return errors.Join(errors.New("error putting manifest record to S3"), err)
+	}
+
+	return nil
+}
+
+// Checkpoint puts the checkpoint with a new generation, and deletes the records of the previous ones
+func (s *manifestStore) Checkpoint(_ uint64, checkpoint []byte) error {
+	object := manifestObject{Generation: db.NewUUID(), Checkpoint: checkpoint}
+	byt, err := json.Marshal(object)
+	if err != nil {
+		return err
+	}
+	if err = s.put(path.Join(s.rootPath, manifestKey), byt); err != nil {
+		return errors.Join(errors.New("error putting manifest to S3"), err)
+	}
+	s.generation = object.Generation
+
+	// stale records are never read, they are only left behind if deleting them fails
+	if err = s.deleteStaleRecords(); err != nil {
+		log.WithError(err).WithField("level", s.rootPath).Warn("error deleting stale manifest records")
+	}
+
+	return nil
+}
+
+func (s *manifestStore) deleteStaleRecords() error {
+	keys, err := s.list(path.Join(s.rootPath, manifestRecordsPrefix) + "/")
+	if err != nil {
+		return err
+	}
+
+	current := s.recordsPrefix(s.generation)
+	for _, key := range keys {
+		if strings.HasPrefix(key, current) {
+			continue
+		}
+		if _, err = s.client.DeleteObject(context.TODO(), &s3.DeleteObjectInput{
+			Bucket: aws.String(s.bucket),
+			Key:    aws.String(key),
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *manifestStore) recordsPrefix(generation string) string {
+	return path.Join(s.rootPath, manifestRecordsPrefix, generation) + "/"
+}
+
+func (s *manifestStore) recordKey(generation string, version uint64) string {
+	return fmt.Sprintf("%s%020d", s.recordsPrefix(generation), version)
+}
+
+// get returns the content of an object, found is false if it doesn't exist
+func (s *manifestStore) get(key string) ([]byte, bool, error) {
+	out, err := s.client.GetObject(context.TODO(), &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	var noSuchKey *types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	defer out.Body.Close()
+
+	byt, err := io.ReadAll(out.Body)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return byt, true, nil
+}
+
+func (s *manifestStore) list(prefix string) ([]string, error) {
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(prefix),
+	})
+
+	keys := make([]string, 0)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range page.Contents {
+			keys = append(keys, *object.Key)
+		}
+	}
+
+	return keys, nil
+}
+
+func (s *manifestStore) put(key string, byt []byte) error {
+	_, err := s.client.PutObject(context.TODO(), &s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(byt),
+	})
+
+	return err
+}
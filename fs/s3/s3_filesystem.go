@@ -32,12 +32,12 @@ func initS3[O cmp.Ordered, E db.Entry[O]](cfg *db.Config, level int) (db.Filesys
 	})
 
 	rootPath := fmt.Sprintf("%02d", level)
-	s3fs := s3ParquetFs[O, E]{cfg, &s3Cfg, client, rootPath}
+	s3fs := s3ParquetFs[O, E]{cfg: cfg, s3cfg: &s3Cfg, client: client, rootPath: rootPath}
 
 	return &s3fs, nil
 }
 
-func openS3[O cmp.Ordered](client *s3.Client, cfg *db.Config, p string, f db.Filesystem[O], listeners []db.FileblockListener[O]) (*db.Fileblock[O], error) {
+func readS3MetaFile[O cmp.Ordered](client *s3.Client, cfg *db.Config, p string) (*db.MetaFile[O], error) {
 	out, err := client.GetObject(context.TODO(), &s3.GetObjectInput{
 		Bucket: aws.String(cfg.S3Config.Bucket),
 		Key:    aws.String(p),
@@ -51,16 +51,12 @@ func openS3[O cmp.Ordered](client *s3.Client, cfg *db.Config, p string, f db.Fil
 	if err = json.NewDecoder(out.Body).Decode(&meta); err != nil {
 		return nil, errors.Join(errors.New("open error decoding metadata"), err)
 	}
-	block := db.NewFileblock(cfg, meta, f)
 
-	for _, listener := range listeners {
-		listener.OnFileblockCreated(block)
-	}
-
-	return db.NewFileblock(cfg, meta, f), nil
+	return meta, nil
 }
 
-func openAllMetadataFilesInS3Folder[O cmp.Ordered](cfg *db.Config, client *s3.Client, filesystem db.Filesystem[O], rootPath string, listeners ...db.FileblockListener[O]) error {
+// readAllMetadataFilesInS3Folder gets and decodes every metadata file of a level, one request each
+func readAllMetadataFilesInS3Folder[O cmp.Ordered](cfg *db.Config, client *s3.Client, rootPath string) ([]*db.MetaFile[O], error) {
 	listInput := &s3.ListObjectsV2Input{
 		Bucket: aws.String(cfg.S3Config.Bucket),
 		Prefix: aws.String(rootPath + "/meta_"),
@@ -68,6 +64,7 @@ func openAllMetadataFilesInS3Folder[O cmp.Ordered](cfg *db.Config, client *s3.Cl
 
 	paginator := s3.NewListObjectsV2Paginator(client, listInput)
 
+	metas := make([]*db.MetaFile[O], 0)
 	for paginator.HasMorePages() {
 		page, err := paginator.NextPage(context.TODO())
 		if err != nil {
@@ -79,11 +76,13 @@ func openAllMetadataFilesInS3Folder[O cmp.Ordered](cfg *db.Config, client *s3.Cl
 		}
 
 		for _, object := range page.Contents {
-			if _, err = openS3(client, cfg, *object.Key, filesystem, listeners); err != nil {
-				return err
+			meta, err := readS3MetaFile[O](client, cfg, *object.Key)
+			if err != nil {
+				return nil, err
 			}
+			metas = append(metas, meta)
 		}
 	}
 
-	return nil
+	return metas, nil
 }
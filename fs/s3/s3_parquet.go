@@ -40,7 +40,12 @@ func InitParquetS3[O cmp.Ordered, E db.Entry[O]](cfg *db.Config, level int) (db.
 	})
 
 	rootPath := fmt.Sprintf("%02d", level)
-	s3fs := s3ParquetFs[O, E]{cfg, &s3Cfg, client, rootPath}
+	s3fs := s3ParquetFs[O, E]{cfg: cfg, s3cfg: &s3Cfg, client: client, rootPath: rootPath}
+	if !cfg.Manifest.Disabled {
+		if err = s3fs.openManifest(); err != nil {
+			return nil, err
+		}
+	}
 
 	return &s3fs, nil
 }
@@ -50,6 +55,52 @@ type s3ParquetFs[O cmp.Ordered, E db.Entry[O]] struct {
 	s3cfg    s3config.Config
 	client   *s3.Client
 	rootPath string
+
+	// manifest records the fileblocks of the level, nil if it's disabled
+	manifest *db.Manifest[O]
+}
+
+// openManifest reads the manifest of the level. It's rebuilt from the metadata files when it doesn't
+// exist, it's corrupted or a rebuild is forced in the config.
+func (f *s3ParquetFs[O, _]) openManifest() error {
+	store := newManifestStore(f.client, f.cfg.S3Config.Bucket, f.rootPath)
+
+	if !f.cfg.Manifest.Rebuild {
+		manifest, found, err := db.OpenManifest[O](store, f.cfg.Manifest.CheckpointEdits)
+		if err == nil && found {
+			f.manifest = manifest
+			return nil
+		}
+		if err != nil && !errors.Is(err, db.ErrCorruptManifest) {
+			return err
+		}
+		if err != nil {
+			log.WithError(err).WithField("level", f.rootPath).Warn("rebuilding corrupted manifest")
+		}
+	}
+
+	metas, err := readAllMetadataFilesInS3Folder[O](f.cfg, f.client, f.rootPath)
+	if err != nil {
+		return err
+	}
+
+	f.manifest = db.NewManifest[O](store, f.cfg.Manifest.CheckpointEdits)
+	if err = f.manifest.Rebuild(metas); err != nil {
+		return errors.Join(errors.New("error rebuilding manifest"), err)
+	}
+
+	return nil
+}
+
+// manifestErr ignores a failed checkpoint of the manifest, the edit was recorded and the checkpoint
+// is retried on the next one
+func manifestErr(err error) error {
+	if errors.Is(err, db.ErrManifestCheckpoint) {
+		log.WithError(err).Warn("error checkpointing manifest")
+		return nil
+	}
+
+	return err
 }
 
 func (f *s3ParquetFs[O, E]) Load(b *db.Fileblock[O]) (*db.EntriesMap[O], error) {
@@ -119,10 +170,24 @@ func (f *s3ParquetFs[O, _]) UpdateMetadata(b *db.Fileblock[O]) error {
 		return errors.Join(errors.New("error updating obj to S3"), err)
 	}
 
+	if f.manifest != nil {
+		return manifestErr(f.manifest.Update(b.Metadata()))
+	}
+
 	return nil
 }
 
 func (f *s3ParquetFs[O, E]) Create(cfg *db.Config, es *db.EntriesMap[O], builder *db.MetadataBuilder[O], ls []db.FileblockListener[O]) (*db.Fileblock[O], error) {
+	return f.create(cfg, es, builder, nil, ls)
+}
+
+// Replace creates a fileblock that takes the place of old in the manifest, see db.FileblockReplacer
+func (f *s3ParquetFs[O, E]) Replace(cfg *db.Config, old *db.Fileblock[O], es *db.EntriesMap[O], builder *db.MetadataBuilder[O], ls []db.FileblockListener[O]) (*db.Fileblock[O], error) {
+	return f.create(cfg, es, builder, old, ls)
+}
+
+// create writes a new fileblock, it's recorded in the manifest in place of old if it's set
+func (f *s3ParquetFs[O, E]) create(cfg *db.Config, es *db.EntriesMap[O], builder *db.MetadataBuilder[O], old *db.Fileblock[O], ls []db.FileblockListener[O]) (*db.Fileblock[O], error) {
 	if es.SecondaryIndicesLen() == 0 {
 		return nil, errors.New("empty data")
 	}
@@ -189,6 +254,19 @@ func (f *s3ParquetFs[O, E]) Create(cfg *db.Config, es *db.EntriesMap[O], builder
 		})
 		return nil, errors.Join(errors.New("error putting obj to S3"), err)
 	}
+
+	if f.manifest != nil {
+		if err = manifestErr(addToManifest(f.manifest, meta, old)); err != nil {
+			for _, key := range []string{meta.DataFilepath, meta.MetaFilepath} {
+				f.client.DeleteObject(context.TODO(), &s3.DeleteObjectInput{
+					Bucket: aws.String(f.cfg.S3Config.Bucket),
+					Key:    aws.String(key),
+				})
+			}
+			return nil, err
+		}
+	}
+
 	block := db.NewFileblock(cfg, meta, f)
 	for _, l := range ls {
 		l.OnFileblockCreated(block)
@@ -197,8 +275,25 @@ func (f *s3ParquetFs[O, E]) Create(cfg *db.Config, es *db.EntriesMap[O], builder
 	return block, nil
 }
 
+// addToManifest records a new fileblock, in the same edit as the removal of old if it's set
+func addToManifest[O cmp.Ordered](manifest *db.Manifest[O], meta *db.MetaFile[O], old *db.Fileblock[O]) error {
+	if old == nil {
+		return manifest.Add(meta)
+	}
+
+	return manifest.Replace(meta, old.Metadata())
+}
+
 func (f *s3ParquetFs[O, _]) Remove(fb *db.Fileblock[O], listeners []db.FileblockListener[O]) error {
 	m := fb.Metadata()
+
+	// once it's out of the manifest the fileblock isn't opened again, even if its objects survive
+	if f.manifest != nil {
+		if err := manifestErr(f.manifest.Remove(m)); err != nil {
+			return err
+		}
+	}
+
 	log.Debugf("Removing parquet block data in '%s'", m.DataFilepath)
 
 	_, err := f.client.DeleteObject(context.TODO(), &s3.DeleteObjectInput{
@@ -225,8 +320,27 @@ func (f *s3ParquetFs[O, _]) Remove(fb *db.Fileblock[O], listeners []db.Fileblock
 	return nil
 }
 
+// OpenMetaFilesInLevel opens the fileblocks recorded in the manifest, or the ones of every metadata
+// file in the level if it's disabled
 func (f *s3ParquetFs[O, _]) OpenMetaFilesInLevel(listeners []db.FileblockListener[O]) error {
-	return openAllMetadataFilesInS3Folder(f.cfg, f.client, f, f.rootPath, listeners...)
+	var metas []*db.MetaFile[O]
+	if f.manifest != nil {
+		metas = f.manifest.Fileblocks()
+	} else {
+		var err error
+		if metas, err = readAllMetadataFilesInS3Folder[O](f.cfg, f.client, f.rootPath); err != nil {
+			return err
+		}
+	}
+
+	for _, meta := range metas {
+		block := db.NewFileblock(f.cfg, meta, f)
+		for _, listener := range listeners {
+			listener.OnFileblockCreated(block)
+		}
+	}
+
+	return nil
 }
 
 func (f *s3ParquetFs[O, E]) FillMetadataBuilder(meta *db.MetadataBuilder[O]) *db.MetadataBuilder[O] {
@@ -0,0 +1,298 @@
+package streedb
+
+import (
+	"cmp"
+	"container/heap"
+	"context"
+	"math/rand/v2"
+)
+
+// IntervalIndex holds fileblocks by the interval of their values, [Min, Max], so that a range
+// query finds every fileblock that overlaps it, including the ones that start before the range. It's
+// a treap ordered by Min where every node knows the greatest Max of its subtree. Fileblocks with the
+// same Min are kept in insertion order. It isn't safe for concurrent use, like BtreeIndex.
+type IntervalIndex[O cmp.Ordered] struct {
+	root *intervalNode[O]
+	len  int
+}
+
+type intervalNode[O cmp.Ordered] struct {
+	fb       *Fileblock[O]
+	priority uint64
+	// maxEnd is the greatest Max of the subtree
+	maxEnd      O
+	left, right *intervalNode[O]
+}
+
+func NewIntervalIndex[O cmp.Ordered]() *IntervalIndex[O] {
+	return &IntervalIndex[O]{}
+}
+
+func (n *intervalNode[O]) update() {
+	n.maxEnd = *n.fb.Max
+	if n.left != nil && n.left.maxEnd > n.maxEnd {
+		n.maxEnd = n.left.maxEnd
+	}
+	if n.right != nil && n.right.maxEnd > n.maxEnd {
+		n.maxEnd = n.right.maxEnd
+	}
+}
+
+func (i *IntervalIndex[O]) Len() int {
+	return i.len
+}
+
+// Insert adds the fileblock, which must have its Min and Max set
+func (i *IntervalIndex[O]) Insert(fb *Fileblock[O]) {
+	i.root = insertInterval(i.root, &intervalNode[O]{fb: fb, priority: rand.Uint64()})
+	i.len++
+}
+
+func insertInterval[O cmp.Ordered](n, node *intervalNode[O]) *intervalNode[O] {
+	if n == nil {
+		node.update()
+		return node
+	}
+
+	// fileblocks with the same min go right, after the ones inserted before them
+	if *node.fb.Min < *n.fb.Min {
+		n.left = insertInterval(n.left, node)
+		if n.left.priority > n.priority {
+			n = rotateRight(n)
+		}
+	} else {
+		n.right = insertInterval(n.right, node)
+		if n.right.priority > n.priority {
+			n = rotateLeft(n)
+		}
+	}
+
+	n.update()
+	return n
+}
+
+func rotateRight[O cmp.Ordered](n *intervalNode[O]) *intervalNode[O] {
+	l := n.left
+	n.left, l.right = l.right, n
+	n.update()
+	l.update()
+
+	return l
+}
+
+func rotateLeft[O cmp.Ordered](n *intervalNode[O]) *intervalNode[O] {
+	r := n.right
+	n.right, r.left = r.left, n
+	n.update()
+	r.update()
+
+	return r
+}
+
+// Remove drops the fileblock equal to fb, see Fileblock.Equals. It returns false if it wasn't found.
+func (i *IntervalIndex[O]) Remove(fb *Fileblock[O]) bool {
+	var removed bool
+	i.root, removed = removeInterval(i.root, fb)
+	if removed {
+		i.len--
+	}
+
+	return removed
+}
+
+func removeInterval[O cmp.Ordered](n *intervalNode[O], fb *Fileblock[O]) (*intervalNode[O], bool) {
+	if n == nil {
+		return nil, false
+	}
+
+	var removed bool
+	switch {
+	case *fb.Min < *n.fb.Min:
+		n.left, removed = removeInterval(n.left, fb)
+	case *fb.Min > *n.fb.Min:
+		n.right, removed = removeInterval(n.right, fb)
+	case n.fb.Equals(fb):
+		return mergeIntervals(n.left, n.right), true
+	default:
+		// rotations can leave fileblocks with the same min at both sides
+		if n.left, removed = removeInterval(n.left, fb); !removed {
+			n.right, removed = removeInterval(n.right, fb)
+		}
+	}
+
+	if removed {
+		n.update()
+	}
+
+	return n, removed
+}
+
+// mergeIntervals joins two treaps, every fileblock of a goes before the ones of b
+func mergeIntervals[O cmp.Ordered](a, b *intervalNode[O]) *intervalNode[O] {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+
+	if a.priority > b.priority {
+		a.right = mergeIntervals(a.right, b)
+		a.update()
+		return a
+	}
+
+	b.left = mergeIntervals(a, b.left)
+	b.update()
+	return b
+}
+
+// Ascend calls fn with every fileblock in ascending order of Min until it returns false
+func (i *IntervalIndex[O]) Ascend(fn func(*Fileblock[O]) bool) {
+	ascendIntervals(i.root, fn)
+}
+
+func ascendIntervals[O cmp.Ordered](n *intervalNode[O], fn func(*Fileblock[O]) bool) bool {
+	if n == nil {
+		return true
+	}
+
+	return ascendIntervals(n.left, fn) && fn(n.fb) && ascendIntervals(n.right, fn)
+}
+
+// Descend calls fn with every fileblock in descending order of Min until it returns false
+func (i *IntervalIndex[O]) Descend(fn func(*Fileblock[O]) bool) {
+	descendIntervals(i.root, fn)
+}
+
+func descendIntervals[O cmp.Ordered](n *intervalNode[O], fn func(*Fileblock[O]) bool) bool {
+	if n == nil {
+		return true
+	}
+
+	return descendIntervals(n.right, fn) && fn(n.fb) && descendIntervals(n.left, fn)
+}
+
+// DescendMax calls fn with every fileblock in descending order of Max until it returns false. A
+// subtree is only visited once its greatest Max is the next one, so stopping early is cheap.
+func (i *IntervalIndex[O]) DescendMax(fn func(*Fileblock[O]) bool) {
+	if i.root == nil {
+		return
+	}
+
+	h := &maxEndHeap[O]{{node: i.root, max: i.root.maxEnd}}
+	for h.Len() > 0 {
+		item := heap.Pop(h).(maxEndItem[O])
+		if item.fb != nil {
+			if !fn(item.fb) {
+				return
+			}
+			continue
+		}
+
+		n := item.node
+		heap.Push(h, maxEndItem[O]{fb: n.fb, max: *n.fb.Max})
+		if n.left != nil {
+			heap.Push(h, maxEndItem[O]{node: n.left, max: n.left.maxEnd})
+		}
+		if n.right != nil {
+			heap.Push(h, maxEndItem[O]{node: n.right, max: n.right.maxEnd})
+		}
+	}
+}
+
+// maxEndItem is a fileblock or a subtree not visited yet, with the greatest Max it holds
+type maxEndItem[O cmp.Ordered] struct {
+	node *intervalNode[O]
+	fb   *Fileblock[O]
+	max  O
+}
+
+type maxEndHeap[O cmp.Ordered] []maxEndItem[O]
+
+func (h maxEndHeap[O]) Len() int           { return len(h) }
+func (h maxEndHeap[O]) Less(i, j int) bool { return h[i].max > h[j].max }
+func (h maxEndHeap[O]) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *maxEndHeap[O]) Push(x any)        { *h = append(*h, x.(maxEndItem[O])) }
+func (h *maxEndHeap[O]) Pop() any {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
+
+// AscendRange calls fn, in ascending order of Min, with every fileblock that has values between min
+// and max, both included, until it returns false.
+func (i *IntervalIndex[O]) AscendRange(min, max O, fn func(*Fileblock[O]) bool) {
+	ascendOverlapping(i.root, min, max, fn)
+}
+
+func ascendOverlapping[O cmp.Ordered](n *intervalNode[O], min, max O, fn func(*Fileblock[O]) bool) bool {
+	if n == nil || n.maxEnd < min {
+		return true
+	}
+	if !ascendOverlapping(n.left, min, max, fn) {
+		return false
+	}
+	// neither this fileblock nor the ones after it start before max
+	if *n.fb.Min > max {
+		return false
+	}
+	if *n.fb.Max >= min && !fn(n.fb) {
+		return false
+	}
+
+	return ascendOverlapping(n.right, min, max, fn)
+}
+
+// DescendRange is AscendRange in descending order of Min
+func (i *IntervalIndex[O]) DescendRange(min, max O, fn func(*Fileblock[O]) bool) {
+	descendOverlapping(i.root, min, max, fn)
+}
+
+func descendOverlapping[O cmp.Ordered](n *intervalNode[O], min, max O, fn func(*Fileblock[O]) bool) bool {
+	if n == nil || n.maxEnd < min {
+		return true
+	}
+	if *n.fb.Min <= max {
+		if !descendOverlapping(n.right, min, max, fn) {
+			return false
+		}
+		if *n.fb.Max >= min && !fn(n.fb) {
+			return false
+		}
+	}
+
+	return descendOverlapping(n.left, min, max, fn)
+}
+
+// AscendRangeWithFilters returns an iterator over the entries of the fileblocks with values between
+// min and max, both included, that match the filters. Fileblocks are pruned by the rows of their
+// metadata, see Fileblock.MatchesRange, and loaded in the order of the entries they hold, ordered
+// by primary index, secondary index and min value. It stops with the error of the context if it's
+// cancelled.
+func (i *IntervalIndex[O]) AscendRangeWithFilters(ctx context.Context, min, max O, filters ...EntryFilter) (EntryIterator[O], bool, error) {
+	result := make([]*Fileblock[O], 0)
+	i.AscendRange(min, max, func(fb *Fileblock[O]) bool {
+		if fb.MatchesRange(min, max, filters...) && fb.Acquire() {
+			result = append(result, fb)
+		}
+		return true
+	})
+
+	return newIteratorWithFilters(ctx, result, min, max, false, filters), len(result) > 0, nil
+}
+
+// DescendRangeWithFilters is AscendRangeWithFilters with the entries of every series in descending
+// order of their max value, so the fileblocks with its newest values are read first.
+func (i *IntervalIndex[O]) DescendRangeWithFilters(ctx context.Context, min, max O, filters ...EntryFilter) (EntryIterator[O], bool, error) {
+	result := make([]*Fileblock[O], 0)
+	i.DescendRange(min, max, func(fb *Fileblock[O]) bool {
+		if fb.MatchesRange(min, max, filters...) && fb.Acquire() {
+			result = append(result, fb)
+		}
+		return true
+	})
+
+	return newIteratorWithFilters(ctx, result, min, max, true, filters), len(result) > 0, nil
+}
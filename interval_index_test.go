@@ -0,0 +1,184 @@
+package streedb
+
+import (
+	"cmp"
+	"context"
+	"math/rand"
+	"slices"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func uuids(blocks []*Fileblock[int64]) []string {
+	res := make([]string, 0, len(blocks))
+	for _, fb := range blocks {
+		res = append(res, fb.Uuid)
+	}
+
+	return res
+}
+
+// overlapping scans every fileblock, in the order they were inserted, for the ones between min and
+// max sorted by Min
+func overlapping(blocks []*Fileblock[int64], min, max int64) []*Fileblock[int64] {
+	res := make([]*Fileblock[int64], 0)
+	for _, fb := range blocks {
+		if *fb.Min <= max && *fb.Max >= min {
+			res = append(res, fb)
+		}
+	}
+	slices.SortStableFunc(res, func(a, b *Fileblock[int64]) int { return cmp.Compare(*a.Min, *b.Min) })
+
+	return res
+}
+
+func TestIntervalIndex(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	index := NewIntervalIndex[int64]()
+	blocks := make([]*Fileblock[int64], 0)
+
+	for i := 0; i < 2000; i++ {
+		if len(blocks) > 0 && r.Intn(3) == 0 {
+			j := r.Intn(len(blocks))
+			require.True(t, index.Remove(blocks[j]))
+			require.False(t, index.Remove(blocks[j]))
+			blocks = slices.Delete(blocks, j, j+1)
+		} else {
+			// few distinct values, so that many fileblocks share their min
+			min := r.Int63n(100)
+			fb := createMockFileblock("p", "s", min, min+r.Int63n(20))
+			fb.Uuid = strconv.Itoa(i)
+			index.Insert(fb)
+			blocks = append(blocks, fb)
+		}
+		require.Equal(t, len(blocks), index.Len())
+
+		min := r.Int63n(130) - 10
+		max := min + r.Int63n(30)
+		expected := uuids(overlapping(blocks, min, max))
+
+		ascending := make([]*Fileblock[int64], 0)
+		index.AscendRange(min, max, func(fb *Fileblock[int64]) bool {
+			ascending = append(ascending, fb)
+			return true
+		})
+		require.Equal(t, expected, uuids(ascending), "ascending [%d, %d]", min, max)
+
+		descending := make([]*Fileblock[int64], 0)
+		index.DescendRange(min, max, func(fb *Fileblock[int64]) bool {
+			descending = append(descending, fb)
+			return true
+		})
+		slices.Reverse(descending)
+		require.Equal(t, expected, uuids(descending), "descending [%d, %d]", min, max)
+	}
+
+	all := make([]*Fileblock[int64], 0)
+	index.Ascend(func(fb *Fileblock[int64]) bool {
+		all = append(all, fb)
+		return true
+	})
+	assert.Equal(t, uuids(overlapping(blocks, -1, 200)), uuids(all))
+
+	expectedMax := make([]int64, 0, len(blocks))
+	for _, fb := range blocks {
+		expectedMax = append(expectedMax, *fb.Max)
+	}
+	slices.Sort(expectedMax)
+	slices.Reverse(expectedMax)
+
+	byMax := make([]int64, 0, len(blocks))
+	index.DescendMax(func(fb *Fileblock[int64]) bool {
+		byMax = append(byMax, *fb.Max)
+		return true
+	})
+	assert.Equal(t, expectedMax, byMax)
+
+	t.Run("Stop", func(t *testing.T) {
+		count := 0
+		index.AscendRange(0, 200, func(fb *Fileblock[int64]) bool {
+			count++
+			return count < 3
+		})
+		assert.Equal(t, 3, count)
+
+		count = 0
+		index.Descend(func(fb *Fileblock[int64]) bool {
+			count++
+			return false
+		})
+		assert.Equal(t, 1, count)
+
+		count = 0
+		index.DescendMax(func(fb *Fileblock[int64]) bool {
+			count++
+			return count < 2
+		})
+		assert.Equal(t, 2, count)
+	})
+}
+
+func TestIntervalIndexRangeWithFilters(t *testing.T) {
+	index := NewIntervalIndex[int64]()
+	// starts before the range and ends inside it
+	index.Insert(createMockFileblock("instance1", "cpu", 1, 6))
+	// starts at the end of the range
+	index.Insert(createMockFileblock("instance1", "cpu", 10, 12))
+	index.Insert(createMockFileblock("instance1", "mem", 5, 7))
+	index.Insert(createMockFileblock("instance1", "cpu", 11, 15))
+
+	iter, found, err := index.AscendRangeWithFilters(context.Background(), 5, 10, PrimaryIndexFilter("instance1"), SecondaryIndexFilter[int64]("cpu"))
+	require.NoError(t, err)
+	require.True(t, found)
+	require.NoError(t, iter.Close())
+
+	mins := make([]int64, 0)
+	index.AscendRange(5, 10, func(fb *Fileblock[int64]) bool {
+		if fb.Matches(SecondaryIndexFilter[int64]("cpu")) {
+			mins = append(mins, *fb.Min)
+		}
+		return true
+	})
+	assert.Equal(t, []int64{1, 10}, mins)
+
+	iter, found, err = index.DescendRangeWithFilters(context.Background(), 16, 20, PrimaryIndexFilter("instance1"))
+	require.NoError(t, err)
+	assert.False(t, found)
+	require.NoError(t, iter.Close())
+}
+
+func TestIntervalIndexRowPruning(t *testing.T) {
+	fb := createMockFileblock("instance1", "cpu", 1, 5)
+	fb.Rows = append(fb.Rows, Row[int64]{SecondaryIdx: "mem", Min: 6, Max: 10, ItemCount: 1})
+	*fb.Max = 10
+	fs := fb.filesystem.(*mockFilesystem[int64])
+
+	index := NewIntervalIndex[int64]()
+	index.Insert(fb)
+
+	assert.True(t, fb.MatchesRange(1, 5, SecondaryIndexFilter[int64]("cpu")))
+	assert.True(t, fb.MatchesRange(5, 20))
+	assert.False(t, fb.MatchesRange(6, 10, SecondaryIndexFilter[int64]("cpu")))
+	assert.False(t, fb.MatchesRange(11, 20))
+	assert.True(t, fb.Matches(SecondaryIndexFilter[int64]("cpu")))
+
+	// cpu has no values in the range, so the fileblock isn't loaded
+	iter, found, err := index.AscendRangeWithFilters(context.Background(), 6, 10, SecondaryIndexFilter[int64]("cpu"))
+	require.NoError(t, err)
+	assert.False(t, found)
+	_, found, err = iter.Next()
+	require.NoError(t, err)
+	assert.False(t, found)
+	assert.Equal(t, 0, fs.extra.load)
+
+	iter, found, err = index.DescendRangeWithFilters(context.Background(), 6, 10, SecondaryIndexFilter[int64]("mem"))
+	require.NoError(t, err)
+	assert.True(t, found)
+	_, _, err = iter.Next()
+	require.NoError(t, err)
+	require.NoError(t, iter.Close())
+	assert.Equal(t, 1, fs.extra.load)
+}
@@ -8,6 +8,7 @@ import (
 	"math"
 	"slices"
 	"sort"
+	"unsafe"
 
 	"github.com/spaolacci/murmur3"
 )
@@ -34,26 +35,70 @@ func (l *Kv) Merge(a Entry[int64]) error {
 	return l.Append(a)
 }
 
+func (l *Kv) Clone() Entry[int64] {
+	return &Kv{
+		PrimaryIdx: l.PrimaryIdx,
+		Key:        l.Key,
+		Ts:         slices.Clone(l.Ts),
+		Val:        slices.Clone(l.Val),
+	}
+}
+
+func (l *Kv) DeleteRange(min, max int64) {
+	n := 0
+	for i, ts := range l.Ts {
+		if ts >= min && ts <= max {
+			continue
+		}
+		l.Ts[n] = ts
+		l.Val[n] = l.Val[i]
+		n++
+	}
+
+	l.Ts = l.Ts[:n]
+	l.Val = l.Val[:n]
+	l.min = nil
+	l.max = nil
+}
+
 func (l *Kv) Sort() {
 	if sort.IsSorted(l) {
 		return
 	}
 
-	slices.SortFunc(l.Ts, func(i, j int64) int {
-		if i < j {
-			return -1
-		} else if i > j {
-			return 1
-		} else {
-			return 0
-		}
-	})
+	// values must move along with their timestamps. Without a value for every timestamp they can't
+	// be paired, only the timestamps are sorted then
+	if len(l.Val) != len(l.Ts) {
+		slices.Sort(l.Ts)
+		return
+	}
+	sort.Sort(l)
+}
+
+func (l *Kv) Reverse() {
+	slices.Reverse(l.Ts)
+	slices.Reverse(l.Val)
+}
+
+func (l *Kv) Slice(from, to int) Entry[int64] {
+	// capped, so that appending to the result doesn't overwrite values of l
+	return &Kv{PrimaryIdx: l.PrimaryIdx, Key: l.Key, Ts: l.Ts[from:to:to], Val: l.Val[from:to:to]}
+}
+
+// Sample implements Sampler
+func (l *Kv) Sample(i int) (int64, float64) {
+	return l.Ts[i], float64(l.Val[i])
 }
 
 func (l *Kv) Len() int {
 	return len(l.Ts)
 }
 
+// SizeBytes implements Sizer. Slices are accounted by capacity, which is what they hold in memory
+func (l *Kv) SizeBytes() int {
+	return int(unsafe.Sizeof(*l)) + len(l.PrimaryIdx) + len(l.Key) + cap(l.Ts)*8 + cap(l.Val)*4
+}
+
 func (l *Kv) Less(i, j int) bool {
 	return l.Ts[i] < l.Ts[j]
 }
@@ -63,14 +108,31 @@ func (l *Kv) Swap(i, j int) {
 	l.Val[i], l.Val[j] = l.Val[j], l.Val[i]
 }
 
+// Overlap returns the values between min and max, both included. When the values are sorted, the
+// result shares its memory with l instead of copying it.
 func (l *Kv) Overlap(min, max int64) (Entry[int64], bool) {
-	lMin := l.Min()
-	lMax := l.Max()
+	if l.Len() == 0 || l.Min() > max || l.Max() < min {
+		return nil, false
+	}
+	if l.Min() >= min && l.Max() <= max {
+		return l, true
+	}
 
-	isOverlapped := (lMin < max || lMin == max) &&
-		(min < lMax || min == lMax)
+	if sort.IsSorted(l) {
+		from := sort.Search(len(l.Ts), func(i int) bool { return l.Ts[i] >= min })
+		to := sort.Search(len(l.Ts), func(i int) bool { return l.Ts[i] > max })
+		return l.Slice(from, to), from < to
+	}
+
+	res := &Kv{PrimaryIdx: l.PrimaryIdx, Key: l.Key}
+	for i, ts := range l.Ts {
+		if ts >= min && ts <= max {
+			res.Ts = append(res.Ts, ts)
+			res.Val = append(res.Val, l.Val[i])
+		}
+	}
 
-	return l, isOverlapped
+	return res, res.Len() > 0
 }
 
 func (l *Kv) Append(a Entry[int64]) error {
@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestAdjacent(t *testing.T) {
@@ -15,3 +16,44 @@ func TestAdjacent(t *testing.T) {
 	assert.False(t, a.IsAdjacent(&c))
 	assert.False(t, b.IsAdjacent(&c))
 }
+
+func TestKvOverlap(t *testing.T) {
+	kv := NewKv("instance1", "cpu", []int64{1, 3, 5, 7, 9}, []int32{10, 30, 50, 70, 90})
+
+	res, found := kv.Overlap(2, 7)
+	require.True(t, found)
+	trimmed := res.(*Kv)
+	assert.Equal(t, []int64{3, 5, 7}, trimmed.Ts)
+	assert.Equal(t, []int32{30, 50, 70}, trimmed.Val)
+	assert.Equal(t, "instance1", trimmed.PrimaryIndex())
+	assert.Equal(t, "cpu", trimmed.SecondaryIndex())
+
+	// sorted values are shared, but appending to the result doesn't touch kv
+	assert.Same(t, &kv.Ts[1], &trimmed.Ts[0])
+	require.NoError(t, trimmed.Append(NewKv("instance1", "cpu", []int64{8}, []int32{80})))
+	assert.Equal(t, []int64{1, 3, 5, 7, 9}, kv.Ts)
+
+	res, found = kv.Overlap(0, 10)
+	assert.True(t, found)
+	assert.Same(t, kv, res)
+
+	_, found = kv.Overlap(4, 4)
+	assert.False(t, found)
+	_, found = kv.Overlap(10, 20)
+	assert.False(t, found)
+
+	unsorted := NewKv("instance1", "cpu", []int64{9, 1, 5}, []int32{90, 10, 50})
+	res, found = unsorted.Overlap(4, 9)
+	require.True(t, found)
+	assert.Equal(t, []int64{9, 5}, res.(*Kv).Ts)
+	assert.Equal(t, []int32{90, 50}, res.(*Kv).Val)
+}
+
+func TestKvSort(t *testing.T) {
+	kv := NewKv("instance1", "cpu", []int64{5, 1, 3, 2}, []int32{50, 10, 30, 20})
+	kv.Sort()
+
+	// values move along with their timestamps
+	assert.Equal(t, []int64{1, 2, 3, 5}, kv.Ts)
+	assert.Equal(t, []int32{10, 20, 30, 50}, kv.Val)
+}
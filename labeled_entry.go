@@ -0,0 +1,256 @@
+package streedb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"unsafe"
+
+	"github.com/spaolacci/murmur3"
+)
+
+// NewLabeledEntry returns the values of a series identified by its labels. The primary index groups
+// series in fileblocks, like the metric name, while the secondary index is the string of the labels.
+func NewLabeledEntry(primaryIdx string, labels Labels, ts []int64, val []float64) *LabeledEntry {
+	e := &LabeledEntry{
+		PrimaryIdx:  primaryIdx,
+		Key:         labels.String(),
+		LabelNames:  make([]string, 0, len(labels)),
+		LabelValues: make([]string, 0, len(labels)),
+		Ts:          ts,
+		Val:         val,
+	}
+
+	for _, label := range labels {
+		e.LabelNames = append(e.LabelNames, label.Name)
+		e.LabelValues = append(e.LabelValues, label.Value)
+	}
+
+	return e
+}
+
+type LabeledEntry struct {
+	PrimaryIdx  string
+	Key         string    `parquet:"name=key, type=BYTE_ARRAY, convertedtype=UTF8, encoding=DELTA_LENGTH_BYTE_ARRAY"`
+	LabelNames  []string  `parquet:"name=label_names, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=REPEATED"`
+	LabelValues []string  `parquet:"name=label_values, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=REPEATED"`
+	Ts          []int64   `parquet:"name=ts, type=INT64, encoding=DELTA_BINARY_PACKED, repetitiontype=REPEATED"`
+	Val         []float64 `parquet:"name=val, type=DOUBLE, repetitiontype=REPEATED"`
+	min         *int64
+	max         *int64
+}
+
+// Labels implements Labeled
+func (l *LabeledEntry) Labels() Labels {
+	labels := make(Labels, 0, len(l.LabelNames))
+	for i, name := range l.LabelNames {
+		labels = append(labels, Label{Name: name, Value: l.LabelValues[i]})
+	}
+
+	return labels
+}
+
+func (l *LabeledEntry) Merge(a Entry[int64]) error {
+	return l.Append(a)
+}
+
+func (l *LabeledEntry) Clone() Entry[int64] {
+	return &LabeledEntry{
+		PrimaryIdx:  l.PrimaryIdx,
+		Key:         l.Key,
+		LabelNames:  slices.Clone(l.LabelNames),
+		LabelValues: slices.Clone(l.LabelValues),
+		Ts:          slices.Clone(l.Ts),
+		Val:         slices.Clone(l.Val),
+	}
+}
+
+func (l *LabeledEntry) DeleteRange(min, max int64) {
+	n := 0
+	for i, ts := range l.Ts {
+		if ts >= min && ts <= max {
+			continue
+		}
+		l.Ts[n] = ts
+		l.Val[n] = l.Val[i]
+		n++
+	}
+
+	l.Ts = l.Ts[:n]
+	l.Val = l.Val[:n]
+	l.min = nil
+	l.max = nil
+}
+
+func (l *LabeledEntry) Sort() {
+	if sort.IsSorted(l) {
+		return
+	}
+
+	sort.Sort(l)
+}
+
+func (l *LabeledEntry) Less(i, j int) bool {
+	return l.Ts[i] < l.Ts[j]
+}
+
+func (l *LabeledEntry) Swap(i, j int) {
+	l.Ts[i], l.Ts[j] = l.Ts[j], l.Ts[i]
+	l.Val[i], l.Val[j] = l.Val[j], l.Val[i]
+}
+
+func (l *LabeledEntry) Reverse() {
+	slices.Reverse(l.Ts)
+	slices.Reverse(l.Val)
+}
+
+// Slice shares the values and the labels of l
+func (l *LabeledEntry) Slice(from, to int) Entry[int64] {
+	return &LabeledEntry{
+		PrimaryIdx:  l.PrimaryIdx,
+		Key:         l.Key,
+		LabelNames:  l.LabelNames,
+		LabelValues: l.LabelValues,
+		Ts:          l.Ts[from:to:to],
+		Val:         l.Val[from:to:to],
+	}
+}
+
+// Overlap returns the values between min and max, both included. When the values are sorted, the
+// result shares its memory with l instead of copying it.
+func (l *LabeledEntry) Overlap(min, max int64) (Entry[int64], bool) {
+	if l.Len() == 0 || l.Min() > max || l.Max() < min {
+		return nil, false
+	}
+	if l.Min() >= min && l.Max() <= max {
+		return l, true
+	}
+
+	if sort.IsSorted(l) {
+		from := sort.Search(len(l.Ts), func(i int) bool { return l.Ts[i] >= min })
+		to := sort.Search(len(l.Ts), func(i int) bool { return l.Ts[i] > max })
+		return l.Slice(from, to), from < to
+	}
+
+	res := l.Slice(0, 0).(*LabeledEntry)
+	for i, ts := range l.Ts {
+		if ts >= min && ts <= max {
+			res.Ts = append(res.Ts, ts)
+			res.Val = append(res.Val, l.Val[i])
+		}
+	}
+
+	return res, res.Len() > 0
+}
+
+func (l *LabeledEntry) Append(a Entry[int64]) error {
+	a_, ok := a.(*LabeledEntry)
+	if !ok {
+		return errors.New("invalid type")
+	}
+
+	l.Ts = append(l.Ts, a_.Ts...)
+	l.Val = append(l.Val, a_.Val...)
+
+	l.min = nil
+	l.max = nil
+
+	return nil
+}
+
+// Sample implements Sampler
+func (l *LabeledEntry) Sample(i int) (int64, float64) {
+	return l.Ts[i], l.Val[i]
+}
+
+func (l *LabeledEntry) Len() int {
+	return len(l.Ts)
+}
+
+// SizeBytes implements Sizer. Slices are accounted by capacity, which is what they hold in memory
+func (l *LabeledEntry) SizeBytes() int {
+	size := int(unsafe.Sizeof(*l)) + len(l.PrimaryIdx) + len(l.Key) + cap(l.Ts)*8 + cap(l.Val)*8
+	for i := range l.LabelNames {
+		size += len(l.LabelNames[i]) + len(l.LabelValues[i])
+	}
+
+	return size
+}
+
+func (l *LabeledEntry) Last() int64 {
+	return l.Ts[len(l.Ts)-1]
+}
+
+func (l *LabeledEntry) Max() int64 {
+	if l.max != nil {
+		return *l.max
+	}
+
+	max := int64(math.MinInt64)
+	for _, v := range l.Ts {
+		if v > max {
+			max = v
+		}
+	}
+
+	l.max = &max
+	return max
+}
+
+func (l *LabeledEntry) Min() int64 {
+	if l.min != nil {
+		return *l.min
+	}
+
+	min := int64(math.MaxInt64)
+	for _, v := range l.Ts {
+		if v < min {
+			min = v
+		}
+	}
+
+	l.min = &min
+	return min
+}
+
+func (l *LabeledEntry) LessThan(a Comparable[int64]) bool {
+	return l.Key < a.SecondaryIndex()
+}
+
+func (l *LabeledEntry) Equals(b Comparable[int64]) bool {
+	if l.PrimaryIndex() != "" {
+		return l.PrimaryIndex() == b.PrimaryIndex() && l.SecondaryIndex() == b.SecondaryIndex()
+	}
+	return l.SecondaryIndex() == b.SecondaryIndex()
+}
+
+func (l *LabeledEntry) SetPrimaryIndex(s string) {
+	l.PrimaryIdx = s
+}
+
+func (l *LabeledEntry) PrimaryIndex() string {
+	return l.PrimaryIdx
+}
+
+func (l *LabeledEntry) SecondaryIndex() string {
+	return l.Key
+}
+
+func (l *LabeledEntry) UUID() string {
+	pIdx := l.PrimaryIndex()
+	sIdx := l.SecondaryIndex()
+	buff := bytes.NewBuffer(make([]byte, 0, len(pIdx)+len(sIdx)+binary.MaxVarintLen64))
+	buff.WriteString(pIdx)
+	buff.WriteString(sIdx)
+	buff.Write(binary.AppendVarint(nil, l.Min()))
+
+	return fmt.Sprintf("%d", murmur3.Sum64(buff.Bytes()))
+}
+
+func (l *LabeledEntry) String() string {
+	return l.PrimaryIdx + l.Key
+}
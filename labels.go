@@ -0,0 +1,136 @@
+package streedb
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidLabels = errors.New("invalid labels")
+
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Labels identify a series, sorted by name. Use NewLabels to build them.
+type Labels []Label
+
+// Labeled is implemented by entries and metadata rows of series identified by labels
+type Labeled interface {
+	Labels() Labels
+}
+
+// NewLabels returns the labels of the map sorted by name. Names can't be empty.
+func NewLabels(labels map[string]string) (Labels, error) {
+	res := make(Labels, 0, len(labels))
+	for name, value := range labels {
+		if name == "" {
+			return nil, fmt.Errorf("%w: empty label name", ErrInvalidLabels)
+		}
+		res = append(res, Label{Name: name, Value: value})
+	}
+
+	slices.SortFunc(res, func(a, b Label) int { return strings.Compare(a.Name, b.Name) })
+
+	return res, nil
+}
+
+// Get returns the value of a label, empty if it's not set
+func (l Labels) Get(name string) string {
+	i, found := slices.BinarySearchFunc(l, name, func(label Label, name string) int { return strings.Compare(label.Name, name) })
+	if !found {
+		return ""
+	}
+
+	return l[i].Value
+}
+
+func (l Labels) Map() map[string]string {
+	res := make(map[string]string, len(l))
+	for _, label := range l {
+		res[label.Name] = label.Value
+	}
+
+	return res
+}
+
+// String returns the labels like {host="a",region="eu"}. It's unique for every set of labels, so it
+// identifies the series.
+func (l Labels) String() string {
+	b := strings.Builder{}
+	b.WriteByte('{')
+	for i, label := range l {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(label.Name)
+		b.WriteByte('=')
+		b.WriteString(strconv.Quote(label.Value))
+	}
+	b.WriteByte('}')
+
+	return b.String()
+}
+
+// LabelMatcher is implemented by the filters of a label, so that they can be resolved with a
+// PostingsIndex
+type LabelMatcher interface {
+	EntryFilter
+	Label() string
+	// MatchesValue tells if a value of the label passes the filter, empty for a missing label
+	MatchesValue(string) bool
+}
+
+// LabelFilter matches the value of a label of the entries with an expression, with the syntax of
+// ParseFilter. Entries that aren't Labeled, or don't have the label, have it empty.
+func LabelFilter(name, expr string) (LabelMatcher, error) {
+	value, err := parseFilter(SecondaryIndexFilterKind, Indexer.SecondaryIndex, false, expr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &labelFilter{name: name, value: value}, nil
+}
+
+type labelFilter struct {
+	name string
+	// value matches the value of the label, as the secondary index of a labelValue
+	value EntryFilter
+}
+
+func (l *labelFilter) Filter(i Indexer) bool {
+	if labeled, ok := i.(Labeled); ok {
+		return l.MatchesValue(labeled.Labels().Get(l.name))
+	}
+
+	return l.MatchesValue("")
+}
+
+func (l *labelFilter) Kind() EntryFilterKind {
+	return SecondaryIndexFilterKind
+}
+
+func (l *labelFilter) Label() string {
+	return l.name
+}
+
+func (l *labelFilter) MatchesValue(v string) bool {
+	return l.value.Filter(labelValue(v))
+}
+
+func (l *labelFilter) MembershipKey() (string, bool) {
+	if f, ok := l.value.(*indexFilter); ok && f.value != "" {
+		return labelKey(l.name, f.value), true
+	}
+
+	return "", false
+}
+
+// labelValue is an Indexer with the value of a label as secondary index
+type labelValue string
+
+func (l labelValue) PrimaryIndex() string   { return "" }
+func (l labelValue) SecondaryIndex() string { return string(l) }
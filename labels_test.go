@@ -0,0 +1,87 @@
+package streedb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLabels(t *testing.T) {
+	labels, err := NewLabels(map[string]string{"service": "api", "host": "a", "region": "eu"})
+	require.NoError(t, err)
+
+	assert.Equal(t, Labels{{"host", "a"}, {"region", "eu"}, {"service", "api"}}, labels)
+	assert.Equal(t, "eu", labels.Get("region"))
+	assert.Equal(t, "", labels.Get("zone"))
+	assert.Equal(t, `{host="a",region="eu",service="api"}`, labels.String())
+	assert.Equal(t, map[string]string{"service": "api", "host": "a", "region": "eu"}, labels.Map())
+
+	_, err = NewLabels(map[string]string{"": "a"})
+	assert.ErrorIs(t, err, ErrInvalidLabels)
+}
+
+func TestLabeledEntry(t *testing.T) {
+	labels, err := NewLabels(map[string]string{"host": "a", "region": "eu"})
+	require.NoError(t, err)
+
+	entry := NewLabeledEntry("cpu", labels, []int64{1, 2, 3, 4}, []float64{1, 2, 3, 4})
+	assert.Equal(t, "cpu", entry.PrimaryIndex())
+	assert.Equal(t, labels.String(), entry.SecondaryIndex())
+	assert.Equal(t, labels, entry.Labels())
+
+	overlap, found := entry.Overlap(2, 3)
+	require.True(t, found)
+	assert.Equal(t, []int64{2, 3}, overlap.(*LabeledEntry).Ts)
+	assert.Equal(t, []float64{2, 3}, overlap.(*LabeledEntry).Val)
+	assert.Equal(t, labels, overlap.(*LabeledEntry).Labels())
+
+	clone := entry.Clone().(*LabeledEntry)
+	clone.LabelValues[0] = "b"
+	assert.Equal(t, "a", entry.Labels().Get("host"))
+
+	require.NoError(t, entry.Append(NewLabeledEntry("cpu", labels, []int64{0}, []float64{0})))
+	entry.Sort()
+	assert.Equal(t, []int64{0, 1, 2, 3, 4}, entry.Ts)
+	assert.Equal(t, int64(0), entry.Min())
+
+	assert.Error(t, entry.Append(NewKv("cpu", "a", []int64{0}, []int32{0})))
+}
+
+func TestLabelFilter(t *testing.T) {
+	eu, err := NewLabels(map[string]string{"host": "instance-1", "region": "eu-west"})
+	require.NoError(t, err)
+	us, err := NewLabels(map[string]string{"host": "instance-2", "region": "us-east"})
+	require.NoError(t, err)
+
+	region, err := LabelFilter("region", "glob:eu-*")
+	require.NoError(t, err)
+	noZone, err := LabelFilter("zone", "!regex:.+")
+	require.NoError(t, err)
+
+	assert.True(t, MatchesFilters(NewLabeledEntry("cpu", eu, nil, nil), region, noZone))
+	assert.False(t, MatchesFilters(NewLabeledEntry("cpu", us, nil, nil), region, noZone))
+	// entries without labels have every label empty
+	assert.False(t, region.Filter(NewKv("cpu", "eu-west", nil, nil)))
+	assert.True(t, noZone.Filter(NewKv("cpu", "eu-west", nil, nil)))
+
+	// label filters can't prune the primary index
+	assert.Equal(t, "", FilterPrefix(SecondaryIndexFilterKind, region))
+
+	_, err = LabelFilter("region", "regex:(")
+	assert.ErrorIs(t, err, ErrInvalidFilter)
+
+	t.Run("Fileblock", func(t *testing.T) {
+		builder := NewMetadataBuilder[int64](NewDefaultConfig())
+		builder.WithEntry(NewLabeledEntry("cpu", us, []int64{1}, []float64{1}))
+		builder.WithEntry(NewLabeledEntry("cpu", us, []int64{5}, []float64{1}))
+		fb := NewFileblock(NewDefaultConfig(), &builder.MetaFile, nil)
+
+		require.Len(t, fb.Rows, 1)
+		assert.Equal(t, Row[int64]{SecondaryIdx: us.String(), ItemCount: 2, Min: 1, Max: 5, Labels: us}, fb.Rows[0])
+
+		assert.False(t, fb.Matches(region))
+		assert.True(t, fb.Matches(NotFilter(region), PrimaryIndexFilter("cpu")))
+		assert.False(t, fb.Matches(NotFilter(region), PrimaryIndexFilter("mem")))
+	})
+}
@@ -1,10 +1,18 @@
 package streedb
 
-import "cmp"
+import (
+	"cmp"
+	"context"
+)
 
 type LsmTreeOps[O cmp.Ordered, E Entry[O]] interface {
 	Append(d Entry[O]) error
-	Find(pIdx, sIdx string, min, max O) (EntryIterator[O], bool, error)
+	Find(ctx context.Context, pIdx, sIdx string, min, max O) (EntryIterator[O], bool, error)
+	FindDescending(ctx context.Context, pIdx, sIdx string, min, max O) (EntryIterator[O], bool, error)
+	FindMatching(ctx context.Context, min, max O, filters ...EntryFilter) (EntryIterator[O], bool, error)
+	FindLatest(ctx context.Context, pIdx, sIdx string, n int) (EntryIterator[O], bool, error)
+	FindPage(ctx context.Context, pIdx, sIdx string, min, max O, limit int, cursor string) ([]Entry[O], string, error)
+	Delete(pIdx, sIdx string, min, max O) error
 	Close() error
 	Compact() error
 }
@@ -0,0 +1,295 @@
+package streedb
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrCorruptManifest = errors.New("corrupt manifest")
+
+	// ErrManifestCheckpoint is returned when an edit was recorded but the manifest couldn't be
+	// checkpointed afterwards. It's retried on the next edit.
+	ErrManifestCheckpoint = errors.New("error checkpointing manifest")
+
+	// ErrManifestFailed is returned by every write to the manifest once a record failed to be appended.
+	// The record might be on disk anyway, so its version can't be reused. The manifest can only be
+	// opened again, which reads whatever the store holds.
+	ErrManifestFailed = errors.New("manifest failed to append a record and must be reopened")
+)
+
+type ManifestEditKind int
+
+const (
+	ManifestEditAdd ManifestEditKind = iota
+	ManifestEditRemove
+	ManifestEditUpdate
+)
+
+// ManifestEdit adds, removes or updates the metadata of a fileblock of a level. Removals only need
+// the uuid.
+type ManifestEdit[O cmp.Ordered] struct {
+	Kind ManifestEditKind
+	Meta MetaFile[O]
+}
+
+// ManifestStore persists the manifest of a level: a checkpoint with every fileblock, followed by the
+// records of the edits made after it. Records carry increasing versions, the ones up to the version
+// of the checkpoint are already part of it.
+type ManifestStore interface {
+	// Read returns the checkpoint and the records appended after it in order, found is false if the
+	// manifest doesn't exist
+	Read() (checkpoint []byte, records [][]byte, found bool, err error)
+	// Append persists a record, it must be written whole or not at all
+	Append(version uint64, record []byte) error
+	// Checkpoint replaces the manifest with a checkpoint, dropping the records up to its version
+	Checkpoint(version uint64, checkpoint []byte) error
+}
+
+// Manifest records the fileblocks of a level, so that it can be opened with a single read instead
+// of reading the metadata of every fileblock. It's an append-only log of edits, like the MANIFEST of
+// RocksDB, rewritten as a checkpoint every checkpointEdits edits. It's safe for concurrent use.
+type Manifest[O cmp.Ordered] struct {
+	mu              sync.Mutex
+	store           ManifestStore
+	checkpointEdits int
+	// failed is set by the first failed append and never cleared
+	failed error
+
+	version    uint64
+	fileblocks map[string]MetaFile[O]
+	// edits made since the last checkpoint, and the uuids of the fileblocks they added
+	edits int
+	added map[string]struct{}
+}
+
+type manifestCheckpoint[O cmp.Ordered] struct {
+	Version    uint64
+	Fileblocks []MetaFile[O]
+}
+
+type manifestRecord[O cmp.Ordered] struct {
+	Version uint64
+	Edits   []ManifestEdit[O]
+}
+
+// NewManifest returns an empty manifest, Rebuild must be called to write it to the store
+func NewManifest[O cmp.Ordered](store ManifestStore, checkpointEdits int) *Manifest[O] {
+	return &Manifest[O]{
+		store:           store,
+		checkpointEdits: checkpointEdits,
+		fileblocks:      make(map[string]MetaFile[O]),
+		added:           make(map[string]struct{}),
+	}
+}
+
+// OpenManifest reads the manifest of the store. It returns false if there's none, Rebuild must be
+// called then with the fileblocks of the level.
+func OpenManifest[O cmp.Ordered](store ManifestStore, checkpointEdits int) (*Manifest[O], bool, error) {
+	m := NewManifest[O](store, checkpointEdits)
+
+	checkpointBytes, records, found, err := store.Read()
+	if err != nil {
+		return nil, false, err
+	}
+	if !found {
+		return m, false, nil
+	}
+
+	var checkpoint manifestCheckpoint[O]
+	if err = json.Unmarshal(checkpointBytes, &checkpoint); err != nil {
+		return nil, false, errors.Join(ErrCorruptManifest, err)
+	}
+	m.version = checkpoint.Version
+	for _, meta := range checkpoint.Fileblocks {
+		m.fileblocks[meta.Uuid] = meta
+	}
+
+	for _, byt := range records {
+		var record manifestRecord[O]
+		if err = json.Unmarshal(byt, &record); err != nil {
+			return nil, false, errors.Join(ErrCorruptManifest, err)
+		}
+		if record.Version <= m.version {
+			continue
+		}
+		if record.Version != m.version+1 {
+			return nil, false, fmt.Errorf("%w: version %d follows %d", ErrCorruptManifest, record.Version, m.version)
+		}
+
+		m.apply(record)
+		m.edits++
+	}
+
+	return m, true, nil
+}
+
+// Rebuild replaces the fileblocks of the manifest, with the ones found scanning a level
+func (m *Manifest[O]) Rebuild(metas []*MetaFile[O]) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.failed != nil {
+		return m.failed
+	}
+
+	m.fileblocks = make(map[string]MetaFile[O], len(metas))
+	for _, meta := range metas {
+		m.fileblocks[meta.Uuid] = manifestMeta(meta)
+	}
+
+	return m.checkpoint()
+}
+
+// Fileblocks returns the metadata of the fileblocks of the level, sorted by uuid
+func (m *Manifest[O]) Fileblocks() []*MetaFile[O] {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	res := make([]*MetaFile[O], 0, len(m.fileblocks))
+	for _, meta := range m.fileblocks {
+		meta = manifestMeta(&meta)
+		res = append(res, &meta)
+	}
+	slices.SortFunc(res, func(a, b *MetaFile[O]) int { return strings.Compare(a.Uuid, b.Uuid) })
+
+	return res
+}
+
+// AddedSinceCheckpoint returns the metadata of the fileblocks added since the last checkpoint, sorted
+// by uuid. The ones written just before a crash are among them.
+func (m *Manifest[O]) AddedSinceCheckpoint() []*MetaFile[O] {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	res := make([]*MetaFile[O], 0, len(m.added))
+	for uuid := range m.added {
+		meta := m.fileblocks[uuid]
+		meta = manifestMeta(&meta)
+		res = append(res, &meta)
+	}
+	slices.SortFunc(res, func(a, b *MetaFile[O]) int { return strings.Compare(a.Uuid, b.Uuid) })
+
+	return res
+}
+
+func (m *Manifest[O]) Add(meta *MetaFile[O]) error {
+	return m.Apply(ManifestEdit[O]{Kind: ManifestEditAdd, Meta: manifestMeta(meta)})
+}
+
+func (m *Manifest[O]) Remove(meta *MetaFile[O]) error {
+	return m.Apply(ManifestEdit[O]{Kind: ManifestEditRemove, Meta: MetaFile[O]{Uuid: meta.Uuid}})
+}
+
+func (m *Manifest[O]) Update(meta *MetaFile[O]) error {
+	return m.Apply(ManifestEdit[O]{Kind: ManifestEditUpdate, Meta: manifestMeta(meta)})
+}
+
+// Replace adds meta and removes old in a single record, a crash leaves one of them but never both
+func (m *Manifest[O]) Replace(meta, old *MetaFile[O]) error {
+	return m.Apply(
+		ManifestEdit[O]{Kind: ManifestEditAdd, Meta: manifestMeta(meta)},
+		ManifestEdit[O]{Kind: ManifestEditRemove, Meta: MetaFile[O]{Uuid: old.Uuid}},
+	)
+}
+
+// Apply records the edits in a single record, so that either all or none of them survive a crash
+func (m *Manifest[O]) Apply(edits ...ManifestEdit[O]) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.failed != nil {
+		return m.failed
+	}
+
+	record := manifestRecord[O]{Version: m.version + 1, Edits: edits}
+	byt, err := json.Marshal(record)
+	if err != nil {
+		return errors.Join(errors.New("error encoding manifest record"), err)
+	}
+	if err = m.store.Append(record.Version, byt); err != nil {
+		// a failed sync might leave the record on disk, a later record with the same version would
+		// make the manifest unreadable
+		m.failed = errors.Join(ErrManifestFailed, errors.New("error appending manifest record"), err)
+		return m.failed
+	}
+
+	m.apply(record)
+	m.edits++
+	if m.edits < m.checkpointEdits {
+		return nil
+	}
+
+	if err = m.checkpoint(); err != nil {
+		return errors.Join(ErrManifestCheckpoint, err)
+	}
+
+	return nil
+}
+
+// Checkpoint rewrites the manifest with the current fileblocks
+func (m *Manifest[O]) Checkpoint() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.failed != nil {
+		return m.failed
+	}
+
+	return m.checkpoint()
+}
+
+// checkpoint must be called holding mu
+func (m *Manifest[O]) checkpoint() error {
+	checkpoint := manifestCheckpoint[O]{Version: m.version, Fileblocks: make([]MetaFile[O], 0, len(m.fileblocks))}
+	for _, meta := range m.fileblocks {
+		checkpoint.Fileblocks = append(checkpoint.Fileblocks, meta)
+	}
+	slices.SortFunc(checkpoint.Fileblocks, func(a, b MetaFile[O]) int { return strings.Compare(a.Uuid, b.Uuid) })
+
+	byt, err := json.Marshal(checkpoint)
+	if err != nil {
+		return errors.Join(errors.New("error encoding manifest checkpoint"), err)
+	}
+	if err = m.store.Checkpoint(m.version, byt); err != nil {
+		return err
+	}
+	m.edits = 0
+	m.added = make(map[string]struct{})
+
+	return nil
+}
+
+// apply must be called holding mu, or before the manifest is returned
+func (m *Manifest[O]) apply(record manifestRecord[O]) {
+	m.version = record.Version
+	for _, edit := range record.Edits {
+		switch edit.Kind {
+		case ManifestEditAdd:
+			m.fileblocks[edit.Meta.Uuid] = edit.Meta
+			m.added[edit.Meta.Uuid] = struct{}{}
+		case ManifestEditUpdate:
+			// a fileblock can be updated while it's being removed
+			if _, found := m.fileblocks[edit.Meta.Uuid]; found {
+				m.fileblocks[edit.Meta.Uuid] = edit.Meta
+			}
+		case ManifestEditRemove:
+			delete(m.fileblocks, edit.Meta.Uuid)
+			delete(m.added, edit.Meta.Uuid)
+		}
+	}
+}
+
+// manifestMeta copies the metadata, so that the manifest isn't changed by tombstones added to the
+// fileblock later
+func manifestMeta[O cmp.Ordered](meta *MetaFile[O]) MetaFile[O] {
+	res := *meta
+	res.Tombstones = slices.Clone(meta.Tombstones)
+
+	return res
+}
@@ -0,0 +1,178 @@
+package streedb
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mockManifestStore struct {
+	checkpoint        []byte
+	checkpointVersion uint64
+	records           [][]byte
+	checkpoints       int
+	// appendErr is returned by Append after writing the record, like a failed sync
+	appendErr error
+}
+
+func (m *mockManifestStore) Read() ([]byte, [][]byte, bool, error) {
+	return m.checkpoint, m.records, m.checkpoint != nil, nil
+}
+
+func (m *mockManifestStore) Append(_ uint64, record []byte) error {
+	m.records = append(m.records, record)
+	return m.appendErr
+}
+
+func (m *mockManifestStore) Checkpoint(version uint64, checkpoint []byte) error {
+	m.checkpoint = checkpoint
+	m.checkpointVersion = version
+	m.records = nil
+	m.checkpoints++
+	return nil
+}
+
+func manifestUuids(m *Manifest[int64]) []string {
+	res := make([]string, 0)
+	for _, meta := range m.Fileblocks() {
+		res = append(res, meta.Uuid)
+	}
+
+	return res
+}
+
+func TestManifest(t *testing.T) {
+	store := &mockManifestStore{}
+	manifest, found, err := OpenManifest[int64](store, 3)
+	require.NoError(t, err)
+	require.False(t, found)
+
+	a := createMockFileblock("instance1", "cpu", 1, 5)
+	a.Uuid = "a"
+	b := createMockFileblock("instance1", "mem", 1, 5)
+	b.Uuid = "b"
+	c := createMockFileblock("instance2", "cpu", 6, 10)
+	c.Uuid = "c"
+
+	require.NoError(t, manifest.Rebuild([]*MetaFile[int64]{&a.MetaFile}))
+	assert.Equal(t, 1, store.checkpoints)
+
+	require.NoError(t, manifest.Add(&b.MetaFile))
+	require.NoError(t, manifest.Add(&c.MetaFile))
+	assert.Len(t, store.records, 2)
+	assert.Equal(t, []string{"a", "b", "c"}, manifestUuids(manifest))
+
+	// the third edit checkpoints
+	b.Tombstones = append(b.Tombstones, Tombstone[int64]{PrimaryIdx: "instance1", Min: 1, Max: 2})
+	require.NoError(t, manifest.Update(&b.MetaFile))
+	assert.Equal(t, 2, store.checkpoints)
+	assert.Empty(t, store.records)
+	assert.Equal(t, uint64(3), store.checkpointVersion)
+
+	// tombstones added later to the fileblock aren't in the manifest until it's updated
+	b.Tombstones = append(b.Tombstones, Tombstone[int64]{PrimaryIdx: "instance1", Min: 3, Max: 4})
+	require.NoError(t, manifest.Remove(&a.MetaFile))
+
+	reopened, found, err := OpenManifest[int64](store, 3)
+	require.NoError(t, err)
+	require.True(t, found)
+	metas := reopened.Fileblocks()
+	require.Len(t, metas, 2)
+	assert.Equal(t, "b", metas[0].Uuid)
+	assert.Len(t, metas[0].Tombstones, 1)
+	assert.Equal(t, *b.Max, *metas[0].Max)
+	assert.Equal(t, b.Rows, metas[0].Rows)
+	assert.Equal(t, "c", metas[1].Uuid)
+
+	t.Run("Update of a removed fileblock", func(t *testing.T) {
+		require.NoError(t, reopened.Update(&a.MetaFile))
+		assert.Equal(t, []string{"b", "c"}, manifestUuids(reopened))
+	})
+
+	t.Run("Apply", func(t *testing.T) {
+		store := &mockManifestStore{}
+		manifest := NewManifest[int64](store, 100)
+		require.NoError(t, manifest.Rebuild(nil))
+		require.NoError(t, manifest.Apply(
+			ManifestEdit[int64]{Kind: ManifestEditAdd, Meta: a.MetaFile},
+			ManifestEdit[int64]{Kind: ManifestEditAdd, Meta: c.MetaFile},
+			ManifestEdit[int64]{Kind: ManifestEditRemove, Meta: MetaFile[int64]{Uuid: "a"}},
+		))
+		assert.Len(t, store.records, 1)
+
+		reopened, found, err := OpenManifest[int64](store, 100)
+		require.NoError(t, err)
+		require.True(t, found)
+		assert.Equal(t, []string{"c"}, manifestUuids(reopened))
+	})
+
+	t.Run("Replace", func(t *testing.T) {
+		store := &mockManifestStore{}
+		manifest := NewManifest[int64](store, 100)
+		require.NoError(t, manifest.Rebuild([]*MetaFile[int64]{&a.MetaFile}))
+		require.NoError(t, manifest.Replace(&b.MetaFile, &a.MetaFile))
+		assert.Len(t, store.records, 1)
+
+		reopened, found, err := OpenManifest[int64](store, 100)
+		require.NoError(t, err)
+		require.True(t, found)
+		assert.Equal(t, []string{"b"}, manifestUuids(reopened))
+	})
+
+	t.Run("AddedSinceCheckpoint", func(t *testing.T) {
+		store := &mockManifestStore{}
+		manifest := NewManifest[int64](store, 3)
+		require.NoError(t, manifest.Rebuild([]*MetaFile[int64]{&a.MetaFile}))
+		assert.Empty(t, manifest.AddedSinceCheckpoint())
+
+		require.NoError(t, manifest.Add(&b.MetaFile))
+		require.NoError(t, manifest.Add(&c.MetaFile))
+		require.NoError(t, manifest.Remove(&b.MetaFile))
+		assert.Empty(t, manifest.AddedSinceCheckpoint())
+
+		require.NoError(t, manifest.Add(&b.MetaFile))
+		reopened, found, err := OpenManifest[int64](store, 3)
+		require.NoError(t, err)
+		require.True(t, found)
+		metas := reopened.AddedSinceCheckpoint()
+		require.Len(t, metas, 1)
+		assert.Equal(t, "b", metas[0].Uuid)
+	})
+
+	t.Run("Failed append", func(t *testing.T) {
+		store := &mockManifestStore{}
+		manifest := NewManifest[int64](store, 100)
+		require.NoError(t, manifest.Rebuild(nil))
+
+		store.appendErr = errors.New("sync failed")
+		assert.ErrorIs(t, manifest.Add(&a.MetaFile), ErrManifestFailed)
+
+		// the record that was written can't be followed by another one with its version
+		store.appendErr = nil
+		assert.ErrorIs(t, manifest.Add(&b.MetaFile), ErrManifestFailed)
+		assert.ErrorIs(t, manifest.Checkpoint(), ErrManifestFailed)
+		assert.Len(t, store.records, 1)
+
+		reopened, found, err := OpenManifest[int64](store, 100)
+		require.NoError(t, err)
+		require.True(t, found)
+		assert.Equal(t, []string{"a"}, manifestUuids(reopened))
+	})
+
+	t.Run("Corrupt", func(t *testing.T) {
+		_, _, err := OpenManifest[int64](&mockManifestStore{checkpoint: []byte("{")}, 3)
+		assert.ErrorIs(t, err, ErrCorruptManifest)
+
+		// a record is missing
+		store := &mockManifestStore{}
+		manifest := NewManifest[int64](store, 100)
+		require.NoError(t, manifest.Rebuild(nil))
+		require.NoError(t, manifest.Add(&a.MetaFile))
+		require.NoError(t, manifest.Add(&b.MetaFile))
+		store.records = store.records[1:]
+		_, _, err = OpenManifest[int64](store, 100)
+		assert.ErrorIs(t, err, ErrCorruptManifest)
+	})
+}
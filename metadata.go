@@ -16,6 +16,16 @@ type MetaFile[O cmp.Ordered] struct {
 	Max        *O
 	Rows       []Row[O]
 
+	// Tombstones mask the values deleted after the fileblock was created, Load doesn't return them
+	Tombstones []Tombstone[O] `json:",omitempty"`
+
+	// WalLsn is the highest lsn of the persistent wal whose entries the fileblock holds, a tombstone
+	// replayed from the wal is only applied to fileblocks written before it
+	WalLsn uint64 `json:",omitempty"`
+
+	// Bloom holds the secondary indexes and labels of the rows, see MembershipFilter
+	Bloom *BloomFilter `json:",omitempty"`
+
 	DataFilepath string `json:"Datafile"`
 	MetaFilepath string `json:"Metafile"`
 }
@@ -25,12 +35,18 @@ type Row[O cmp.Ordered] struct {
 	ItemCount    int
 	Min          O
 	Max          O
+
+	// Labels are set for the series of Labeled entries, so that fileblocks can be pruned by them
+	Labels Labels `json:",omitempty"`
 }
 
 func (r *Row[O]) Merge(o *Row[O]) {
 	if r.SecondaryIdx == "" {
 		r.SecondaryIdx = o.SecondaryIdx
 	}
+	if r.Labels == nil {
+		r.Labels = o.Labels
+	}
 
 	r.ItemCount += o.ItemCount
 	if o.Min < r.Min {
@@ -42,6 +58,16 @@ func (r *Row[O]) Merge(o *Row[O]) {
 	}
 }
 
+// rowIndexer exposes a row to the filters as the series it describes
+type rowIndexer[O cmp.Ordered] struct {
+	pIdx string
+	row  *Row[O]
+}
+
+func (r *rowIndexer[O]) PrimaryIndex() string   { return r.pIdx }
+func (r *rowIndexer[O]) SecondaryIndex() string { return r.row.SecondaryIdx }
+func (r *rowIndexer[O]) Labels() Labels         { return r.row.Labels }
+
 func (m *MetaFile[O]) Metadata() *MetaFile[O] {
 	return m
 }
@@ -19,6 +19,10 @@ type MetadataBuilder[O cmp.Ordered] struct {
 	filenamePrefix string
 	fullFilepath   string
 	rootPath       string
+	// bloomKeys is the number of keys added to the bloom filter, it's rebuilt bigger when they
+	// exceed bloomCapacity
+	bloomKeys     int
+	bloomCapacity int
 
 	MetaFile[O]
 }
@@ -96,6 +100,14 @@ func (b *MetadataBuilder[O]) WithMin(m O) *MetadataBuilder[O] {
 	return b
 }
 
+func (b *MetadataBuilder[O]) WithWalLsn(lsn uint64) *MetadataBuilder[O] {
+	if lsn > b.WalLsn {
+		b.WalLsn = lsn
+	}
+
+	return b
+}
+
 func (b *MetadataBuilder[O]) WithEntry(e Entry[O]) *MetadataBuilder[O] {
 	if b.PrimaryIdx == "" {
 		b.PrimaryIdx = e.PrimaryIndex()
@@ -111,26 +123,53 @@ func (b *MetadataBuilder[O]) WithEntry(e Entry[O]) *MetadataBuilder[O] {
 	if b.Max == nil {
 		b.Max = new(O)
 		*b.Max = e.Max()
-	} else if e.Min() < *b.Max {
+	} else if e.Max() > *b.Max {
 		*b.Max = e.Max()
 	}
 
 	b.ItemCount += e.Len()
-	found := false
-	for _, row := range b.Rows {
-		if row.SecondaryIdx == e.SecondaryIndex() {
-			row.Merge(&row)
+	row := Row[O]{SecondaryIdx: e.SecondaryIndex(), Min: e.Min(), Max: e.Max(), ItemCount: e.Len()}
+	if labeled, ok := e.(Labeled); ok {
+		row.Labels = labeled.Labels()
+	}
+
+	for i := range b.Rows {
+		if b.Rows[i].SecondaryIdx == row.SecondaryIdx {
+			b.Rows[i].Merge(&row)
 			return b
 		}
 	}
-	if !found {
-		b.Rows = append(b.Rows,
-			Row[O]{SecondaryIdx: e.SecondaryIndex(), Min: e.Min(), Max: e.Max(), ItemCount: e.Len()})
-	}
+	b.Rows = append(b.Rows, row)
+	b.addToBloom(&row)
 
 	return b
 }
 
+// addToBloom adds the keys of a new row to the bloom filter. The filter is sized for twice the keys
+// it holds, and rebuilt from the rows when they don't fit.
+func (b *MetadataBuilder[O]) addToBloom(row *Row[O]) {
+	if b.cfg == nil || b.cfg.Metadata.BloomFalsePositiveRate <= 0 {
+		return
+	}
+
+	keys := rowKeys(row)
+	b.bloomKeys += len(keys)
+	if b.Bloom != nil && b.bloomKeys <= b.bloomCapacity {
+		for _, key := range keys {
+			b.Bloom.Add(key)
+		}
+		return
+	}
+
+	b.bloomCapacity = max(b.bloomKeys*2, 64)
+	b.Bloom = NewBloomFilter(b.bloomCapacity, b.cfg.Metadata.BloomFalsePositiveRate)
+	for i := range b.Rows {
+		for _, key := range rowKeys(&b.Rows[i]) {
+			b.Bloom.Add(key)
+		}
+	}
+}
+
 func (b *MetadataBuilder[O]) WithFilename(s string) *MetadataBuilder[O] {
 	b.Uuid = s
 	return b
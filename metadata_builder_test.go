@@ -32,3 +32,16 @@ func TestMetadataBuilder(t *testing.T) {
 	assert.Equal(t, int64(1), min)
 	assert.Equal(t, int64(3), max)
 }
+
+func TestMetadataBuilderWithEntry(t *testing.T) {
+	builder := NewMetadataBuilder[int64](&Config{MaxLevels: 5}).
+		WithEntry(NewKv("instance1", "cpu", []int64{1, 2, 3}, []int32{1, 2, 3})).
+		WithEntry(NewKv("instance1", "mem", []int64{5, 6}, []int32{5, 6}))
+	assert.Equal(t, int64(1), *builder.Min)
+	assert.Equal(t, int64(6), *builder.Max)
+
+	// an entry within the range doesn't move it
+	builder.WithEntry(NewKv("instance1", "disk", []int64{2}, []int32{2}))
+	assert.Equal(t, int64(1), *builder.Min)
+	assert.Equal(t, int64(6), *builder.Max)
+}
@@ -6,7 +6,9 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"slices"
 	"sort"
+	"unsafe"
 
 	db "github.com/sayden/streedb"
 	"github.com/spaolacci/murmur3"
@@ -34,6 +36,32 @@ func (m *MetricsEntry) Merge(a db.Entry[int64]) error {
 	return m.Append(a)
 }
 
+func (m *MetricsEntry) Clone() db.Entry[int64] {
+	return &MetricsEntry{
+		MetricName:     m.MetricName,
+		MetricCategory: m.MetricCategory,
+		Ts:             slices.Clone(m.Ts),
+		Val:            slices.Clone(m.Val),
+	}
+}
+
+func (m *MetricsEntry) DeleteRange(min, max int64) {
+	n := 0
+	for i, ts := range m.Ts {
+		if ts >= min && ts <= max {
+			continue
+		}
+		m.Ts[n] = ts
+		m.Val[n] = m.Val[i]
+		n++
+	}
+
+	m.Ts = m.Ts[:n]
+	m.Val = m.Val[:n]
+	m.min = nil
+	m.max = nil
+}
+
 func (m *MetricsEntry) Sort() {
 	if sort.IsSorted(m) {
 		return
@@ -51,13 +79,31 @@ func (m *MetricsEntry) Swap(i, j int) {
 	m.Val[i], m.Val[j] = m.Val[j], m.Val[i]
 }
 
+// Overlap returns the values between min and max, both included. When the values are sorted, the
+// result shares its memory with m instead of copying it.
 func (m *MetricsEntry) Overlap(min, max int64) (db.Entry[int64], bool) {
-	lMin := m.Min()
-	lMax := m.Max()
+	if m.Len() == 0 || m.Min() > max || m.Max() < min {
+		return nil, false
+	}
+	if m.Min() >= min && m.Max() <= max {
+		return m, true
+	}
 
-	isOverlapped := (lMin < max || lMin == max) &&
-		(min < lMax || min == lMax)
-	return m, isOverlapped
+	if sort.IsSorted(m) {
+		from := sort.Search(len(m.Ts), func(i int) bool { return m.Ts[i] >= min })
+		to := sort.Search(len(m.Ts), func(i int) bool { return m.Ts[i] > max })
+		return m.Slice(from, to), from < to
+	}
+
+	res := &MetricsEntry{MetricName: m.MetricName, MetricCategory: m.MetricCategory}
+	for i, ts := range m.Ts {
+		if ts >= min && ts <= max {
+			res.Ts = append(res.Ts, ts)
+			res.Val = append(res.Val, m.Val[i])
+		}
+	}
+
+	return res, res.Len() > 0
 }
 
 func (m *MetricsEntry) Append(a db.Entry[int64]) error {
@@ -135,10 +181,30 @@ func (m *MetricsEntry) SecondaryIndex() string {
 	return m.MetricName
 }
 
+func (m *MetricsEntry) Reverse() {
+	slices.Reverse(m.Ts)
+	slices.Reverse(m.Val)
+}
+
+func (m *MetricsEntry) Slice(from, to int) db.Entry[int64] {
+	// capped, so that appending to the result doesn't overwrite values of m
+	return &MetricsEntry{MetricName: m.MetricName, MetricCategory: m.MetricCategory, Ts: m.Ts[from:to:to], Val: m.Val[from:to:to]}
+}
+
+// Sample implements db.Sampler
+func (m *MetricsEntry) Sample(i int) (int64, float64) {
+	return m.Ts[i], m.Val[i]
+}
+
 func (m *MetricsEntry) Len() int {
 	return len(m.Val)
 }
 
+// SizeBytes implements db.Sizer. Slices are accounted by capacity, which is what they hold in memory
+func (m *MetricsEntry) SizeBytes() int {
+	return int(unsafe.Sizeof(*m)) + len(m.MetricName) + len(m.MetricCategory) + cap(m.Ts)*8 + cap(m.Val)*8
+}
+
 func (m *MetricsEntry) UUID() string {
 	pIdx := m.PrimaryIndex()
 	sIdx := m.SecondaryIndex()
@@ -2,6 +2,7 @@ package metrics
 
 import (
 	"cmp"
+	"context"
 	"path"
 	"time"
 
@@ -53,7 +54,7 @@ func (m *LSMMetrics[O, E]) Append(d db.Entry[O]) error {
 	return m.db.Append(d)
 }
 
-func (m *LSMMetrics[O, E]) Find(pIdx, sIdx string, min, max O) (db.EntryIterator[O], bool, error) {
+func (m *LSMMetrics[O, E]) Find(ctx context.Context, pIdx, sIdx string, min, max O) (db.EntryIterator[O], bool, error) {
 	now := time.Now()
 	defer func() {
 		elapsed := time.Since(now)
@@ -67,7 +68,40 @@ func (m *LSMMetrics[O, E]) Find(pIdx, sIdx string, min, max O) (db.EntryIterator
 		}
 	}()
 
-	return m.db.Find(pIdx, sIdx, min, max)
+	return m.db.Find(ctx, pIdx, sIdx, min, max)
+}
+
+func (m *LSMMetrics[O, E]) FindMatching(ctx context.Context, min, max O, filters ...db.EntryFilter) (db.EntryIterator[O], bool, error) {
+	now := time.Now()
+	defer func() {
+		elapsed := time.Since(now)
+		log.Debug().Fields(map[string]interface{}{
+			"elapsed": elapsed,
+			"filters": len(filters)}).
+			Msg("FindMatching")
+		if err := m.Metrics.Append(NewMetric("find_matching", "elapsed_nano", time.Now().UnixMilli(), float64(elapsed.Nanoseconds()))); err != nil {
+			log.Err(err).Msg("Failed to append metric")
+		}
+	}()
+
+	return m.db.FindMatching(ctx, min, max, filters...)
+}
+
+func (m *LSMMetrics[O, E]) FindPage(ctx context.Context, pIdx, sIdx string, min, max O, limit int, cursor string) ([]db.Entry[O], string, error) {
+	now := time.Now()
+	defer func() {
+		elapsed := time.Since(now)
+		log.Debug().Fields(map[string]interface{}{
+			"elapsed":      elapsed,
+			"primaryIdx":   pIdx,
+			"secondaryIdx": sIdx}).
+			Msg("FindPage")
+		if err := m.Metrics.Append(NewMetric("find_page", "elapsed_nano", time.Now().UnixMilli(), float64(elapsed.Nanoseconds()))); err != nil {
+			log.Err(err).Msg("Failed to append metric")
+		}
+	}()
+
+	return m.db.FindPage(ctx, pIdx, sIdx, min, max, limit, cursor)
 }
 
 func (m *LSMMetrics[O, E]) Close() error {
@@ -103,6 +137,6 @@ func (m *LSMMetrics[O, E]) Compact() error {
 	return m.db.Compact()
 }
 
-func (m *LSMMetrics[O, E]) GetMetrics() (db.EntryIterator[int64], bool, error) {
-	return m.Metrics.Find("", "", 0, time.Now().UnixMilli()+10000)
+func (m *LSMMetrics[O, E]) GetMetrics(ctx context.Context) (db.EntryIterator[int64], bool, error) {
+	return m.Metrics.Find(ctx, "", "", 0, time.Now().UnixMilli()+10000)
 }
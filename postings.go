@@ -0,0 +1,199 @@
+package streedb
+
+import (
+	"cmp"
+)
+
+// seriesID identifies a series in a PostingsIndex
+type seriesID uint64
+
+type seriesSet map[seriesID]struct{}
+
+// PostingsIndex maps every label=value to the series that have it, and every series to the
+// fileblocks that hold its values. It's built from the labels in the rows of the metadata. It isn't
+// safe for concurrent use, like BtreeIndex.
+type PostingsIndex[O cmp.Ordered] struct {
+	// postings holds the series of every value of every label
+	postings map[string]map[string]seriesSet
+	ids      map[string]seriesID
+	series   map[seriesID]*postingsSeries[O]
+	nextID   seriesID
+	// unindexed holds the fileblocks without rows or with unlabeled rows by their uuid, their
+	// series can't be told apart by the postings
+	unindexed map[string]*Fileblock[O]
+}
+
+type postingsSeries[O cmp.Ordered] struct {
+	key    string
+	labels Labels
+	// fileblocks are keyed by their uuid
+	fileblocks map[string]*Fileblock[O]
+}
+
+func NewPostingsIndex[O cmp.Ordered]() *PostingsIndex[O] {
+	return &PostingsIndex[O]{
+		postings:  make(map[string]map[string]seriesSet),
+		ids:       make(map[string]seriesID),
+		series:    make(map[seriesID]*postingsSeries[O]),
+		unindexed: make(map[string]*Fileblock[O]),
+	}
+}
+
+func postingsKey(pIdx, sIdx string) string {
+	return pIdx + "\x00" + sIdx
+}
+
+// Add indexes the labeled series of the fileblock
+func (p *PostingsIndex[O]) Add(fb *Fileblock[O]) {
+	if len(fb.Rows) == 0 {
+		p.unindexed[fb.Uuid] = fb
+	}
+
+	for _, row := range fb.Rows {
+		if len(row.Labels) == 0 {
+			p.unindexed[fb.Uuid] = fb
+			continue
+		}
+
+		key := postingsKey(fb.PrimaryIdx, row.SecondaryIdx)
+		id, found := p.ids[key]
+		if !found {
+			id = p.nextID
+			p.nextID++
+			p.ids[key] = id
+			p.series[id] = &postingsSeries[O]{key: key, labels: row.Labels, fileblocks: make(map[string]*Fileblock[O])}
+
+			for _, label := range row.Labels {
+				values, found := p.postings[label.Name]
+				if !found {
+					values = make(map[string]seriesSet)
+					p.postings[label.Name] = values
+				}
+				if values[label.Value] == nil {
+					values[label.Value] = make(seriesSet)
+				}
+				values[label.Value][id] = struct{}{}
+			}
+		}
+
+		p.series[id].fileblocks[fb.Uuid] = fb
+	}
+}
+
+// Remove drops the fileblock, and the series that are left without fileblocks
+func (p *PostingsIndex[O]) Remove(fb *Fileblock[O]) {
+	delete(p.unindexed, fb.Uuid)
+
+	for _, row := range fb.Rows {
+		key := postingsKey(fb.PrimaryIdx, row.SecondaryIdx)
+		id, found := p.ids[key]
+		if !found {
+			continue
+		}
+
+		series := p.series[id]
+		delete(series.fileblocks, fb.Uuid)
+		if len(series.fileblocks) > 0 {
+			continue
+		}
+
+		delete(p.ids, key)
+		delete(p.series, id)
+		for _, label := range series.labels {
+			values := p.postings[label.Name]
+			delete(values[label.Value], id)
+			if len(values[label.Value]) == 0 {
+				delete(values, label.Value)
+			}
+			if len(values) == 0 {
+				delete(p.postings, label.Name)
+			}
+		}
+	}
+}
+
+// Fileblocks returns the fileblocks holding series that can pass the label filters, and false if
+// the postings can't narrow them down. A label filter narrows them down when it doesn't match a
+// missing label, every series it can match has one of the values it matches. The rest only remove
+// series from the result. Fileblocks the postings can't tell apart are always returned, like in
+// Fileblock.Matches.
+func (p *PostingsIndex[O]) Fileblocks(filters ...EntryFilter) ([]*Fileblock[O], bool) {
+	var candidates seriesSet
+	excluding := make([]LabelMatcher, 0)
+
+	for _, filter := range filters {
+		matcher, ok := filter.(LabelMatcher)
+		if !ok {
+			continue
+		}
+		if matcher.MatchesValue("") {
+			excluding = append(excluding, matcher)
+			continue
+		}
+
+		matching := p.matching(matcher, true)
+		if candidates == nil {
+			candidates = matching
+		} else {
+			candidates = intersect(candidates, matching)
+		}
+	}
+
+	if candidates == nil {
+		return nil, false
+	}
+
+	for _, matcher := range excluding {
+		for id := range p.matching(matcher, false) {
+			delete(candidates, id)
+		}
+	}
+
+	fileblocks := make(map[string]*Fileblock[O], len(p.unindexed))
+	for uuid, fb := range p.unindexed {
+		fileblocks[uuid] = fb
+	}
+	for id := range candidates {
+		for uuid, fb := range p.series[id].fileblocks {
+			fileblocks[uuid] = fb
+		}
+	}
+
+	res := make([]*Fileblock[O], 0, len(fileblocks))
+	for _, fb := range fileblocks {
+		res = append(res, fb)
+	}
+
+	return res, true
+}
+
+// matching returns the series with a value of the label for which the matcher returns want
+func (p *PostingsIndex[O]) matching(matcher LabelMatcher, want bool) seriesSet {
+	res := make(seriesSet)
+	for value, series := range p.postings[matcher.Label()] {
+		if matcher.MatchesValue(value) != want {
+			continue
+		}
+		for id := range series {
+			res[id] = struct{}{}
+		}
+	}
+
+	return res
+}
+
+// intersect returns the series in both sets, iterating the smallest one
+func intersect(a, b seriesSet) seriesSet {
+	if len(b) < len(a) {
+		a, b = b, a
+	}
+
+	res := make(seriesSet, len(a))
+	for id := range a {
+		if _, found := b[id]; found {
+			res[id] = struct{}{}
+		}
+	}
+
+	return res
+}
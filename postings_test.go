@@ -0,0 +1,90 @@
+package streedb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func labeledFileblock(t *testing.T, pIdx string, min, max int64, series ...map[string]string) *Fileblock[int64] {
+	builder := NewMetadataBuilder[int64](NewDefaultConfig())
+	for _, labels := range series {
+		l, err := NewLabels(labels)
+		require.NoError(t, err)
+		builder.WithEntry(NewLabeledEntry(pIdx, l, []int64{min, max}, []float64{0, 0}))
+	}
+
+	return NewFileblock(NewDefaultConfig(), &builder.MetaFile, nil)
+}
+
+func TestPostingsIndex(t *testing.T) {
+	eu1 := map[string]string{"host": "a", "region": "eu"}
+	eu2 := map[string]string{"host": "b", "region": "eu"}
+	us := map[string]string{"host": "c", "region": "us"}
+	noRegion := map[string]string{"host": "d"}
+
+	first := labeledFileblock(t, "cpu", 1, 5, eu1, us)
+	second := labeledFileblock(t, "cpu", 6, 10, eu2, noRegion)
+	third := labeledFileblock(t, "cpu", 11, 15, us)
+	unlabeled := createMockFileblock("cpu", "user", 1, 5)
+
+	postings := NewPostingsIndex[int64]()
+	for _, fb := range []*Fileblock[int64]{first, second, third, unlabeled} {
+		postings.Add(fb)
+	}
+
+	filter := func(name, expr string) EntryFilter {
+		f, err := LabelFilter(name, expr)
+		require.NoError(t, err)
+		return f
+	}
+	find := func(filters ...EntryFilter) []*Fileblock[int64] {
+		blocks, ok := postings.Fileblocks(filters...)
+		require.True(t, ok)
+		return blocks
+	}
+
+	// the postings can't tell apart the series of unlabeled rows, they are always returned
+	assert.ElementsMatch(t, []*Fileblock[int64]{first, second, unlabeled}, find(filter("region", "eu")))
+	assert.ElementsMatch(t, []*Fileblock[int64]{first, third, unlabeled}, find(filter("region", "us")))
+	assert.ElementsMatch(t, []*Fileblock[int64]{first, unlabeled}, find(filter("region", "eu"), filter("host", "glob:[ac]")))
+	assert.ElementsMatch(t, []*Fileblock[int64]{second, unlabeled}, find(filter("host", "regex:^[a-d]$"), filter("region", "!regex:eu|us")))
+	assert.ElementsMatch(t, []*Fileblock[int64]{second, unlabeled}, find(filter("region", "eu"), filter("host", "!a")))
+	assert.ElementsMatch(t, []*Fileblock[int64]{unlabeled}, find(filter("region", "asia")))
+
+	// filters matching a missing label can't narrow down the series
+	_, ok := postings.Fileblocks(filter("region", "!eu"), PrimaryIndexFilter("cpu"))
+	assert.False(t, ok)
+
+	t.Run("Remove", func(t *testing.T) {
+		postings.Remove(first)
+		assert.ElementsMatch(t, []*Fileblock[int64]{third, unlabeled}, find(filter("region", "us")))
+		assert.ElementsMatch(t, []*Fileblock[int64]{unlabeled}, find(filter("host", "a")))
+
+		postings.Remove(third)
+		assert.ElementsMatch(t, []*Fileblock[int64]{unlabeled}, find(filter("region", "us")))
+		assert.NotContains(t, postings.postings["region"], "us")
+		assert.Len(t, postings.series, 2)
+
+		postings.Remove(unlabeled)
+		assert.Len(t, postings.series, 2)
+		assert.Empty(t, find(filter("region", "us")))
+	})
+
+	t.Run("WithoutRows", func(t *testing.T) {
+		rowless := createMockFileblock("cpu", "user", 1, 5)
+		rowless.Rows = nil
+		mixed := labeledFileblock(t, "cpu", 1, 5, eu1)
+		mixed.Rows = append(mixed.Rows, Row[int64]{SecondaryIdx: "user", Min: 1, Max: 5, ItemCount: 2})
+
+		postings.Add(rowless)
+		postings.Add(mixed)
+		assert.ElementsMatch(t, []*Fileblock[int64]{rowless, mixed}, find(filter("region", "us")))
+		assert.ElementsMatch(t, []*Fileblock[int64]{second, rowless, mixed}, find(filter("region", "eu")))
+
+		postings.Remove(rowless)
+		postings.Remove(mixed)
+		assert.Empty(t, find(filter("region", "us")))
+	})
+}
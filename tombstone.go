@@ -0,0 +1,48 @@
+package streedb
+
+import (
+	"cmp"
+	"errors"
+)
+
+var ErrInvalidTombstone = errors.New("a tombstone needs a primary index and min <= max")
+
+// Tombstone marks the values between Min and Max, both included, of a primary index as deleted. An
+// empty SecondaryIdx deletes the values of every secondary index of the primary index.
+type Tombstone[O cmp.Ordered] struct {
+	PrimaryIdx   string
+	SecondaryIdx string
+	Min          O
+	Max          O
+}
+
+func NewTombstone[O cmp.Ordered](pIdx, sIdx string, min, max O) (Tombstone[O], error) {
+	t := Tombstone[O]{PrimaryIdx: pIdx, SecondaryIdx: sIdx, Min: min, Max: max}
+	if pIdx == "" || max < min {
+		return t, ErrInvalidTombstone
+	}
+
+	return t, nil
+}
+
+// Matches tells if the tombstone applies to the series of an entry
+func (t Tombstone[O]) Matches(i Indexer) bool {
+	return t.PrimaryIdx == i.PrimaryIndex() && (t.SecondaryIdx == "" || t.SecondaryIdx == i.SecondaryIndex())
+}
+
+// Overlaps tells if the tombstone deletes any value between min and max
+func (t Tombstone[O]) Overlaps(min, max O) bool {
+	return t.Min <= max && min <= t.Max
+}
+
+// ApplyTombstones deletes the values masked by the tombstones from the entry and returns false if the
+// entry is left empty
+func ApplyTombstones[O cmp.Ordered](e Entry[O], ts ...Tombstone[O]) bool {
+	for _, t := range ts {
+		if t.Matches(e) {
+			e.DeleteRange(t.Min, t.Max)
+		}
+	}
+
+	return e.Len() > 0
+}
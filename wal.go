@@ -1,10 +1,51 @@
 package streedb
 
-import "cmp"
+import (
+	"cmp"
+	"errors"
+)
+
+// ErrWriteStall is returned by appends when WalCfg.FailOnWriteStall is set and too many flushes are
+// pending
+var ErrWriteStall = errors.New("write stall: too many pending wal flushes")
+
+type WalSyncMode int
+
+const (
+	// WAL_SYNC_ALWAYS fsyncs the log before every append returns. Concurrent appends share one fsync
+	WAL_SYNC_ALWAYS WalSyncMode = iota
+
+	// WAL_SYNC_BATCH fsyncs the log every WalCfg.SyncIntervalMs or once WalCfg.SyncBytes are pending.
+	// Appends wait for the batch that contains them
+	WAL_SYNC_BATCH
+
+	// WAL_SYNC_NONE leaves the writes in the OS buffers, appends return as soon as they are written
+	WAL_SYNC_NONE
+)
+
+// WalReader finds copies of the entries in the wal
+type WalReader[O cmp.Ordered] interface {
+	Find(pIdx string, sIdx string, min, max O) (EntryIterator[O], bool)
+	// FindMatching returns the entries with values between min and max of the series that pass every
+	// filter
+	FindMatching(min, max O, filters ...EntryFilter) (EntryIterator[O], bool)
+	// FindSeries returns every value of the matching series, whatever their range
+	FindSeries(pIdx string, sIdx string) (EntryIterator[O], bool)
+}
 
 type Wal[O cmp.Ordered] interface {
+	WalReader[O]
 	Append(d Entry[O]) error
-	Find(pIdx string, sIdx string, min, max O) (EntryIterator[O], bool)
+	// View calls onView while no entry can be flushed, with a reader of the wal. Whatever onView
+	// finds in the fileblocks is consistent with what it finds in the wal: an entry is in one or the
+	// other, never in both
+	View(onView func(WalReader[O]) error) error
+	// Snapshot returns a copy of every entry in the wal. onSnapshot is called while no entry can be
+	// flushed, so that whatever it captures is consistent with the returned entries
+	Snapshot(onSnapshot func()) []Entry[O]
+	// Delete removes the values masked by the tombstone. onDelete is called while no entry can be
+	// flushed, to record the tombstone in the fileblocks
+	Delete(t Tombstone[O], onDelete func() error) error
 	Close() error
 }
 